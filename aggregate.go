@@ -0,0 +1,800 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type OrgLoad struct {
+	Org   string  `json:"org" bson:"_id"`
+	Count int     `json:"count" bson:"count"`
+	Pct   float64 `json:"pct" bson:"-"`
+	Load  string  `json:"load" bson:"-"`
+}
+
+// timestampMatch builds a filter on properties.timestamp for the inclusive
+// [start, end] date range. Empty bounds are left open.
+func timestampMatch(start, end string) bson.M {
+	rng := timestampRange(start, end)
+	if rng == nil {
+		return bson.M{}
+	}
+	return bson.M{"properties.timestamp": rng}
+}
+
+// storedTimestampLayout is the prefix of upstreamTimestampLayout down to
+// the second. Stored timestamps are strings in Bangkok time, so bounds
+// formatted this way compare correctly as strings.
+const storedTimestampLayout = "2006-01-02 15:04:05"
+
+// rangeBounds converts an inclusive [start, end] range into half-open
+// [lower, upper) times. A date-only end covers the whole day; an end with a
+// time covers up to the end of that second. Empty or invalid inputs give a
+// zero time, meaning that side is unbounded.
+func rangeBounds(start, end string) (lower, upper time.Time) {
+	if start != "" {
+		if startTime, err := parseDate(start); err == nil {
+			lower = startTime
+		}
+	}
+	if end != "" {
+		if endTime, err := parseDate(end); err == nil {
+			if isDateOnly(end) {
+				upper = endTime.AddDate(0, 0, 1)
+			} else {
+				upper = endTime.Add(time.Second)
+			}
+		}
+	}
+	return lower, upper
+}
+
+// timestampRange returns the $gte/$lt bounds for an inclusive [start, end]
+// range, or nil when both are empty. Bounds are converted to Bangkok time
+// first, so offsets in the input are honoured.
+func timestampRange(start, end string) bson.M {
+	lower, upper := rangeBounds(start, end)
+	rng := bson.M{}
+	if !lower.IsZero() {
+		rng["$gte"] = lower.In(bangkokTime).Format(storedTimestampLayout)
+	}
+	if !upper.IsZero() {
+		rng["$lt"] = upper.In(bangkokTime).Format(storedTimestampLayout)
+	}
+	if len(rng) == 0 {
+		return nil
+	}
+	return rng
+}
+
+func aggregateOrgLoad(ctx context.Context, start, end string) ([]OrgLoad, error) {
+	ctx, cancel := withMongoTimeout(ctx, mongoOpTimeout)
+	defer cancel()
+
+	pipeline := []bson.M{
+		{"$match": timestampMatch(start, end)},
+		{"$unwind": "$properties.org"},
+		{"$group": bson.M{"_id": "$properties.org", "count": bson.M{"$sum": 1}}},
+		{"$sort": bson.M{"count": -1}},
+	}
+
+	cursor, err := postsCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	loads := []OrgLoad{}
+	if err := cursor.All(ctx, &loads); err != nil {
+		return nil, err
+	}
+
+	total := 0
+	for _, l := range loads {
+		total += l.Count
+	}
+
+	n := len(loads)
+	for i := range loads {
+		if total > 0 {
+			loads[i].Pct = float64(loads[i].Count) * 100 / float64(total)
+		}
+		switch {
+		case i*3 < n:
+			loads[i].Load = "high"
+		case i*3 < 2*n:
+			loads[i].Load = "medium"
+		default:
+			loads[i].Load = "low"
+		}
+	}
+
+	return loads, nil
+}
+
+// giniCoefficient measures how unevenly complaints are spread across orgs:
+// 0 means every org has the same count, values near 1 mean one org has them all.
+func giniCoefficient(loads []OrgLoad) float64 {
+	counts := make([]int, len(loads))
+	total := 0
+	for i, l := range loads {
+		counts[i] = l.Count
+		total += l.Count
+	}
+	if len(counts) == 0 || total == 0 {
+		return 0
+	}
+
+	sort.Ints(counts)
+
+	n := float64(len(counts))
+	weighted := 0.0
+	for i, c := range counts {
+		weighted += float64(i+1) * float64(c)
+	}
+
+	return 2*weighted/(n*float64(total)) - (n+1)/n
+}
+
+type GeocodingCoverage struct {
+	Total                 int     `json:"total" bson:"total"`
+	HasCoordinates        int     `json:"has_coordinates" bson:"has_coordinates"`
+	HasAddress            int     `json:"has_address" bson:"has_address"`
+	Both                  int     `json:"both" bson:"both"`
+	Neither               int     `json:"neither" bson:"neither"`
+	SuspiciousCoordinates int     `json:"suspicious_coordinates" bson:"suspicious_coordinates"`
+	PctLocatable          float64 `json:"pct_locatable" bson:"-"`
+}
+
+// Bangkok bounding box used to flag coordinates that are present but implausible.
+const (
+	bangkokMinLng = 100.3
+	bangkokMaxLng = 101.0
+	bangkokMinLat = 13.5
+	bangkokMaxLat = 14.0
+)
+
+func countIf(cond interface{}) bson.M {
+	return bson.M{"$sum": bson.M{"$cond": bson.A{cond, 1, 0}}}
+}
+
+func aggregateGeocodingCoverage(ctx context.Context, start, end string) (GeocodingCoverage, error) {
+	ctx, cancel := withMongoTimeout(ctx, mongoOpTimeout)
+	defer cancel()
+
+	outsideBangkok := bson.M{"$or": bson.A{
+		bson.M{"$lt": bson.A{"$lng", bangkokMinLng}},
+		bson.M{"$gt": bson.A{"$lng", bangkokMaxLng}},
+		bson.M{"$lt": bson.A{"$lat", bangkokMinLat}},
+		bson.M{"$gt": bson.A{"$lat", bangkokMaxLat}},
+	}}
+
+	pipeline := []bson.M{
+		{"$match": timestampMatch(start, end)},
+		{"$project": bson.M{
+			"has_coordinates": bson.M{"$eq": bson.A{bson.M{"$size": bson.M{"$ifNull": bson.A{"$geometry.coordinates", bson.A{}}}}, 2}},
+			"has_address":     bson.M{"$gt": bson.A{bson.M{"$strLenCP": bson.M{"$ifNull": bson.A{"$properties.address", ""}}}, 0}},
+			"lng":             bson.M{"$arrayElemAt": bson.A{"$geometry.coordinates", 0}},
+			"lat":             bson.M{"$arrayElemAt": bson.A{"$geometry.coordinates", 1}},
+		}},
+		{"$group": bson.M{
+			"_id":             nil,
+			"total":           bson.M{"$sum": 1},
+			"has_coordinates": countIf("$has_coordinates"),
+			"has_address":     countIf("$has_address"),
+			"both":            countIf(bson.M{"$and": bson.A{"$has_coordinates", "$has_address"}}),
+			"neither": countIf(bson.M{"$and": bson.A{
+				bson.M{"$not": bson.A{"$has_coordinates"}},
+				bson.M{"$not": bson.A{"$has_address"}},
+			}}),
+			"suspicious_coordinates": countIf(bson.M{"$and": bson.A{"$has_coordinates", outsideBangkok}}),
+		}},
+	}
+
+	var coverage GeocodingCoverage
+
+	cursor, err := postsCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return coverage, err
+	}
+	defer cursor.Close(ctx)
+
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&coverage); err != nil {
+			return coverage, err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return coverage, err
+	}
+
+	if coverage.Total > 0 {
+		coverage.PctLocatable = float64(coverage.Total-coverage.Neither) / float64(coverage.Total)
+	}
+
+	return coverage, nil
+}
+
+// upstreamTimestampLayout matches the timestamp and last_activity strings
+// returned by the Traffy API, e.g. "2023-03-31 23:41:30.000000+0700".
+const upstreamTimestampLayout = "2006-01-02 15:04:05.999999-0700"
+
+type ReopenStreak struct {
+	TicketID       string `json:"ticket_id"`
+	Streak         int    `json:"streak"`
+	District       string `json:"district"`
+	ProblemType    string `json:"problem_type"`
+	DaysUnresolved int    `json:"days_unresolved"`
+}
+
+// aggregateReopenStreaks lists unfinished tickets reopened at least
+// minStreak times. No per-event reopen history is stored, so the streak is
+// the ticket's count_reopen. The upstream API only reports that counter,
+// and it increments on every reopen. Every reopen follows a resolution, so
+// the reopens of one ticket always form a single run.
+//
+// Days unresolved run from the report to now, or to last_activity for a
+// ticket closed as irrelevant.
+func aggregateReopenStreaks(ctx context.Context, start, end string, minStreak int) ([]ReopenStreak, error) {
+	ctx, cancel := withMongoTimeout(ctx, mongoOpTimeout)
+	defer cancel()
+
+	match := timestampMatch(start, end)
+	match["properties.count_reopen"] = bson.M{"$gte": minStreak}
+	match["properties.state"] = bson.M{"$ne": "finish"}
+
+	pipeline := []bson.M{
+		{"$match": match},
+		{"$sort": bson.M{"properties.count_reopen": -1}},
+	}
+
+	cursor, err := postsCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	var features []Feature
+	if err := cursor.All(ctx, &features); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	streaks := make([]ReopenStreak, 0, len(features))
+	for _, f := range features {
+		streak := ReopenStreak{
+			TicketID: f.Properties.TicketID,
+			Streak:   f.Properties.CountReopen,
+			District: f.Properties.District,
+		}
+		if len(f.Properties.ProblemTypeFondue) > 0 {
+			streak.ProblemType = f.Properties.ProblemTypeFondue[0]
+		}
+		resolved := now
+		if slices.Contains(closedStates, f.Properties.State) {
+			if closed, err := time.Parse(upstreamTimestampLayout, f.Properties.LastActivity); err == nil {
+				resolved = closed
+			}
+		}
+		if opened, err := time.Parse(upstreamTimestampLayout, f.Properties.Timestamp); err == nil {
+			streak.DaysUnresolved = int(resolved.Sub(opened).Hours() / 24)
+		}
+		streaks = append(streaks, streak)
+	}
+
+	return streaks, nil
+}
+
+type ProblemTypeResolution struct {
+	District       string  `json:"district" bson:"district"`
+	ProblemType    string  `json:"problem_type" bson:"problem_type"`
+	Total          int     `json:"total" bson:"total"`
+	Resolved       int     `json:"resolved" bson:"resolved"`
+	ResolutionRate float64 `json:"resolution_rate" bson:"resolution_rate"`
+}
+
+func aggregateProblemTypeResolution(ctx context.Context, start, end string, minTickets int) ([]ProblemTypeResolution, error) {
+	ctx, cancel := withMongoTimeout(ctx, mongoOpTimeout)
+	defer cancel()
+
+	pipeline := []bson.M{
+		{"$match": timestampMatch(start, end)},
+		{"$unwind": "$properties.problem_type_fondue"},
+		{"$group": bson.M{
+			"_id": bson.M{
+				"district":     "$properties.district",
+				"problem_type": "$properties.problem_type_fondue",
+			},
+			"total":    bson.M{"$sum": 1},
+			"resolved": countIf(bson.M{"$eq": bson.A{"$properties.state", "finish"}}),
+		}},
+		{"$match": bson.M{"total": bson.M{"$gte": minTickets}}},
+		{"$project": bson.M{
+			"_id":             0,
+			"district":        "$_id.district",
+			"problem_type":    "$_id.problem_type",
+			"total":           1,
+			"resolved":        1,
+			"resolution_rate": bson.M{"$divide": bson.A{"$resolved", "$total"}},
+		}},
+		{"$sort": bson.D{{Key: "district", Value: 1}, {Key: "problem_type", Value: 1}}},
+	}
+
+	cursor, err := postsCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []ProblemTypeResolution{}
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// aggregateSumState counts stored documents of either schema per state and
+// maps the counts onto the SumState fields the upstream API returns. No
+// matches yields a zeroed SumState.
+func aggregateSumState(ctx context.Context, start, end string) (SumState, error) {
+	ctx, cancel := withMongoTimeout(ctx, mongoOpTimeout)
+	defer cancel()
+
+	pipeline := []bson.M{
+		{"$match": ComplaintFilter{Start: start, End: end}.anySchema()},
+		{"$group": bson.M{
+			"_id":   bson.M{"$ifNull": bson.A{"$properties.state", "$state"}},
+			"count": bson.M{"$sum": 1},
+		}},
+	}
+
+	var sum SumState
+
+	cursor, err := postsCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return sum, err
+	}
+
+	var counts []struct {
+		State string `bson:"_id"`
+		Count int    `bson:"count"`
+	}
+	if err := cursor.All(ctx, &counts); err != nil {
+		return sum, err
+	}
+
+	for _, c := range counts {
+		sum.add(c.State, c.Count)
+	}
+
+	return sum, nil
+}
+
+// add maps a stored state value onto its SumState field. Unknown states are
+// ignored.
+func (s *SumState) add(state string, count int) {
+	switch state {
+	case "finish":
+		s.Finish += count
+	case "follow":
+		s.Follow += count
+	case "forward":
+		s.Forward += count
+	case "inprogress":
+		s.InProgress += count
+	case "irrelevant":
+		s.Irrelevant += count
+	case "start":
+		s.Start += count
+	}
+}
+
+type DistrictCount struct {
+	District string `json:"district" bson:"_id"`
+	Count    int    `json:"count" bson:"count"`
+}
+
+var districtStatsCache = newTTLCache[[]DistrictCount](2 * time.Minute)
+
+// aggregateByDistrict counts stored documents of either schema per district,
+// busiest first.
+func aggregateByDistrict(ctx context.Context, start, end string) ([]DistrictCount, error) {
+	ctx, cancel := withMongoTimeout(ctx, mongoOpTimeout)
+	defer cancel()
+
+	cacheKey := start + "|" + end
+	if counts, ok := districtStatsCache.Get(cacheKey); ok {
+		return counts, nil
+	}
+
+	pipeline := []bson.M{
+		{"$match": ComplaintFilter{Start: start, End: end}.anySchema()},
+		{"$group": bson.M{
+			"_id":   bson.M{"$ifNull": bson.A{"$properties.district", "$district"}},
+			"count": bson.M{"$sum": 1},
+		}},
+		{"$sort": bson.M{"count": -1}},
+	}
+
+	cursor, err := postsCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := []DistrictCount{}
+	if err := cursor.All(ctx, &counts); err != nil {
+		return nil, err
+	}
+
+	districtStatsCache.Set(cacheKey, counts)
+
+	return counts, nil
+}
+
+type TimeBucketCount struct {
+	Date  string `json:"date" bson:"_id"`
+	Count int    `json:"count" bson:"count"`
+}
+
+// timeBuckets lists the bucket sizes /statistics/timeseries accepts.
+var timeBuckets = []string{"day", "week", "month"}
+
+func isValidTimeBucket(bucket string) bool {
+	for _, b := range timeBuckets {
+		if b == bucket {
+			return true
+		}
+	}
+	return false
+}
+
+// aggregateTimeseries counts stored documents of either schema per day, ISO
+// week (keyed by its Monday) or month (keyed by its first day), oldest first.
+// Only the date part of the stored timestamp is parsed, so buckets follow the
+// local date the upstream API reports.
+func aggregateTimeseries(ctx context.Context, start, end, bucket string) ([]TimeBucketCount, error) {
+	ctx, cancel := withMongoTimeout(ctx, mongoOpTimeout)
+	defer cancel()
+
+	day := bson.M{"$dateFromString": bson.M{
+		"dateString": bson.M{"$substrCP": bson.A{bson.M{"$ifNull": bson.A{"$properties.timestamp", "$timestamp"}}, 0, 10}},
+		"format":     "%Y-%m-%d",
+		"onError":    nil,
+		"onNull":     nil,
+	}}
+
+	key := bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$day"}}
+	switch bucket {
+	case "week":
+		monday := bson.M{"$subtract": bson.A{
+			"$day",
+			bson.M{"$multiply": bson.A{bson.M{"$subtract": bson.A{bson.M{"$isoDayOfWeek": "$day"}, 1}}, 24 * 60 * 60 * 1000}},
+		}}
+		key = bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": monday}}
+	case "month":
+		key = bson.M{"$dateToString": bson.M{"format": "%Y-%m-01", "date": "$day"}}
+	}
+
+	pipeline := []bson.M{
+		{"$match": ComplaintFilter{Start: start, End: end}.anySchema()},
+		{"$addFields": bson.M{"day": day}},
+		{"$match": bson.M{"day": bson.M{"$ne": nil}}},
+		{"$group": bson.M{"_id": key, "count": bson.M{"$sum": 1}}},
+		{"$sort": bson.M{"_id": 1}},
+	}
+
+	cursor, err := postsCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := []TimeBucketCount{}
+	if err := cursor.All(ctx, &counts); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// maxTopProblems caps the limit of /complaints/top-problems.
+const maxTopProblems = 50
+
+type ProblemTypeCount struct {
+	ProblemType string `json:"problem_type" bson:"_id"`
+	Count       int    `json:"count" bson:"count"`
+}
+
+// aggregateTopProblems returns the limit most frequent problem types. Tags
+// are counted per spelling in MongoDB, then merged under their canonical
+// name here, so documents stored before normalization still count towards
+// the right category.
+func aggregateTopProblems(ctx context.Context, start, end string, limit int) ([]ProblemTypeCount, error) {
+	ctx, cancel := withMongoTimeout(ctx, mongoOpTimeout)
+	defer cancel()
+
+	pipeline := []bson.M{
+		{"$match": timestampMatch(start, end)},
+		{"$unwind": "$properties.problem_type_fondue"},
+		{"$group": bson.M{"_id": "$properties.problem_type_fondue", "count": bson.M{"$sum": 1}}},
+		{"$sort": bson.M{"count": -1}},
+	}
+
+	cursor, err := postsCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw []ProblemTypeCount
+	if err := cursor.All(ctx, &raw); err != nil {
+		return nil, err
+	}
+
+	merged := map[string]int{}
+	for _, r := range raw {
+		merged[normalizeProblemType(r.ProblemType)] += r.Count
+	}
+
+	counts := make([]ProblemTypeCount, 0, len(merged))
+	for problemType, count := range merged {
+		counts = append(counts, ProblemTypeCount{ProblemType: problemType, Count: count})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].ProblemType < counts[j].ProblemType
+	})
+
+	if len(counts) > limit {
+		counts = counts[:limit]
+	}
+	return counts, nil
+}
+
+type OrgCount struct {
+	Org   string `json:"org" bson:"_id"`
+	Count int    `json:"count" bson:"count"`
+}
+
+// aggregateCountByOrg counts complaints per assigned organization across
+// both schemas. Features list orgs as an array; complaints keep them in one
+// comma-separated string, which is split and trimmed to match.
+func aggregateCountByOrg(ctx context.Context, start, end string) ([]OrgCount, error) {
+	ctx, cancel := withMongoTimeout(ctx, mongoOpTimeout)
+	defer cancel()
+
+	complaintOrgs := bson.M{"$map": bson.M{
+		"input": bson.M{"$split": bson.A{bson.M{"$ifNull": bson.A{"$organization", ""}}, ","}},
+		"as":    "org",
+		"in":    bson.M{"$trim": bson.M{"input": "$$org"}},
+	}}
+
+	pipeline := []bson.M{
+		{"$match": ComplaintFilter{Start: start, End: end}.anySchema()},
+		{"$project": bson.M{"orgs": bson.M{"$ifNull": bson.A{"$properties.org", complaintOrgs}}}},
+		{"$unwind": "$orgs"},
+		{"$match": bson.M{"orgs": bson.M{"$nin": bson.A{"", nil}}}},
+		{"$group": bson.M{"_id": "$orgs", "count": bson.M{"$sum": 1}}},
+		{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+	}
+
+	cursor, err := postsCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := []OrgCount{}
+	if err := cursor.All(ctx, &counts); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// openStates and closedStates split knownStates for
+// /complaints/open-vs-closed.
+var (
+	openStates   = []string{"start", "follow", "forward", "inprogress"}
+	closedStates = []string{"finish", "irrelevant"}
+)
+
+type OpenClosed struct {
+	District string  `json:"district" bson:"_id"`
+	Open     int     `json:"open" bson:"open"`
+	Closed   int     `json:"closed" bson:"closed"`
+	Ratio    float64 `json:"ratio" bson:"-"`
+}
+
+// aggregateOpenClosed counts open and closed complaints of either schema
+// per district, ranked by rankOpenClosed.
+func aggregateOpenClosed(ctx context.Context, start, end string) ([]OpenClosed, error) {
+	ctx, cancel := withMongoTimeout(ctx, mongoOpTimeout)
+	defer cancel()
+
+	state := bson.M{"$ifNull": bson.A{"$properties.state", "$state"}}
+	pipeline := []bson.M{
+		{"$match": ComplaintFilter{Start: start, End: end}.anySchema()},
+		{"$group": bson.M{
+			"_id":    bson.M{"$ifNull": bson.A{"$properties.district", "$district"}},
+			"open":   countIf(bson.M{"$in": bson.A{state, openStates}}),
+			"closed": countIf(bson.M{"$in": bson.A{state, closedStates}}),
+		}},
+	}
+
+	cursor, err := postsCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := []OpenClosed{}
+	if err := cursor.All(ctx, &counts); err != nil {
+		return nil, err
+	}
+	rankOpenClosed(counts)
+	return counts, nil
+}
+
+// rankOpenClosed sets each district's Ratio to its open share of open and
+// closed complaints, 1 with none closed and 0 with neither, and sorts
+// counts by it, highest first, then by district.
+func rankOpenClosed(counts []OpenClosed) {
+	for i := range counts {
+		if total := counts[i].Open + counts[i].Closed; total > 0 {
+			counts[i].Ratio = float64(counts[i].Open) / float64(total)
+		} else {
+			counts[i].Ratio = 0
+		}
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Ratio != counts[j].Ratio {
+			return counts[i].Ratio > counts[j].Ratio
+		}
+		return counts[i].District < counts[j].District
+	})
+}
+
+// resolutionGroups are the values ?group_by= accepts on
+// /statistics/resolution-time, alone or comma-separated.
+var resolutionGroups = []string{"district", "problem_type"}
+
+// ResolutionTime summarizes how many hours finished complaints took from
+// being reported to their last activity. District and ProblemType are only
+// set when grouped by them.
+type ResolutionTime struct {
+	District    string  `json:"district,omitempty"`
+	ProblemType string  `json:"problem_type,omitempty"`
+	Count       int     `json:"count"`
+	MeanHours   float64 `json:"mean_hours"`
+	MedianHours float64 `json:"median_hours"`
+	P95Hours    float64 `json:"p95_hours"`
+}
+
+// aggregateResolutionTime computes resolution times of finished complaints
+// of either schema, grouped by any of district and problem_type, or overall
+// when groupBy is empty. MongoDB returns each group's durations and the
+// percentiles are taken here, after merging problem type spellings the way
+// aggregateTopProblems does. Complaints whose last activity precedes their
+// timestamp, or either fails to parse, are left out.
+func aggregateResolutionTime(ctx context.Context, start, end string, groupBy []string) ([]ResolutionTime, error) {
+	ctx, cancel := withMongoTimeout(ctx, mongoOpTimeout)
+	defer cancel()
+
+	parse := func(feature, complaint string) bson.M {
+		return bson.M{"$dateFromString": bson.M{
+			"dateString": bson.M{"$ifNull": bson.A{"$" + feature, "$" + complaint}},
+			"onError":    nil,
+			"onNull":     nil,
+		}}
+	}
+	hours := bson.M{"$divide": bson.A{
+		bson.M{"$subtract": bson.A{
+			parse("properties.last_activity", "last_activity"),
+			parse("properties.timestamp", "timestamp"),
+		}},
+		float64(time.Hour / time.Millisecond),
+	}}
+
+	complaintTypes := bson.M{"$map": bson.M{
+		"input": bson.M{"$split": bson.A{bson.M{"$ifNull": bson.A{"$type", ""}}, ","}},
+		"as":    "type",
+		"in":    bson.M{"$trim": bson.M{"input": "$$type"}},
+	}}
+
+	pipeline := []bson.M{
+		{"$match": ComplaintFilter{Start: start, End: end, State: "finish"}.anySchema()},
+		{"$project": bson.M{
+			"hours":         hours,
+			"district":      bson.M{"$ifNull": bson.A{"$properties.district", "$district"}},
+			"problem_types": bson.M{"$ifNull": bson.A{"$properties.problem_type_fondue", complaintTypes}},
+		}},
+		{"$match": bson.M{"hours": bson.M{"$gte": 0}}},
+	}
+
+	key := bson.M{}
+	for _, group := range groupBy {
+		switch group {
+		case "district":
+			key["district"] = "$district"
+		case "problem_type":
+			pipeline = append(pipeline,
+				bson.M{"$unwind": "$problem_types"},
+				bson.M{"$match": bson.M{"problem_types": bson.M{"$ne": ""}}},
+			)
+			key["problem_type"] = "$problem_types"
+		}
+	}
+	pipeline = append(pipeline, bson.M{"$group": bson.M{"_id": key, "hours": bson.M{"$push": "$hours"}}})
+
+	cursor, err := postsCollection.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+
+	var raw []struct {
+		Key struct {
+			District    string `bson:"district"`
+			ProblemType string `bson:"problem_type"`
+		} `bson:"_id"`
+		Hours []float64 `bson:"hours"`
+	}
+	if err := cursor.All(ctx, &raw); err != nil {
+		return nil, err
+	}
+
+	type groupKey struct{ district, problemType string }
+	merged := map[groupKey][]float64{}
+	for _, r := range raw {
+		k := groupKey{district: r.Key.District}
+		if r.Key.ProblemType != "" {
+			k.problemType = normalizeProblemType(r.Key.ProblemType)
+		}
+		merged[k] = append(merged[k], r.Hours...)
+	}
+
+	results := make([]ResolutionTime, 0, len(merged))
+	for k, durations := range merged {
+		sort.Float64s(durations)
+		results = append(results, ResolutionTime{
+			District:    k.district,
+			ProblemType: k.problemType,
+			Count:       len(durations),
+			MeanHours:   mean(durations),
+			MedianHours: percentile(durations, 50),
+			P95Hours:    percentile(durations, 95),
+		})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].District != results[j].District {
+			return results[i].District < results[j].District
+		}
+		return results[i].ProblemType < results[j].ProblemType
+	})
+	return results, nil
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// percentile returns the p-th percentile of sorted, interpolating linearly
+// between the two closest ranks, so the 50th is the usual median.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(rank)
+	if lower+1 >= len(sorted) {
+		return sorted[lower]
+	}
+	return sorted[lower] + (rank-float64(lower))*(sorted[lower+1]-sorted[lower])
+}
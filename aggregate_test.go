@@ -0,0 +1,411 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestRankOpenClosed(t *testing.T) {
+	counts := []OpenClosed{
+		{District: "Bang Rak", Open: 1, Closed: 3},
+		{District: "Empty", Open: 0, Closed: 0},
+		{District: "Dusit", Open: 4, Closed: 0},
+		{District: "Pathum Wan", Open: 2, Closed: 1},
+		{District: "Closed", Open: 0, Closed: 5},
+		{District: "Bang Na", Open: 1, Closed: 3},
+	}
+	rankOpenClosed(counts)
+
+	want := []OpenClosed{
+		{District: "Dusit", Open: 4, Closed: 0, Ratio: 1},
+		{District: "Pathum Wan", Open: 2, Closed: 1, Ratio: 2.0 / 3},
+		{District: "Bang Na", Open: 1, Closed: 3, Ratio: 0.25},
+		{District: "Bang Rak", Open: 1, Closed: 3, Ratio: 0.25},
+		{District: "Closed", Open: 0, Closed: 5, Ratio: 0},
+		{District: "Empty", Open: 0, Closed: 0, Ratio: 0},
+	}
+	if !slices.Equal(counts, want) {
+		t.Errorf("rankOpenClosed =\n%+v\nwant\n%+v", counts, want)
+	}
+}
+
+func TestAggregateOpenClosed(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("zero closed", func(mt *mtest.T) {
+		useCollection(mt.T, mt.Coll)
+		ns := mt.Coll.Database().Name() + "." + mt.Coll.Name()
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch,
+			bson.D{{Key: "_id", Value: "Bang Rak"}, {Key: "open", Value: 1}, {Key: "closed", Value: 1}},
+			bson.D{{Key: "_id", Value: "Dusit"}, {Key: "open", Value: 3}, {Key: "closed", Value: 0}},
+		))
+
+		counts, err := aggregateOpenClosed(context.Background(), "", "")
+		if err != nil {
+			mt.Fatalf("aggregateOpenClosed: %v", err)
+		}
+		if len(counts) != 2 || counts[0].District != "Dusit" || counts[0].Ratio != 1 || counts[1].Ratio != 0.5 {
+			mt.Errorf("counts = %+v, want Dusit at 1 ahead of Bang Rak at 0.5", counts)
+		}
+	})
+}
+
+func TestAggregateReopenStreaks(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("closed and open", func(mt *mtest.T) {
+		useCollection(mt.T, mt.Coll)
+		ns := mt.Coll.Database().Name() + "." + mt.Coll.Name()
+
+		opened := time.Now().AddDate(0, 0, -100)
+		feature := func(ticketID, state, lastActivity string) bson.D {
+			return bson.D{{Key: "properties", Value: bson.D{
+				{Key: "ticket_id", Value: ticketID},
+				{Key: "state", Value: state},
+				{Key: "count_reopen", Value: 4},
+				{Key: "timestamp", Value: opened.Format(upstreamTimestampLayout)},
+				{Key: "last_activity", Value: lastActivity},
+			}}}
+		}
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch,
+			feature("open", "inprogress", ""),
+			feature("dismissed", "irrelevant", opened.AddDate(0, 0, 30).Format(upstreamTimestampLayout)),
+		))
+
+		streaks, err := aggregateReopenStreaks(context.Background(), "", "", 3)
+		if err != nil {
+			mt.Fatalf("aggregateReopenStreaks: %v", err)
+		}
+		if len(streaks) != 2 || streaks[0].DaysUnresolved != 100 || streaks[1].DaysUnresolved != 30 {
+			mt.Errorf("streaks = %+v, want 100 days for the open ticket and 30 for the one closed after 30", streaks)
+		}
+
+		match := mt.GetStartedEvent().Command.Lookup("pipeline", "0", "$match").Document()
+		if state := match.Lookup("properties.state", "$ne").StringValue(); state != "finish" {
+			mt.Errorf("$match %v does not exclude finished tickets", match)
+		}
+	})
+}
+
+func TestAggregateTimeseries(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	tests := []struct {
+		bucket, format string
+		monday         bool
+		buckets        []string
+	}{
+		{"day", "%Y-%m-%d", false, []string{"2024-01-31", "2024-02-01", "2024-03-15"}},
+		{"week", "%Y-%m-%d", true, []string{"2024-01-29", "2024-03-11"}},
+		{"month", "%Y-%m-01", false, []string{"2024-01-01", "2024-02-01", "2024-03-01"}},
+	}
+	for _, tc := range tests {
+		mt.Run(tc.bucket, func(mt *mtest.T) {
+			useCollection(mt.T, mt.Coll)
+			ns := mt.Coll.Database().Name() + "." + mt.Coll.Name()
+
+			var docs []bson.D
+			for i, date := range tc.buckets {
+				docs = append(docs, bson.D{{Key: "_id", Value: date}, {Key: "count", Value: i + 1}})
+			}
+			mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, docs...))
+
+			counts, err := aggregateTimeseries(context.Background(), "2024-01-01", "2024-03-31", tc.bucket)
+			if err != nil {
+				mt.Fatalf("aggregateTimeseries: %v", err)
+			}
+			if len(counts) != len(tc.buckets) {
+				mt.Fatalf("counts = %+v, want %d buckets", counts, len(tc.buckets))
+			}
+			for i, c := range counts {
+				if c.Date != tc.buckets[i] || c.Count != i+1 {
+					mt.Errorf("bucket %d = %+v, want %s with %d", i, c, tc.buckets[i], i+1)
+				}
+			}
+
+			command := mt.GetStartedEvent().Command
+			day := command.Lookup("pipeline", "1", "$addFields", "day", "$dateFromString", "format").StringValue()
+			if day != "%Y-%m-%d" {
+				mt.Errorf("timestamps parsed with format %q, want %%Y-%%m-%%d", day)
+			}
+			key := command.Lookup("pipeline", "3", "$group", "_id", "$dateToString")
+			if format := key.Document().Lookup("format").StringValue(); format != tc.format {
+				mt.Errorf("bucket key format = %q, want %q", format, tc.format)
+			}
+			date, _ := key.Document().Lookup("date").DocumentOK()
+			if _, err := date.LookupErr("$subtract"); (err == nil) != tc.monday {
+				mt.Errorf("bucket key %v: steps back to Monday = %v, want %v", key, !tc.monday, tc.monday)
+			}
+			if sort := command.Lookup("pipeline", "4", "$sort", "_id").AsInt64(); sort != 1 {
+				mt.Errorf("buckets sorted by _id %d, want oldest first", sort)
+			}
+		})
+	}
+}
+
+func TestTimeseriesBucketValidated(t *testing.T) {
+	r := newTestRouter(t, Config{})
+
+	for _, bucket := range []string{"year", "Day", "hour"} {
+		if w := serve(r, http.MethodGet, "/api/v1/statistics/timeseries?bucket="+bucket, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("bucket=%s: status = %d, want 400", bucket, w.Code)
+		}
+	}
+}
+
+func TestAggregateTopProblems(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("merged spellings", func(mt *mtest.T) {
+		useCollection(mt.T, mt.Coll)
+		ns := mt.Coll.Database().Name() + "." + mt.Coll.Name()
+
+		// MongoDB counts per spelling; road and ถนน only overtake flood once
+		// they are merged.
+		count := func(problemType string, n int) bson.D {
+			return bson.D{{Key: "_id", Value: problemType}, {Key: "count", Value: n}}
+		}
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch,
+			count("flood", 6),
+			count("road", 4),
+			count("ถนน", 3),
+			count("noise", 2),
+			count("Road", 1),
+			count("tree", 2),
+		))
+
+		counts, err := aggregateTopProblems(context.Background(), "", "", 3)
+		if err != nil {
+			mt.Fatalf("aggregateTopProblems: %v", err)
+		}
+		want := []ProblemTypeCount{
+			{ProblemType: "ถนน", Count: 8},
+			{ProblemType: "น้ำท่วม", Count: 6},
+			{ProblemType: "ต้นไม้", Count: 2},
+		}
+		if !slices.Equal(counts, want) {
+			mt.Errorf("counts = %+v, want %+v", counts, want)
+		}
+
+		command := mt.GetStartedEvent().Command
+		if unwind := command.Lookup("pipeline", "1", "$unwind").StringValue(); unwind != "$properties.problem_type_fondue" {
+			mt.Errorf("$unwind = %q, want the problem_type_fondue array", unwind)
+		}
+	})
+}
+
+func TestTopProblemsLimitValidated(t *testing.T) {
+	r := newTestRouter(t, Config{})
+
+	for _, limit := range []string{"0", "-1", "51", "abc"} {
+		if w := serve(r, http.MethodGet, "/api/v1/complaints/top-problems?limit="+limit, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("limit=%s: status = %d, want 400", limit, w.Code)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []float64{1, 2, 3, 4, 10}
+	tests := []struct {
+		p, want float64
+	}{
+		{0, 1},
+		{50, 3},
+		{75, 4},
+		{95, 8.8},
+		{100, 10},
+	}
+	for _, tc := range tests {
+		if got := percentile(sorted, tc.p); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("percentile(%v, %v) = %v, want %v", sorted, tc.p, got, tc.want)
+		}
+	}
+	if got := percentile([]float64{6, 18}, 50); got != 12 {
+		t.Errorf("median of an even count = %v, want 12", got)
+	}
+	if percentile(nil, 50) != 0 || mean(nil) != 0 {
+		t.Error("empty input did not give 0")
+	}
+}
+
+func TestAggregateResolutionTime(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("by problem type", func(mt *mtest.T) {
+		useCollection(mt.T, mt.Coll)
+		ns := mt.Coll.Database().Name() + "." + mt.Coll.Name()
+
+		group := func(problemType string, hours ...float64) bson.D {
+			return bson.D{
+				{Key: "_id", Value: bson.D{{Key: "problem_type", Value: problemType}}},
+				{Key: "hours", Value: hours},
+			}
+		}
+		// road and ถนน are one category, so their durations are pooled
+		// before the percentiles are taken.
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch,
+			group("road", 10, 1),
+			group("ถนน", 4, 2, 3),
+			group("flood", 24),
+		))
+
+		results, err := aggregateResolutionTime(context.Background(), "", "", []string{"problem_type"})
+		if err != nil {
+			mt.Fatalf("aggregateResolutionTime: %v", err)
+		}
+		want := []ResolutionTime{
+			{ProblemType: "ถนน", Count: 5, MeanHours: 4, MedianHours: 3, P95Hours: 8.8},
+			{ProblemType: "น้ำท่วม", Count: 1, MeanHours: 24, MedianHours: 24, P95Hours: 24},
+		}
+		if len(results) != len(want) {
+			mt.Fatalf("results = %+v, want %+v", results, want)
+		}
+		for i, got := range results {
+			w := want[i]
+			if got.ProblemType != w.ProblemType || got.Count != w.Count ||
+				math.Abs(got.MeanHours-w.MeanHours) > 1e-9 || math.Abs(got.MedianHours-w.MedianHours) > 1e-9 || math.Abs(got.P95Hours-w.P95Hours) > 1e-9 {
+				mt.Errorf("result %d = %+v, want %+v", i, got, w)
+			}
+		}
+
+		command := mt.GetStartedEvent().Command
+		if ms, _ := command.Lookup("pipeline", "1", "$project", "hours", "$divide", "1").DoubleOK(); ms != 3600000 {
+			mt.Errorf("durations divided by %v, want milliseconds per hour", ms)
+		}
+		if state := command.Lookup("pipeline", "0", "$match"); !strings.Contains(state.String(), `"finish"`) {
+			mt.Errorf("$match %v does not select finished complaints", state)
+		}
+	})
+}
+
+func TestSumStateAdd(t *testing.T) {
+	tests := []struct {
+		state string
+		want  SumState
+	}{
+		{"finish", SumState{Finish: 3}},
+		{"follow", SumState{Follow: 3}},
+		{"forward", SumState{Forward: 3}},
+		{"inprogress", SumState{InProgress: 3}},
+		{"irrelevant", SumState{Irrelevant: 3}},
+		{"start", SumState{Start: 3}},
+		{"เสร็จสิ้น", SumState{}},
+		{"Finish", SumState{}},
+		{"", SumState{}},
+	}
+	for _, tc := range tests {
+		var sum SumState
+		sum.add(tc.state, 1)
+		sum.add(tc.state, 2)
+		if sum != tc.want {
+			t.Errorf("add(%q) = %+v, want %+v", tc.state, sum, tc.want)
+		}
+	}
+}
+
+func TestAggregateSumState(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	count := func(state interface{}, n int) bson.D {
+		return bson.D{{Key: "_id", Value: state}, {Key: "count", Value: n}}
+	}
+	tests := []struct {
+		name   string
+		counts []bson.D
+		want   SumState
+	}{
+		{"no documents", nil, SumState{}},
+		{"mixed", []bson.D{count("finish", 7), count("start", 2), count("unknown", 9), count("inprogress", 4)}, SumState{Finish: 7, Start: 2, InProgress: 4}},
+	}
+	for _, tc := range tests {
+		mt.Run(tc.name, func(mt *mtest.T) {
+			useCollection(mt.T, mt.Coll)
+			r := newTestRouter(mt.T, Config{})
+			ns := mt.Coll.Database().Name() + "." + mt.Coll.Name()
+			mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, tc.counts...))
+
+			w := serve(r, http.MethodGet, "/api/v1/statistics/by-state", "")
+			if w.Code != http.StatusOK {
+				mt.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+			}
+			var got SumState
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				mt.Fatalf("decode body: %v", err)
+			}
+			if got != tc.want {
+				mt.Errorf("body = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAggregateCountByOrgMixedSchemas(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("features and complaints", func(mt *mtest.T) {
+		useCollection(mt.T, mt.Coll)
+		r := newTestRouter(mt.T, Config{})
+		ns := mt.Coll.Database().Name() + "." + mt.Coll.Name()
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch,
+			bson.D{{Key: "_id", Value: "BMA Traffic"}, {Key: "count", Value: 5}},
+			bson.D{{Key: "_id", Value: "เขตบางรัก"}, {Key: "count", Value: 2}},
+		))
+
+		w := serve(r, http.MethodGet, "/api/v1/complaints/count-by-org?start=2024-01-01&end=2024-01-31", "")
+		if w.Code != http.StatusOK {
+			mt.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+		}
+		if body := w.Body.String(); body != `[{"org":"BMA Traffic","count":5},{"org":"เขตบางรัก","count":2}]` {
+			mt.Errorf("body = %s, want both orgs busiest first", body)
+		}
+
+		command := mt.GetStartedEvent().Command
+
+		// The date range applies to whichever schema a document has.
+		branches, _ := command.Lookup("pipeline", "0", "$match", "$or").ArrayOK()
+		values, _ := branches.Values()
+		var ranged []string
+		for _, v := range values {
+			elements, _ := v.Document().Elements()
+			for _, e := range elements {
+				ranged = append(ranged, e.Key())
+			}
+		}
+		if !slices.Equal(ranged, []string{"properties.timestamp", "timestamp"}) {
+			mt.Errorf("$match $or ranges %v, want properties.timestamp and timestamp", ranged)
+		}
+
+		// Features contribute their org array, complaints their
+		// comma-separated organization split into the same shape.
+		orgs := command.Lookup("pipeline", "1", "$project", "orgs", "$ifNull")
+		if feature := orgs.Array().Index(0).Value().StringValue(); feature != "$properties.org" {
+			mt.Errorf("orgs first read from %q, want $properties.org", feature)
+		}
+		split := orgs.Array().Index(1).Value().Document().Lookup("$map", "input", "$split")
+		if source := split.Array().Index(0).Value().Document().Lookup("$ifNull", "0").StringValue(); source != "$organization" {
+			mt.Errorf("complaint orgs split from %q, want $organization", source)
+		}
+		if sep := split.Array().Index(1).Value().StringValue(); sep != "," {
+			mt.Errorf("complaint orgs split on %q, want a comma", sep)
+		}
+		if unwind := command.Lookup("pipeline", "2", "$unwind").StringValue(); unwind != "$orgs" {
+			mt.Errorf("$unwind = %q, want $orgs", unwind)
+		}
+	})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// auditCollection records every write to postsCollection. It is nil until
+// initMongoDB runs, in which case nothing is recorded.
+var auditCollection *mongo.Collection
+
+// Audit actions.
+const (
+	auditInsert = "insert"
+	auditUpdate = "update"
+	auditDelete = "delete"
+)
+
+// systemActor is recorded for writes made without an authenticated user,
+// such as scheduled syncs.
+const systemActor = "system"
+
+// AuditEntry is one write to postsCollection. TicketID is only set when the
+// write targeted a single ticket.
+type AuditEntry struct {
+	Action     string    `json:"action" bson:"action"`
+	Collection string    `json:"collection" bson:"collection"`
+	TicketID   string    `json:"ticket_id,omitempty" bson:"ticket_id,omitempty"`
+	Actor      string    `json:"actor" bson:"actor"`
+	Timestamp  time.Time `json:"timestamp" bson:"timestamp"`
+	Count      int64     `json:"count" bson:"count"`
+}
+
+type actorKey struct{}
+
+// withActor returns a copy of ctx that attributes writes to username.
+func withActor(ctx context.Context, username string) context.Context {
+	return context.WithValue(ctx, actorKey{}, username)
+}
+
+// actorFrom returns the user set by withActor, or systemActor.
+func actorFrom(ctx context.Context) string {
+	if username, ok := ctx.Value(actorKey{}).(string); ok && username != "" {
+		return username
+	}
+	return systemActor
+}
+
+// recordAudit stores an entry for a write that affected count documents.
+// Writes that changed nothing are not recorded. The write has already
+// happened, so a failure is logged rather than returned, and the entry is
+// stored even if ctx was cancelled in the meantime.
+func recordAudit(ctx context.Context, action, ticketID string, count int64) {
+	if auditCollection == nil || count == 0 {
+		return
+	}
+
+	entry := AuditEntry{
+		Action:     action,
+		Collection: postsCollection.Name(),
+		TicketID:   ticketID,
+		Actor:      actorFrom(ctx),
+		Timestamp:  time.Now(),
+		Count:      count,
+	}
+
+	ctx, cancel := withMongoTimeout(context.WithoutCancel(ctx), mongoOpTimeout)
+	defer cancel()
+
+	if _, err := auditCollection.InsertOne(ctx, entry); err != nil {
+		slog.Error("Failed to record audit entry", "action", action, "ticket_id", ticketID, "actor", entry.Actor, "error", err)
+	}
+}
+
+// ensureAuditIndex indexes audit entries by time for GET /admin/audit.
+func ensureAuditIndex(ctx context.Context) error {
+	_, err := auditCollection.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "timestamp", Value: -1}}})
+	return err
+}
+
+// findAuditEntries pages through audit entries recorded within the
+// inclusive [start, end] range, newest first.
+func findAuditEntries(ctx context.Context, start, end string, offset, limit int) (int64, []AuditEntry, error) {
+	ctx, cancel := withMongoTimeout(ctx, mongoOpTimeout)
+	defer cancel()
+
+	filter := bson.D{}
+	lower, upper := rangeBounds(start, end)
+	rng := bson.M{}
+	if !lower.IsZero() {
+		rng["$gte"] = lower
+	}
+	if !upper.IsZero() {
+		rng["$lt"] = upper
+	}
+	if len(rng) > 0 {
+		filter = append(filter, bson.E{Key: "timestamp", Value: rng})
+	}
+
+	total, err := auditCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: -1}}).
+		SetSkip(int64(offset)).
+		SetLimit(int64(limit))
+
+	cursor, err := auditCollection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	entries := []AuditEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return 0, nil, err
+	}
+	return total, entries, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Credentials is the body accepted by POST /auth/token.
+type Credentials struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// checkCredentials compares against the configured user in constant time.
+// With no user configured every login is rejected.
+func checkCredentials(cfg Config, creds Credentials) bool {
+	if cfg.AuthUsername == "" || cfg.AuthPassword == "" {
+		return false
+	}
+	userOK := subtle.ConstantTimeCompare([]byte(creds.Username), []byte(cfg.AuthUsername)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(creds.Password), []byte(cfg.AuthPassword)) == 1
+	return userOK && passOK
+}
+
+// issueToken signs an HS256 token for username that expires after ttl.
+func issueToken(secret, username string, ttl time.Duration) (string, time.Time, error) {
+	if secret == "" {
+		return "", time.Time{}, errors.New("JWT_SECRET is not set")
+	}
+
+	now := time.Now()
+	expires := now.Add(ttl)
+	claims := jwt.RegisteredClaims{
+		Subject:   username,
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(expires),
+	}
+
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	return signed, expires, err
+}
+
+// bearerToken returns the token from an "Authorization: Bearer" header.
+func bearerToken(c *gin.Context) (string, bool) {
+	raw, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	return raw, ok && raw != ""
+}
+
+// parseToken verifies raw as an HS256 token signed with secret and returns
+// its claims. opts are applied after the defaults, e.g. jwt.WithLeeway to
+// accept a recently expired token.
+func parseToken(secret, raw string, opts ...jwt.ParserOption) (jwt.RegisteredClaims, error) {
+	var claims jwt.RegisteredClaims
+	opts = append([]jwt.ParserOption{jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired()}, opts...)
+	_, err := jwt.ParseWithClaims(raw, &claims, func(*jwt.Token) (interface{}, error) {
+		return []byte(secret), nil
+	}, opts...)
+	return claims, err
+}
+
+// JWTMiddleware rejects requests without a valid, unexpired Bearer token
+// signed with secret. An empty secret rejects everything rather than
+// leaving the route open.
+func JWTMiddleware(secret string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		raw, ok := bearerToken(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing bearer token"})
+			return
+		}
+
+		if secret == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication is not configured"})
+			return
+		}
+
+		claims, err := parseToken(secret, raw)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token", "details": err.Error()})
+			return
+		}
+
+		c.Set("username", claims.Subject)
+		c.Request = c.Request.WithContext(withActor(c.Request.Context(), claims.Subject))
+		c.Next()
+	}
+}
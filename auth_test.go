@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// signedToken signs claims with method and key, bypassing
+// issueToken so tests can build expired or otherwise bad tokens.
+func signedToken(t *testing.T, method jwt.SigningMethod, key any, claims jwt.RegisteredClaims) string {
+	t.Helper()
+
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return token
+}
+
+func TestJWTMiddleware(t *testing.T) {
+	r := gin.New()
+	r.GET("/", JWTMiddleware(testJWTSecret), func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString("username"))
+	})
+
+	w := serve(r, http.MethodGet, "/", "", "Authorization", bearer(t))
+	if w.Code != http.StatusOK || w.Body.String() != "tester" {
+		t.Fatalf("valid token: status = %d, body %q, want 200 for tester", w.Code, w.Body)
+	}
+
+	expired := signedToken(t, jwt.SigningMethodHS256, []byte(testJWTSecret), jwt.RegisteredClaims{
+		Subject:   "tester",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+	})
+	wrongSecret := signedToken(t, jwt.SigningMethodHS256, []byte("other-secret"), jwt.RegisteredClaims{
+		Subject:   "tester",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	})
+	noExpiry := signedToken(t, jwt.SigningMethodHS256, []byte(testJWTSecret), jwt.RegisteredClaims{Subject: "tester"})
+	unsigned := signedToken(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, jwt.RegisteredClaims{
+		Subject:   "tester",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	})
+
+	for _, tc := range []struct {
+		name string
+		auth string
+	}{
+		{"missing header", ""},
+		{"not bearer", "Basic dGVzdGVyOnB3"},
+		{"empty bearer", "Bearer "},
+		{"malformed", "Bearer not-a-jwt"},
+		{"expired", "Bearer " + expired},
+		{"wrong secret", "Bearer " + wrongSecret},
+		{"no expiry", "Bearer " + noExpiry},
+		{"alg none", "Bearer " + unsigned},
+	} {
+		w := serve(r, http.MethodGet, "/", "", "Authorization", tc.auth)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status = %d, want 401", tc.name, w.Code)
+		}
+	}
+}
+
+func TestJWTMiddlewareWithoutSecret(t *testing.T) {
+	r := gin.New()
+	r.GET("/", JWTMiddleware(""), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	if w := serve(r, http.MethodGet, "/", "", "Authorization", bearer(t)); w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d with no secret configured, want 401", w.Code)
+	}
+}
+
+func TestAuthTokenIssuesUsableToken(t *testing.T) {
+	r := newTestRouter(t, Config{
+		JWTSecret:    testJWTSecret,
+		AuthUsername: "admin",
+		AuthPassword: "hunter2",
+		TokenTTL:     time.Hour,
+	})
+
+	w := serve(r, http.MethodPost, "/api/v1/auth/token", `{"username":"admin","password":"wrong"}`,
+		"Content-Type", "application/json")
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong password: status = %d, want 401", w.Code)
+	}
+
+	w = serve(r, http.MethodPost, "/api/v1/auth/token", `{"username":"admin","password":"hunter2"}`,
+		"Content-Type", "application/json")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+
+	claims, err := parseToken(testJWTSecret, body.Token)
+	if err != nil || claims.Subject != "admin" {
+		t.Errorf("issued token: subject %q, err %v, want a valid token for admin", claims.Subject, err)
+	}
+}
+
+func TestAuthRefreshGrace(t *testing.T) {
+	r := newTestRouter(t, Config{
+		JWTSecret:       testJWTSecret,
+		TokenTTL:        time.Hour,
+		JWTRefreshGrace: 5 * time.Minute,
+	})
+
+	expiredAgo := func(d time.Duration) string {
+		return "Bearer " + signedToken(t, jwt.SigningMethodHS256, []byte(testJWTSecret), jwt.RegisteredClaims{
+			Subject:   "tester",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(-d)),
+		})
+	}
+
+	for _, tc := range []struct {
+		name string
+		auth string
+		want int
+	}{
+		{"unexpired", bearer(t), http.StatusOK},
+		{"within grace", expiredAgo(time.Minute), http.StatusOK},
+		{"beyond grace", expiredAgo(10 * time.Minute), http.StatusUnauthorized},
+		{"missing", "", http.StatusUnauthorized},
+	} {
+		w := serve(r, http.MethodPost, "/api/v1/auth/refresh", "", "Authorization", tc.auth)
+		if w.Code != tc.want {
+			t.Errorf("%s: status = %d, want %d: %s", tc.name, w.Code, tc.want, w.Body)
+			continue
+		}
+		if tc.want != http.StatusOK {
+			continue
+		}
+
+		var body struct {
+			Token string `json:"token"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: decode body: %v", tc.name, err)
+		}
+		claims, err := parseToken(testJWTSecret, body.Token)
+		if err != nil || claims.Subject != "tester" {
+			t.Errorf("%s: refreshed token: subject %q, err %v, want a valid token for tester", tc.name, claims.Subject, err)
+		}
+	}
+}
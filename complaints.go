@@ -0,0 +1,872 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ComplaintFilter holds the query parameters shared by the endpoints that
+// read stored features back out of MongoDB.
+type ComplaintFilter struct {
+	Start       string
+	End         string
+	State       string
+	ProblemType string
+	Org         string
+	Province    string
+	OutputType  string
+	Districts   []string
+	BBox        *[4]float64
+	MinStar     *float64
+	MaxStar     *float64
+	MinReopen   *int
+	MaxReopen   *int
+	SeeInfo     *bool
+	Schema      string
+
+	LastActivityAfter  string
+	LastActivityBefore string
+}
+
+// schemaField tells the two document schemas apart. It is unrelated to the
+// numeric schema_version migrations stamp. Documents saved before it existed
+// get it from POST /admin/backfill-schema.
+const (
+	schemaField     = "_schema_version"
+	schemaFeature   = "feature"
+	schemaComplaint = "complaint"
+)
+
+// bson builds the filter for Feature documents stored by /saveToMongoDB.
+// Flat Complaint documents have no properties, which keeps them out whether
+// or not POST /admin/backfill-schema has tagged them yet.
+func (f ComplaintFilter) bson() bson.D {
+	return append(bson.D{{Key: "properties", Value: bson.M{"$exists": true}}}, f.bsonFor("properties.")...)
+}
+
+// bsonForSchema builds the filter for the schema f.Schema selects, Feature
+// documents if it is unset.
+func (f ComplaintFilter) bsonForSchema() bson.D {
+	if f.Schema == schemaComplaint {
+		return f.bsonFor("")
+	}
+	return f.bson()
+}
+
+// anySchema matches both Feature documents and the flat Complaint documents
+// stored by /saveToMongoDBCSV.
+func (f ComplaintFilter) anySchema() bson.D {
+	return bson.D{{Key: "$or", Value: bson.A{f.bsonFor("properties."), f.bsonFor("")}}}
+}
+
+// storeFilter is the filter Store queries run: both schemas unless
+// f.Schema selects one.
+func (f ComplaintFilter) storeFilter() bson.D {
+	if f.Schema == "" {
+		return f.anySchema()
+	}
+	return f.bsonForSchema()
+}
+
+// upstream picks out the filters the upstream API applies itself. The
+// rest have to be applied to the pages it returns.
+func (f ComplaintFilter) upstream() UpstreamFilter {
+	return UpstreamFilter{State: f.State, ProblemType: f.ProblemType, Org: f.Org, Province: f.Province, OutputType: f.OutputType}
+}
+
+func (f ComplaintFilter) bsonFor(prefix string) bson.D {
+	filter := bson.D{}
+	if rng := timestampRange(f.Start, f.End); rng != nil {
+		filter = append(filter, bson.E{Key: prefix + "timestamp", Value: rng})
+	}
+	if f.State != "" {
+		filter = append(filter, bson.E{Key: prefix + "state", Value: f.State})
+	}
+	if f.ProblemType != "" {
+		if prefix == "" {
+			// Complaints keep their problem types as one comma-separated string.
+			filter = append(filter, bson.E{Key: "type", Value: primitive.Regex{Pattern: regexp.QuoteMeta(f.ProblemType)}})
+		} else {
+			filter = append(filter, bson.E{Key: prefix + "problem_type_fondue", Value: bson.M{"$in": bson.A{f.ProblemType}}})
+		}
+	}
+	if f.Org != "" {
+		// Features list orgs as an array, complaints as one string; a regex
+		// matches a substring of either.
+		key := "organization"
+		if prefix != "" {
+			key = prefix + "org"
+		}
+		filter = append(filter, bson.E{Key: key, Value: primitive.Regex{Pattern: regexp.QuoteMeta(f.Org), Options: "i"}})
+	}
+	if f.Province != "" {
+		filter = append(filter, bson.E{Key: prefix + "province", Value: f.Province})
+	}
+	if f.OutputType != "" {
+		// Only features carry an output type; flat complaints use type for
+		// problem types, so they never match.
+		filter = append(filter, bson.E{Key: "properties.type", Value: f.OutputType})
+	}
+	if len(f.Districts) > 0 {
+		filter = append(filter, bson.E{Key: prefix + "district", Value: bson.M{"$in": f.Districts}})
+	}
+	if f.BBox != nil {
+		filter = append(filter, bson.E{Key: "geometry.coordinates", Value: bboxFilter(*f.BBox)})
+	}
+	if f.SeeInfo != nil {
+		filter = append(filter, bson.E{Key: prefix + "see_info", Value: *f.SeeInfo})
+	}
+	if f.Schema != "" {
+		filter = append(filter, bson.E{Key: schemaField, Value: f.Schema})
+	}
+
+	// Conditions on fields stored as strings that have to be converted
+	// before comparing share the one $expr a filter may hold.
+	exprs := bson.A{}
+	if f.MinStar != nil || f.MaxStar != nil {
+		exprs = append(exprs, numericRange(prefix+"star", f.MinStar, f.MaxStar))
+	}
+	if f.MinReopen != nil || f.MaxReopen != nil {
+		// Complaints store count_reopen as a string, features as an int.
+		exprs = append(exprs, numericRange(prefix+"count_reopen", intToFloat(f.MinReopen), intToFloat(f.MaxReopen)))
+	}
+	if rng := lastActivityRange(prefix+"last_activity", f.LastActivityAfter, f.LastActivityBefore); rng != nil {
+		exprs = append(exprs, rng)
+	}
+	if len(exprs) == 1 {
+		filter = append(filter, bson.E{Key: "$expr", Value: exprs[0]})
+	} else if len(exprs) > 1 {
+		filter = append(filter, bson.E{Key: "$expr", Value: bson.M{"$and": exprs}})
+	}
+	return filter
+}
+
+// numericRange compares field numerically whether it was stored as a number
+// or a string such as "3". Documents where the field is missing or not
+// numeric never match.
+func numericRange(field string, min, max *float64) bson.M {
+	value := bson.M{"$convert": bson.M{"input": "$" + field, "to": "double", "onError": nil, "onNull": nil}}
+
+	// null sorts below every number, so it has to be excluded explicitly for
+	// a max-only range.
+	conds := bson.A{bson.M{"$ne": bson.A{value, nil}}}
+	if min != nil {
+		conds = append(conds, bson.M{"$gte": bson.A{value, *min}})
+	}
+	if max != nil {
+		conds = append(conds, bson.M{"$lte": bson.A{value, *max}})
+	}
+	return bson.M{"$and": conds}
+}
+
+func intToFloat(v *int) *float64 {
+	if v == nil {
+		return nil
+	}
+	f := float64(*v)
+	return &f
+}
+
+// lastActivityRange matches field, an upstream timestamp string, against the
+// inclusive [after, before] range, or returns nil when both are empty.
+// Unparseable values never match.
+func lastActivityRange(field, after, before string) bson.M {
+	lower, upper := rangeBounds(after, before)
+	if lower.IsZero() && upper.IsZero() {
+		return nil
+	}
+
+	activity := bson.M{"$dateFromString": bson.M{"dateString": "$" + field, "onError": nil, "onNull": nil}}
+
+	conds := bson.A{bson.M{"$ne": bson.A{activity, nil}}}
+	if !lower.IsZero() {
+		conds = append(conds, bson.M{"$gte": bson.A{activity, lower}})
+	}
+	if !upper.IsZero() {
+		conds = append(conds, bson.M{"$lt": bson.A{activity, upper}})
+	}
+	return bson.M{"$and": conds}
+}
+
+// The upstream API takes a single district at most, so multi-district
+// queries against it are applied to the returned page instead.
+
+func filterFeaturesByDistrict(features []Feature, districts []string) []Feature {
+	filtered := []Feature{}
+	for _, f := range features {
+		if slices.Contains(districts, f.Properties.District) {
+			filtered = append(filtered, f)
+		}
+	}
+	return filtered
+}
+
+func filterComplaintsByDistrict(complaints []Complaint, districts []string) []Complaint {
+	filtered := []Complaint{}
+	for _, c := range complaints {
+		if slices.Contains(districts, c.District) {
+			filtered = append(filtered, c)
+		}
+	}
+	return filtered
+}
+
+// PageMeta describes where a page sits in the full result set.
+type PageMeta struct {
+	Total      int    `json:"total"`
+	Offset     int    `json:"offset"`
+	Limit      int    `json:"limit"`
+	HasMore    bool   `json:"has_more"`
+	TotalPages int    `json:"total_pages"`
+	NextOffset int    `json:"next_offset"`
+	Cursor     string `json:"cursor,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// ComplaintsPage is the envelope paginated read endpoints respond with.
+type ComplaintsPage struct {
+	Data []Feature `json:"data"`
+	Meta PageMeta  `json:"meta"`
+}
+
+type storedFeature struct {
+	ID      primitive.ObjectID `bson:"_id"`
+	Feature `bson:",inline"`
+}
+
+type storedComplaint struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	Complaint `bson:",inline"`
+}
+
+// findComplaints pages through stored features in insertion order. When
+// cursor is set it takes precedence over offset so pages stay stable while
+// new documents are being inserted. One extra document is fetched to tell
+// whether another page follows.
+func findComplaints(ctx context.Context, filter ComplaintFilter, offset, limit int, cursor string) (ComplaintsPage, error) {
+	ctx, cancel := withMongoTimeout(ctx, mongoOpTimeout)
+	defer cancel()
+
+	query := filter.bsonForSchema()
+
+	total, err := postsCollection.CountDocuments(ctx, query)
+	if err != nil {
+		return ComplaintsPage{}, err
+	}
+
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetLimit(int64(limit + 1))
+
+	if cursor != "" {
+		after, err := primitive.ObjectIDFromHex(cursor)
+		if err != nil {
+			return ComplaintsPage{}, err
+		}
+		query = append(query, bson.E{Key: "_id", Value: bson.M{"$gt": after}})
+	} else {
+		findOptions.SetSkip(int64(offset))
+	}
+
+	results, err := postsCollection.Find(ctx, query, findOptions)
+	if err != nil {
+		return ComplaintsPage{}, err
+	}
+
+	var stored []storedFeature
+	if filter.Schema == schemaComplaint {
+		var complaints []storedComplaint
+		if err := results.All(ctx, &complaints); err != nil {
+			return ComplaintsPage{}, err
+		}
+		for _, c := range complaints {
+			stored = append(stored, storedFeature{ID: c.ID, Feature: complaintToFeature(c.Complaint)})
+		}
+	} else if err := results.All(ctx, &stored); err != nil {
+		return ComplaintsPage{}, err
+	}
+
+	hasMore := len(stored) > limit
+	if hasMore {
+		stored = stored[:limit]
+	}
+
+	features := make([]Feature, 0, len(stored))
+	for _, s := range stored {
+		features = append(features, s.Feature)
+	}
+
+	page := ComplaintsPage{
+		Data: features,
+		Meta: newPageMeta(int(total), offset, limit, hasMore),
+	}
+	if hasMore {
+		page.Meta.NextCursor = stored[len(stored)-1].ID.Hex()
+	}
+
+	return page, nil
+}
+
+// maxStaleDays caps the days parameter of /complaints/stale.
+const maxStaleDays = 365
+
+// staleBefore formats threshold for comparing against last_activity, a
+// Bangkok-time string, as a string.
+func staleBefore(threshold time.Time) string {
+	return threshold.In(bangkokTime).Format(storedTimestampLayout)
+}
+
+// staleComplaints pages through stored features last updated before
+// threshold, least recently updated first. state is optional.
+func staleComplaints(ctx context.Context, threshold time.Time, state string, offset, limit int) (ComplaintsPage, error) {
+	ctx, cancel := withMongoTimeout(ctx, mongoOpTimeout)
+	defer cancel()
+
+	// Blank values are not stale, just unknown.
+	query := bson.D{{Key: "properties.last_activity", Value: bson.M{
+		"$gt": "",
+		"$lt": staleBefore(threshold),
+	}}}
+	if state != "" {
+		query = append(query, bson.E{Key: "properties.state", Value: state})
+	}
+
+	total, err := postsCollection.CountDocuments(ctx, query)
+	if err != nil {
+		return ComplaintsPage{}, err
+	}
+
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "properties.last_activity", Value: 1}, {Key: "_id", Value: 1}}).
+		SetSkip(int64(offset)).
+		SetLimit(int64(limit))
+
+	cursor, err := postsCollection.Find(ctx, query, findOptions)
+	if err != nil {
+		return ComplaintsPage{}, err
+	}
+
+	features := []Feature{}
+	if err := cursor.All(ctx, &features); err != nil {
+		return ComplaintsPage{}, err
+	}
+
+	return ComplaintsPage{
+		Data: features,
+		Meta: newPageMeta(int(total), offset, limit, offset+len(features) < int(total)),
+	}, nil
+}
+
+// countFeatures counts stored features matching filter through
+// complaintStore. Features are told apart by schemaField, so documents
+// saved before it existed need POST /admin/backfill-schema. If nothing at
+// all is stored and a date range was given, the upstream API's total for
+// that range is reported instead, fetched with a one-record page.
+func countFeatures(ctx context.Context, filter ComplaintFilter) (int64, string, error) {
+	filter.Schema = schemaFeature
+	count, err := complaintStore.CountComplaints(ctx, filter)
+	if err != nil || count > 0 || (filter.Start == "" && filter.End == "") {
+		return count, "mongodb", err
+	}
+
+	stored, err := complaintStore.CountComplaints(ctx, ComplaintFilter{})
+	if err != nil || stored > 0 {
+		return count, "mongodb", err
+	}
+
+	data, err := fetchPage(ctx, filter.Start, filter.End, 0, 1, filter.upstream())
+	if err != nil {
+		return 0, "upstream", err
+	}
+	return int64(data.Total), "upstream", nil
+}
+
+// explainComplaints returns MongoDB's query plan for the find /complaints
+// would run with filter and limit.
+func explainComplaints(ctx context.Context, filter ComplaintFilter, limit int) (bson.M, error) {
+	ctx, cancel := withMongoTimeout(ctx, mongoOpTimeout)
+	defer cancel()
+
+	cmd := bson.D{
+		{Key: "explain", Value: bson.D{
+			{Key: "find", Value: postsCollection.Name()},
+			{Key: "filter", Value: filter.bsonForSchema()},
+			{Key: "sort", Value: bson.D{{Key: "_id", Value: 1}}},
+			{Key: "limit", Value: limit},
+			{Key: "comment", Value: "explain"},
+		}},
+		{Key: "verbosity", Value: "queryPlanner"},
+	}
+
+	var plan bson.M
+	err := postsCollection.Database().RunCommand(ctx, cmd).Decode(&plan)
+	return plan, err
+}
+
+// minSearchLength is the shortest q accepted by /complaints/search.
+const minSearchLength = 2
+
+// searchComplaints runs a text search over feature descriptions and
+// addresses, best matches first.
+func searchComplaints(ctx context.Context, q string, filter ComplaintFilter, offset, limit int) (ComplaintsPage, error) {
+	ctx, cancel := withMongoTimeout(ctx, mongoOpTimeout)
+	defer cancel()
+
+	query := append(bson.D{{Key: "$text", Value: bson.M{"$search": q}}}, filter.bson()...)
+
+	total, err := postsCollection.CountDocuments(ctx, query)
+	if err != nil {
+		return ComplaintsPage{}, err
+	}
+
+	score := bson.M{"$meta": "textScore"}
+	findOptions := options.Find().
+		SetProjection(bson.M{"score": score}).
+		SetSort(bson.D{{Key: "score", Value: score}}).
+		SetSkip(int64(offset)).
+		SetLimit(int64(limit))
+
+	results, err := postsCollection.Find(ctx, query, findOptions)
+	if err != nil {
+		return ComplaintsPage{}, err
+	}
+
+	features := []Feature{}
+	if err := results.All(ctx, &features); err != nil {
+		return ComplaintsPage{}, err
+	}
+
+	return ComplaintsPage{
+		Data: features,
+		Meta: newPageMeta(int(total), offset, limit, offset+len(features) < int(total)),
+	}, nil
+}
+
+// ticketFilter matches a ticket stored either as a Feature from the JSON API
+// or as a flat Complaint from the CSV API.
+func ticketFilter(ticketID string) bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{"properties.ticket_id": ticketID},
+		bson.M{"ticket_id": ticketID},
+	}}
+}
+
+// findComplaint returns the stored document for a ticket as-is, whichever
+// schema it was saved with. It returns mongo.ErrNoDocuments when missing.
+func findComplaint(ctx context.Context, ticketID string) (bson.M, error) {
+	ctx, cancel := withMongoTimeout(ctx, mongoOpTimeout)
+	defer cancel()
+
+	var doc bson.M
+	err := postsCollection.FindOne(ctx, ticketFilter(ticketID)).Decode(&doc)
+	return doc, err
+}
+
+// FieldChange is one field's value before and after a new version.
+type FieldChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// HistoryEntry is one stored version of a ticket. Diff lists the fields
+// that changed since the previous version and is empty for the first.
+type HistoryEntry struct {
+	CreatedAt *time.Time             `json:"created_at"`
+	Record    Complaint              `json:"record"`
+	Diff      map[string]FieldChange `json:"diff"`
+}
+
+// featureHistory returns every version of a ticket, oldest first: the
+// versions archived by saveFeaturesToMongoDB and the documents stored now.
+// Documents saved before created_at was recorded sort ahead of the rest.
+func featureHistory(ctx context.Context, ticketID string) ([]HistoryEntry, error) {
+	ctx, cancel := withMongoTimeout(ctx, mongoOpTimeout)
+	defer cancel()
+
+	findOptions := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := postsCollection.Find(ctx, ticketFilter(ticketID), findOptions)
+	if err != nil {
+		return nil, err
+	}
+	var current []bson.Raw
+	if err := cursor.All(ctx, &current); err != nil {
+		return nil, err
+	}
+
+	archived, err := findFeatureVersions(ctx, ticketID)
+	if err != nil {
+		return nil, err
+	}
+	return buildHistory(ticketVersions(archived, current))
+}
+
+// appendVersion adds the stored document raw, written at at, to history as
+// its newest version, with the fields that changed since the one before.
+func appendVersion(history []HistoryEntry, raw bson.Raw, at *time.Time) ([]HistoryEntry, error) {
+	record, err := decodeComplaint(raw)
+	if err != nil {
+		return nil, err
+	}
+	record.CreatedAt = nil
+
+	entry := HistoryEntry{CreatedAt: at, Record: record, Diff: map[string]FieldChange{}}
+	if len(history) > 0 {
+		entry.Diff = diffComplaints(history[len(history)-1].Record, record)
+	}
+	return append(history, entry), nil
+}
+
+// diffComplaints compares the exported CSV fields of two versions.
+func diffComplaints(prev, next Complaint) map[string]FieldChange {
+	diff := map[string]FieldChange{}
+	before, after := prev.csvRecord(), next.csvRecord()
+	for i, field := range complaintCSVHeaders {
+		if before[i] != after[i] {
+			diff[field] = FieldChange{From: before[i], To: after[i]}
+		}
+	}
+	return diff
+}
+
+// deleteComplaint removes a single ticket and reports whether it existed.
+func deleteComplaint(ctx context.Context, ticketID string) (bool, error) {
+	ctx, cancel := withMongoTimeout(ctx, mongoOpTimeout)
+	defer cancel()
+
+	result, err := postsCollection.DeleteOne(ctx, ticketFilter(ticketID))
+	if err != nil {
+		return false, err
+	}
+	recordAudit(ctx, auditDelete, ticketID, result.DeletedCount)
+	return result.DeletedCount > 0, nil
+}
+
+// deleteComplaints removes the stored documents filter matches, of either
+// schema unless filter.Schema selects one.
+func deleteComplaints(ctx context.Context, filter ComplaintFilter) (int64, error) {
+	ctx, cancel := withMongoTimeout(ctx, mongoOpTimeout)
+	defer cancel()
+
+	result, err := postsCollection.DeleteMany(ctx, filter.storeFilter())
+	if err != nil {
+		return 0, err
+	}
+	recordAudit(ctx, auditDelete, "", result.DeletedCount)
+	return result.DeletedCount, nil
+}
+
+type DuplicateTicket struct {
+	TicketID string `json:"ticket_id" bson:"ticket_id"`
+	Count    int    `json:"count" bson:"count"`
+}
+
+// duplicateGroups groups stored documents by ticket ID and keeps the IDs
+// that occur more than once. A feature and a CSV complaint for the same
+// ticket are different records, so each schema is grouped separately.
+func duplicateGroups() []bson.M {
+	return []bson.M{
+		{"$group": bson.M{
+			"_id": bson.M{
+				"ticket_id": bson.M{"$ifNull": bson.A{"$properties.ticket_id", "$ticket_id"}},
+				"feature":   bson.M{"$ne": bson.A{bson.M{"$type": "$properties"}, "missing"}},
+			},
+			"count":     bson.M{"$sum": 1},
+			"ids":       bson.M{"$push": "$_id"},
+			"latest_id": bson.M{"$max": "$_id"},
+		}},
+		{"$match": bson.M{"_id.ticket_id": bson.M{"$ne": nil}, "count": bson.M{"$gt": 1}}},
+	}
+}
+
+// findDuplicates pages through ticket IDs stored more than once, most
+// copies first.
+func findDuplicates(ctx context.Context, offset, limit int) (int, []DuplicateTicket, error) {
+	ctx, cancel := withMongoTimeout(ctx, mongoOpTimeout)
+	defer cancel()
+
+	pipeline := append(duplicateGroups(), bson.M{"$facet": bson.M{
+		"total": bson.A{bson.M{"$count": "n"}},
+		"items": bson.A{
+			bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id.ticket_id", Value: 1}}},
+			bson.M{"$skip": offset},
+			bson.M{"$limit": limit},
+			bson.M{"$project": bson.M{"_id": 0, "ticket_id": "$_id.ticket_id", "count": 1}},
+		},
+	}})
+
+	cursor, err := postsCollection.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var result []struct {
+		Total []struct {
+			N int `bson:"n"`
+		} `bson:"total"`
+		Items []DuplicateTicket `bson:"items"`
+	}
+	if err := cursor.All(ctx, &result); err != nil {
+		return 0, nil, err
+	}
+
+	items := []DuplicateTicket{}
+	total := 0
+	if len(result) > 0 {
+		if len(result[0].Total) > 0 {
+			total = result[0].Total[0].N
+		}
+		if result[0].Items != nil {
+			items = result[0].Items
+		}
+	}
+
+	return total, items, nil
+}
+
+// deleteDuplicates removes every copy of a duplicated ticket except the most
+// recently inserted one, judged by ObjectID order.
+func deleteDuplicates(ctx context.Context) (int64, error) {
+	ctx, cancel := withMongoTimeout(ctx, mongoOpTimeout)
+	defer cancel()
+
+	pipeline := append(duplicateGroups(), bson.M{"$project": bson.M{
+		"stale": bson.M{"$setDifference": bson.A{"$ids", bson.A{"$latest_id"}}},
+	}})
+
+	cursor, err := postsCollection.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var stale []primitive.ObjectID
+	for cursor.Next(ctx) {
+		var group struct {
+			Stale []primitive.ObjectID `bson:"stale"`
+		}
+		if err := cursor.Decode(&group); err != nil {
+			return 0, err
+		}
+		stale = append(stale, group.Stale...)
+	}
+	if err := cursor.Err(); err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	for start := 0; start < len(stale); start += maxBulkTicketIDs {
+		end := min(start+maxBulkTicketIDs, len(stale))
+		result, err := postsCollection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": stale[start:end]}})
+		if err != nil {
+			recordAudit(ctx, auditDelete, "", deleted)
+			return deleted, err
+		}
+		deleted += result.DeletedCount
+	}
+	recordAudit(ctx, auditDelete, "", deleted)
+
+	return deleted, nil
+}
+
+// ComplaintUpdate holds the fields of a ticket that change after it is
+// first reported. Nil fields are left untouched.
+type ComplaintUpdate struct {
+	State        *string `json:"state"`
+	PhotoAfter   *string `json:"photo_after"`
+	Note         *string `json:"note"`
+	LastActivity *string `json:"last_activity"`
+	CountReopen  *int    `json:"count_reopen"`
+}
+
+func (u ComplaintUpdate) validate() error {
+	if u.State == nil && u.PhotoAfter == nil && u.Note == nil && u.LastActivity == nil && u.CountReopen == nil {
+		return errors.New("no updatable fields provided")
+	}
+	if u.State != nil && (*u.State == "" || !isValidState(*u.State)) {
+		return fmt.Errorf("invalid state %q", *u.State)
+	}
+	if u.LastActivity != nil {
+		_, dateErr := parseDate(*u.LastActivity)
+		_, timestampErr := time.Parse(upstreamTimestampLayout, *u.LastActivity)
+		if dateErr != nil && timestampErr != nil {
+			return fmt.Errorf("invalid last_activity %q", *u.LastActivity)
+		}
+	}
+	if u.CountReopen != nil && *u.CountReopen < 0 {
+		return errors.New("count_reopen must not be negative")
+	}
+	return nil
+}
+
+// set builds the $set document, using Feature field names when the stored
+// document came from the JSON API and flat Complaint names otherwise.
+func (u ComplaintUpdate) set(feature bool) bson.M {
+	set := bson.M{}
+	prefix := ""
+	photoAfter := "photo_after"
+	if feature {
+		prefix = "properties."
+		photoAfter = "after_photo"
+	}
+
+	if u.State != nil {
+		set[prefix+"state"] = *u.State
+	}
+	if u.PhotoAfter != nil {
+		set[prefix+photoAfter] = *u.PhotoAfter
+	}
+	if u.Note != nil {
+		set[prefix+"note"] = *u.Note
+	}
+	if u.LastActivity != nil {
+		set[prefix+"last_activity"] = *u.LastActivity
+	}
+	if u.CountReopen != nil {
+		if feature {
+			set[prefix+"count_reopen"] = *u.CountReopen
+		} else {
+			set["count_reopen"] = strconv.Itoa(*u.CountReopen)
+		}
+	}
+	return set
+}
+
+// updateComplaint applies u to the stored ticket and returns the updated
+// document. It returns mongo.ErrNoDocuments when the ticket does not exist.
+func updateComplaint(ctx context.Context, ticketID string, u ComplaintUpdate) (bson.M, error) {
+	ctx, cancel := withMongoTimeout(ctx, mongoOpTimeout)
+	defer cancel()
+
+	var existing bson.Raw
+	if err := postsCollection.FindOne(ctx, ticketFilter(ticketID)).Decode(&existing); err != nil {
+		return nil, err
+	}
+	_, isFeature := existing.Lookup("properties").DocumentOK()
+
+	var updated bson.M
+	err := postsCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": existing.Lookup("_id")},
+		bson.M{"$set": u.set(isFeature)},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err == nil {
+		recordAudit(ctx, auditUpdate, ticketID, 1)
+	}
+
+	return updated, err
+}
+
+var districtsCache = newTTLCache[[]string](5 * time.Minute)
+
+// distinctDistricts returns every district stored under either document
+// schema, deduplicated and sorted.
+func distinctDistricts(ctx context.Context) ([]string, error) {
+	if districts, ok := districtsCache.Get(""); ok {
+		return districts, nil
+	}
+
+	ctx, cancel := withMongoTimeout(ctx, mongoOpTimeout)
+	defer cancel()
+
+	seen := map[string]bool{}
+	for _, field := range []string{"properties.district", "district"} {
+		values, err := postsCollection.Distinct(ctx, field, bson.D{})
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			if s, ok := v.(string); ok && s != "" {
+				seen[s] = true
+			}
+		}
+	}
+
+	districts := make([]string, 0, len(seen))
+	for d := range seen {
+		districts = append(districts, d)
+	}
+	sort.Strings(districts)
+
+	districtsCache.Set("", districts)
+
+	return districts, nil
+}
+
+// subdistrictsCache holds the subdistricts of recently requested districts.
+// It is bounded because the district comes from the query string.
+var subdistrictsCache = NewLRUCache[string, []string](256, 5*time.Minute)
+
+// distinctSubdistricts returns the subdistricts stored for district under
+// either document schema, deduplicated and sorted.
+func distinctSubdistricts(ctx context.Context, district string) ([]string, error) {
+	if subdistricts, ok := subdistrictsCache.Get(district); ok {
+		return subdistricts, nil
+	}
+
+	ctx, cancel := withMongoTimeout(ctx, mongoOpTimeout)
+	defer cancel()
+
+	seen := map[string]bool{}
+	for _, prefix := range []string{"properties.", ""} {
+		values, err := postsCollection.Distinct(ctx, prefix+"subdistrict", bson.D{{Key: prefix + "district", Value: district}})
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			if s, ok := v.(string); ok && s != "" {
+				seen[s] = true
+			}
+		}
+	}
+
+	subdistricts := make([]string, 0, len(seen))
+	for s := range seen {
+		subdistricts = append(subdistricts, s)
+	}
+	sort.Strings(subdistricts)
+
+	subdistrictsCache.Set(district, subdistricts)
+
+	return subdistricts, nil
+}
+
+const maxBulkTicketIDs = 500
+
+// bulkUpdate applies update to every listed ticket. Each schema is updated
+// separately since their field paths differ.
+func bulkUpdate(ctx context.Context, ticketIDs []string, update ComplaintUpdate) (int64, int64, error) {
+	ctx, cancel := withMongoTimeout(ctx, mongoOpTimeout)
+	defer cancel()
+
+	var matched, modified int64
+	for _, isFeature := range []bool{true, false} {
+		key := "ticket_id"
+		if isFeature {
+			key = "properties.ticket_id"
+		}
+
+		result, err := postsCollection.UpdateMany(ctx,
+			bson.M{key: bson.M{"$in": ticketIDs}},
+			bson.M{"$set": update.set(isFeature)},
+		)
+		if err != nil {
+			recordAudit(ctx, auditUpdate, "", modified)
+			return matched, modified, err
+		}
+		matched += result.MatchedCount
+		modified += result.ModifiedCount
+	}
+	recordAudit(ctx, auditUpdate, "", modified)
+
+	return matched, modified, nil
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestComplaintsSchemaFilter(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	featureDoc := bson.D{
+		{Key: "_id", Value: primitive.NewObjectID()},
+		{Key: "type", Value: "Feature"},
+		{Key: "properties", Value: bson.D{{Key: "ticket_id", Value: "F1"}, {Key: "state", Value: "finish"}}},
+		{Key: schemaField, Value: schemaFeature},
+	}
+	complaintDoc := bson.D{
+		{Key: "_id", Value: primitive.NewObjectID()},
+		{Key: "ticket_id", Value: "C1"},
+		{Key: "state", Value: "start"},
+		{Key: schemaField, Value: schemaComplaint},
+	}
+
+	tests := []struct {
+		name           string
+		query          string
+		stored         bson.D
+		wantProperties bool
+		wantSchema     string
+		wantTicket     string
+	}{
+		{"unfiltered", "", featureDoc, true, "", "F1"},
+		{"feature", "?schema=feature", featureDoc, true, schemaFeature, "F1"},
+		{"complaint", "?schema=complaint", complaintDoc, false, schemaComplaint, "C1"},
+	}
+	for _, tc := range tests {
+		mt.Run(tc.name, func(mt *mtest.T) {
+			useCollection(mt.T, mt.Coll)
+			r := newTestRouter(mt.T, Config{})
+
+			ns := mt.DB.Name() + "." + mt.Coll.Name()
+			mt.AddMockResponses(
+				mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, bson.D{{Key: "n", Value: 1}}),
+				mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, tc.stored),
+			)
+
+			w := serve(r, http.MethodGet, "/api/v1/complaints"+tc.query, "")
+			if w.Code != http.StatusOK {
+				mt.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+			}
+			var page ComplaintsPage
+			if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+				mt.Fatalf("decode body: %v", err)
+			}
+			if len(page.Data) != 1 || page.Data[0].Properties.TicketID != tc.wantTicket {
+				mt.Errorf("data = %+v, want ticket %s", page.Data, tc.wantTicket)
+			}
+
+			events := mt.GetAllStartedEvents()
+			if len(events) != 2 {
+				mt.Fatalf("sent %d commands, want a count and a find", len(events))
+			}
+			for _, started := range events {
+				filter := started.Command.Lookup("filter")
+				if started.CommandName == "aggregate" {
+					filter = started.Command.Lookup("pipeline", "0", "$match")
+				}
+
+				_, err := filter.Document().LookupErr("properties")
+				if hasProperties := err == nil; hasProperties != tc.wantProperties {
+					mt.Errorf("%s filter %v: restricts to documents with properties = %v, want %v", started.CommandName, filter, hasProperties, tc.wantProperties)
+				}
+				schema, _ := filter.Document().Lookup(schemaField).StringValueOK()
+				if schema != tc.wantSchema {
+					mt.Errorf("%s filter %v: %s = %q, want %q", started.CommandName, filter, schemaField, schema, tc.wantSchema)
+				}
+			}
+		})
+	}
+}
+
+func TestSubdistrictsUnknownDistrict(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("unknown", func(mt *mtest.T) {
+		useCollection(mt.T, mt.Coll)
+		prev := subdistrictsCache
+		mt.Cleanup(func() { subdistrictsCache = prev })
+		subdistrictsCache = NewLRUCache[string, []string](256, time.Minute)
+		r := newTestRouter(mt.T, Config{})
+
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "values", Value: bson.A{}}),
+			mtest.CreateSuccessResponse(bson.E{Key: "values", Value: bson.A{}}),
+		)
+
+		w := serve(r, http.MethodGet, "/api/v1/complaints/subdistricts?district=Atlantis", "")
+		if w.Code != http.StatusOK {
+			mt.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+		}
+		if body := w.Body.String(); body != `{"district":"Atlantis","subdistricts":[]}` {
+			mt.Errorf("body = %s, want an empty subdistricts array", body)
+		}
+
+		events := mt.GetAllStartedEvents()
+		if len(events) != 2 {
+			mt.Fatalf("sent %d commands, want a distinct per schema", len(events))
+		}
+		for _, started := range events {
+			key, _ := started.Command.Lookup("key").StringValueOK()
+			district := started.Command.Lookup("query").Document().Index(0)
+			if started.CommandName != "distinct" || !strings.HasSuffix(key, "subdistrict") || district.Value().StringValue() != "Atlantis" {
+				mt.Errorf("sent %s on %q filtered by %v, want distinct subdistricts of Atlantis", started.CommandName, key, district)
+			}
+		}
+	})
+}
+
+func TestSearchComplaints(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("ranked page", func(mt *mtest.T) {
+		useCollection(mt.T, mt.Coll)
+		ns := mt.DB.Name() + "." + mt.Coll.Name()
+
+		feature := func(ticketID string, score float64) bson.D {
+			return bson.D{
+				{Key: "type", Value: "Feature"},
+				{Key: "properties", Value: bson.D{{Key: "ticket_id", Value: ticketID}}},
+				{Key: "score", Value: score},
+			}
+		}
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, bson.D{{Key: "n", Value: 3}}),
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, feature("F2", 1.5), feature("F1", 0.75)),
+		)
+
+		page, err := searchComplaints(context.Background(), "ถนนพัง", ComplaintFilter{State: "start"}, 0, 2)
+		if err != nil {
+			mt.Fatalf("searchComplaints: %v", err)
+		}
+		if len(page.Data) != 2 || page.Data[0].Properties.TicketID != "F2" || page.Meta.Total != 3 || !page.Meta.HasMore {
+			mt.Errorf("page = %+v, want F2 and F1 of 3 with more to come", page)
+		}
+
+		events := mt.GetAllStartedEvents()
+		if len(events) != 2 || events[1].CommandName != "find" {
+			mt.Fatalf("sent %d commands, want a count and a find", len(events))
+		}
+		find := events[1]
+		if q, _ := find.Command.Lookup("filter", "$text", "$search").StringValueOK(); q != "ถนนพัง" {
+			mt.Errorf("$text.$search = %q, want ถนนพัง", q)
+		}
+		if state, _ := find.Command.Lookup("filter", "properties.state").StringValueOK(); state != "start" {
+			mt.Errorf("filter %v does not keep the state filter", find.Command.Lookup("filter"))
+		}
+		for _, path := range [][]string{{"sort", "score", "$meta"}, {"projection", "score", "$meta"}} {
+			if meta, _ := find.Command.Lookup(path...).StringValueOK(); meta != "textScore" {
+				mt.Errorf("%s = %v, want textScore", strings.Join(path, "."), find.Command.Lookup(path...))
+			}
+		}
+		if limit, _ := find.Command.Lookup("limit").AsInt64OK(); limit != 2 {
+			mt.Errorf("limit = %d, want 2", limit)
+		}
+	})
+}
+
+func TestSearchQueryLength(t *testing.T) {
+	useStore(t)
+	r := newTestRouter(t, Config{})
+
+	for _, q := range []string{"", "+", "a", "ถ", "++a++"} {
+		if w := serve(r, http.MethodGet, "/api/v1/complaints/search?q="+q, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("q=%q: status = %d, want 400", q, w.Code)
+		}
+	}
+	for _, q := range []string{"ab", "ถน"} {
+		if w := serve(r, http.MethodGet, "/api/v1/complaints/search?q="+q, ""); w.Code != http.StatusOK {
+			t.Errorf("q=%q: status = %d, want 200: %s", q, w.Code, w.Body)
+		}
+	}
+}
+
+func TestStaleBeforeComparesAsString(t *testing.T) {
+	threshold := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	before := staleBefore(threshold)
+	if before != "2024-03-01 07:00:00" {
+		t.Fatalf("staleBefore = %q, want the threshold in Bangkok time", before)
+	}
+
+	tests := []struct {
+		lastActivity string
+		stale        bool
+	}{
+		{"2023-12-31 23:59:59", true},
+		{"2024-02-29 23:00:00", true},
+		{"2024-03-01 06:59:59", true},
+		{"2024-03-01 07:00:00", false},
+		{"2024-03-01 10:00:00", false},
+		{"2024-11-01 00:00:00", false},
+	}
+	for _, tc := range tests {
+		if stale := tc.lastActivity < before; stale != tc.stale {
+			t.Errorf("%s < %s = %v, want %v", tc.lastActivity, before, stale, tc.stale)
+		}
+	}
+}
+
+func TestStaleComplaints(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("query", func(mt *mtest.T) {
+		useCollection(mt.T, mt.Coll)
+		ns := mt.DB.Name() + "." + mt.Coll.Name()
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, bson.D{{Key: "n", Value: 1}}),
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, bson.D{
+				{Key: "type", Value: "Feature"},
+				{Key: "properties", Value: bson.D{{Key: "ticket_id", Value: "old"}, {Key: "last_activity", Value: "2024-01-15 09:00:00"}}},
+			}),
+		)
+
+		threshold := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+		page, err := staleComplaints(context.Background(), threshold, "inprogress", 10, 5)
+		if err != nil {
+			mt.Fatalf("staleComplaints: %v", err)
+		}
+		if len(page.Data) != 1 || page.Data[0].Properties.TicketID != "old" || page.Meta.Total != 1 {
+			mt.Errorf("page = %+v, want the one old ticket", page)
+		}
+
+		events := mt.GetAllStartedEvents()
+		if len(events) != 2 || events[1].CommandName != "find" {
+			mt.Fatalf("sent %d commands, want a count and a find", len(events))
+		}
+		find := events[1].Command
+		lastActivity := find.Lookup("filter", "properties.last_activity").Document()
+		if lt, _ := lastActivity.Lookup("$lt").StringValueOK(); lt != "2024-03-01 07:00:00" {
+			mt.Errorf("$lt = %v, want the threshold as a Bangkok time string", lastActivity.Lookup("$lt"))
+		}
+		if gt, ok := lastActivity.Lookup("$gt").StringValueOK(); !ok || gt != "" {
+			mt.Errorf("$gt = %v, want blank last_activity excluded", lastActivity.Lookup("$gt"))
+		}
+		if state, _ := find.Lookup("filter", "properties.state").StringValueOK(); state != "inprogress" {
+			mt.Errorf("state filter = %q, want inprogress", state)
+		}
+		if sort := find.Lookup("sort", "properties.last_activity").AsInt64(); sort != 1 {
+			mt.Errorf("sorted by last_activity %d, want least recently updated first", sort)
+		}
+		if skip, limit := find.Lookup("skip").AsInt64(), find.Lookup("limit").AsInt64(); skip != 10 || limit != 5 {
+			mt.Errorf("skip %d limit %d, want 10 and 5", skip, limit)
+		}
+	})
+}
+
+func TestStaleDaysValidated(t *testing.T) {
+	useStore(t)
+	r := newTestRouter(t, Config{})
+
+	for _, query := range []string{"days=0", "days=366", "days=x", "state=unknown"} {
+		if w := serve(r, http.MethodGet, "/api/v1/complaints/stale?"+query, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", query, w.Code)
+		}
+	}
+	if w := serve(r, http.MethodGet, "/api/v1/complaints/stale?days=365", ""); w.Code != http.StatusOK {
+		t.Errorf("days=365: status = %d, want 200", w.Code)
+	}
+}
+
+func TestDistrictsDeduplicatedAndSorted(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("both schemas", func(mt *mtest.T) {
+		useCollection(mt.T, mt.Coll)
+		prev := districtsCache
+		mt.Cleanup(func() { districtsCache = prev })
+		districtsCache = newTTLCache[[]string](time.Minute)
+		r := newTestRouter(mt.T, Config{})
+
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "values", Value: bson.A{"Pathum Wan", "Bang Kapi", "", "Dusit"}}),
+			mtest.CreateSuccessResponse(bson.E{Key: "values", Value: bson.A{"Dusit", "Bang Rak", nil, "Bang Kapi"}}),
+		)
+
+		w := serve(r, http.MethodGet, "/api/v1/districts", "")
+		if w.Code != http.StatusOK {
+			mt.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+		}
+		if body := w.Body.String(); body != `{"districts":["Bang Kapi","Bang Rak","Dusit","Pathum Wan"]}` {
+			mt.Errorf("body = %s, want each district once in order", body)
+		}
+
+		var fields []string
+		for _, started := range mt.GetAllStartedEvents() {
+			fields = append(fields, started.Command.Lookup("key").StringValue())
+		}
+		if strings.Join(fields, ",") != "properties.district,district" {
+			mt.Errorf("distinct on %v, want properties.district and district", fields)
+		}
+
+		// A second request inside the TTL is served from the cache.
+		if w := serve(r, http.MethodGet, "/api/v1/districts", ""); w.Code != http.StatusOK || len(mt.GetAllStartedEvents()) != 2 {
+			mt.Errorf("cached request: status = %d after %d commands, want 200 without querying again", w.Code, len(mt.GetAllStartedEvents()))
+		}
+	})
+}
+
+func TestParseDistricts(t *testing.T) {
+	names := func(n int) []string {
+		names := make([]string, n)
+		for i := range names {
+			names[i] = fmt.Sprintf("D%d", i)
+		}
+		return names
+	}
+	many := func(n int) string { return url.QueryEscape(strings.Join(names(n), ",")) }
+	tests := []struct {
+		query  string
+		want   []string
+		wantOK bool
+	}{
+		{"", nil, true},
+		{"district=", nil, true},
+		{"district=Bang+Rak", []string{"Bang Rak"}, true},
+		{"district=+Lat+Phrao+,Wang+Thong+Lang,,+", []string{"Lat Phrao", "Wang Thong Lang"}, true},
+		{"district=" + many(maxDistricts), names(maxDistricts), true},
+		{"district=" + many(maxDistricts+1), nil, false},
+	}
+	for _, tc := range tests {
+		c, w := queryContext(tc.query)
+		got, ok := parseDistricts(c)
+		if ok != tc.wantOK || !slices.Equal(got, tc.want) {
+			t.Errorf("parseDistricts(%q) = %q, %v, want %q, %v", tc.query, got, ok, tc.want, tc.wantOK)
+		}
+		if !ok && w.Code != http.StatusBadRequest {
+			t.Errorf("parseDistricts(%q) wrote status %d, want 400", tc.query, w.Code)
+		}
+	}
+}
+
+func TestComplaintFilterDistricts(t *testing.T) {
+	districts := []string{"Lat Phrao", "Wang Thong Lang"}
+	filter := ComplaintFilter{Districts: districts}
+
+	for _, prefix := range []string{"properties.", ""} {
+		doc := filter.bsonFor(prefix)
+		if len(doc) != 1 || doc[0].Key != prefix+"district" {
+			t.Fatalf("bsonFor(%q) = %v, want one condition on %sdistrict", prefix, doc, prefix)
+		}
+		in, _ := doc[0].Value.(bson.M)["$in"].([]string)
+		if !slices.Equal(in, districts) {
+			t.Errorf("bsonFor(%q) = %v, want $in %q", prefix, doc, districts)
+		}
+	}
+	if doc := (ComplaintFilter{}).bsonFor("properties."); len(doc) != 0 {
+		t.Errorf("no districts: bsonFor = %v, want no conditions", doc)
+	}
+
+	feature := func(ticketID, district string) Feature {
+		f := testFeature(ticketID)
+		f.Properties.District = district
+		return f
+	}
+	features := filterFeaturesByDistrict([]Feature{feature("F1", "Lat Phrao"), feature("F2", "Bang Rak"), feature("F3", "Wang Thong Lang")}, districts)
+	if len(features) != 2 || features[0].Properties.TicketID != "F1" || features[1].Properties.TicketID != "F3" {
+		t.Errorf("filterFeaturesByDistrict kept %+v, want F1 and F3", features)
+	}
+	complaints := filterComplaintsByDistrict([]Complaint{{TicketID: "C1", District: "Bang Rak"}, {TicketID: "C2", District: "Wang Thong Lang"}}, districts)
+	if len(complaints) != 1 || complaints[0].TicketID != "C2" {
+		t.Errorf("filterComplaintsByDistrict kept %+v, want C2", complaints)
+	}
+	if got := filterComplaintsByDistrict(nil, districts); got == nil {
+		t.Error("filterComplaintsByDistrict(nil) = nil, want an empty slice for JSON")
+	}
+}
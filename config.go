@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultMongoURI       = "mongodb://localhost:27023"
+	defaultDatabaseName   = "traffyFondue"
+	defaultCollectionName = "postsTraffyFondue"
+	defaultAuditName      = "audit"
+	defaultHistoryName    = "history"
+	defaultMongoMaxPool   = 100
+	defaultMongoMinPool   = 5
+	defaultMongoIdleTime  = 30 * time.Second
+	defaultMongoOpTimeout = 30 * time.Second
+	defaultServerPort     = "8000"
+	defaultHTTPTimeout    = 30 * time.Second
+
+	defaultUpstreamCacheSize = 64
+	defaultUpstreamCacheTTL  = 60 * time.Second
+
+	defaultMaxRequestBodyMB = 50
+
+	defaultJWTRefreshGrace = 5 * time.Minute
+)
+
+type Config struct {
+	MongoURI       string
+	DatabaseName   string
+	CollectionName string
+	AuditName      string
+	HistoryName    string
+	MongoMaxPool   uint64
+	MongoMinPool   uint64
+	MongoIdleTime  time.Duration
+	MongoOpTimeout time.Duration
+	ServerPort     string
+	TraffyBaseURL  string
+	HTTPTimeout    time.Duration
+	RetryAttempts  int
+	RetryBaseDelay time.Duration
+	IngestWorkers  int
+	SyncInterval   time.Duration
+	LogFormat      string
+	JWTSecret      string
+	AuthUsername   string
+	AuthPassword   string
+	TokenTTL       time.Duration
+
+	// JWTRefreshGrace is how long after expiry a token may still be
+	// exchanged at POST /auth/refresh.
+	JWTRefreshGrace time.Duration
+
+	UpstreamCacheSize int
+	UpstreamCacheTTL  time.Duration
+
+	CORSOrigins []string
+	DebugMode   bool
+
+	UpstreamRPS   float64
+	UpstreamBurst int
+
+	WebhookURL string
+
+	MaxRequestBodyBytes int64
+
+	// MongoReplicaSet, when set, joins that replica set and reads from
+	// secondaries where possible.
+	MongoReplicaSet string
+}
+
+// loadConfig reads settings from the environment, falling back to the values
+// the service has always used when a variable is unset or invalid.
+func loadConfig() Config {
+	return Config{
+		MongoURI:       getEnv("MONGO_URI", defaultMongoURI),
+		DatabaseName:   getEnv("MONGO_DB", defaultDatabaseName),
+		CollectionName: getEnv("MONGO_COLLECTION", defaultCollectionName),
+		AuditName:      getEnv("MONGO_AUDIT_COLLECTION", defaultAuditName),
+		HistoryName:    getEnv("MONGO_HISTORY_COLLECTION", defaultHistoryName),
+		MongoMaxPool:   uint64(getEnvInt("MONGO_MAX_POOL", defaultMongoMaxPool)),
+		MongoMinPool:   uint64(getEnvCount("MONGO_MIN_POOL", defaultMongoMinPool)),
+		MongoIdleTime:  time.Duration(getEnvInt("MONGO_IDLE_TIMEOUT_SEC", int(defaultMongoIdleTime/time.Second))) * time.Second,
+		MongoOpTimeout: time.Duration(getEnvInt("MONGO_OP_TIMEOUT_SEC", int(defaultMongoOpTimeout/time.Second))) * time.Second,
+		ServerPort:     getEnv("SERVER_PORT", defaultServerPort),
+		TraffyBaseURL:  getEnv("TRAFFY_BASE_URL", defaultTraffyBaseURL),
+		HTTPTimeout:    time.Duration(getEnvInt("HTTP_TIMEOUT_SECONDS", int(defaultHTTPTimeout/time.Second))) * time.Second,
+		RetryAttempts:  getEnvInt("FETCH_RETRY_ATTEMPTS", 3),
+		RetryBaseDelay: time.Duration(getEnvInt("FETCH_RETRY_BASE_DELAY_MS", 500)) * time.Millisecond,
+		IngestWorkers:  getEnvInt("INGEST_WORKERS", 4),
+		SyncInterval:   time.Duration(getEnvInt("SYNC_INTERVAL_MINUTES", 0)) * time.Minute,
+		LogFormat:      getEnv("LOG_FORMAT", "text"),
+		JWTSecret:      os.Getenv("JWT_SECRET"),
+		AuthUsername:   os.Getenv("AUTH_USERNAME"),
+		AuthPassword:   os.Getenv("AUTH_PASSWORD"),
+		TokenTTL:       time.Duration(getEnvInt("JWT_TTL_MINUTES", 60)) * time.Minute,
+
+		JWTRefreshGrace: time.Duration(getEnvInt("JWT_REFRESH_GRACE_SECONDS", int(defaultJWTRefreshGrace/time.Second))) * time.Second,
+
+		UpstreamCacheSize: getEnvInt("UPSTREAM_CACHE_SIZE", defaultUpstreamCacheSize),
+		UpstreamCacheTTL:  time.Duration(getEnvInt("UPSTREAM_CACHE_TTL_SECONDS", int(defaultUpstreamCacheTTL/time.Second))) * time.Second,
+
+		CORSOrigins: splitList(os.Getenv("CORS_ORIGINS")),
+		DebugMode:   os.Getenv("DEBUG_MODE") == "true",
+
+		UpstreamRPS:   getEnvFloat("UPSTREAM_RPS", 2),
+		UpstreamBurst: getEnvInt("UPSTREAM_BURST", 5),
+
+		WebhookURL: os.Getenv("WEBHOOK_URL"),
+
+		MaxRequestBodyBytes: int64(getEnvInt("MAX_REQUEST_BODY_MB", defaultMaxRequestBodyMB)) << 20,
+
+		MongoReplicaSet: os.Getenv("MONGO_REPLICA_SET"),
+	}
+}
+
+// validate reports settings that are each valid on their own but cannot
+// be combined. The driver would only reject them on first use.
+func (c Config) validate() error {
+	if c.MongoMinPool > c.MongoMaxPool {
+		return fmt.Errorf("MONGO_MIN_POOL (%d) must not be greater than MONGO_MAX_POOL (%d)", c.MongoMinPool, c.MongoMaxPool)
+	}
+	return nil
+}
+
+const maxBatchSize = 100000
+
+// BatchConfig controls how many records each ingestion iteration covers.
+type BatchConfig struct {
+	JSONBatchSize int
+	CSVBatchSize  int
+}
+
+// loadBatchConfig reads JSON_BATCH_SIZE and CSV_BATCH_SIZE, defaulting to
+// 1000 and 25000. Unlike other settings an invalid value is an error rather
+// than silently ignored, since it changes how much data is ingested.
+func loadBatchConfig() (BatchConfig, error) {
+	jsonSize, err := getEnvBatchSize("JSON_BATCH_SIZE", 1000)
+	if err != nil {
+		return BatchConfig{}, err
+	}
+
+	csvSize, err := getEnvBatchSize("CSV_BATCH_SIZE", 25000)
+	if err != nil {
+		return BatchConfig{}, err
+	}
+
+	return BatchConfig{JSONBatchSize: jsonSize, CSVBatchSize: csvSize}, nil
+}
+
+func getEnvBatchSize(key string, fallback int) (int, error) {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback, nil
+	}
+
+	v, err := strconv.Atoi(raw)
+	if err != nil || v < 1 || v > maxBatchSize {
+		return 0, fmt.Errorf("%s must be an integer between 1 and %d, got %q", key, maxBatchSize, raw)
+	}
+
+	return v, nil
+}
+
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || v <= 0 {
+		return fallback
+	}
+	return v
+}
+
+// getEnvCount is getEnvInt for settings where 0 is a valid value.
+func getEnvCount(key string, fallback int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || v < 0 {
+		return fallback
+	}
+	return v
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	v, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil || v <= 0 {
+		return fallback
+	}
+	return v
+}
+
+// initLogger installs the default slog logger: JSON for log aggregators in
+// production, human-readable text otherwise.
+func initLogger(format string) {
+	var handler slog.Handler
+	if format == "json" {
+		handler = slog.NewJSONHandler(os.Stdout, nil)
+	} else {
+		handler = slog.NewTextHandler(os.Stdout, nil)
+	}
+	slog.SetDefault(slog.New(handler))
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMongoMinPoolAcceptsZero(t *testing.T) {
+	t.Setenv("MONGO_MIN_POOL", "0")
+	if got := loadConfig().MongoMinPool; got != 0 {
+		t.Errorf("MongoMinPool = %d, want 0", got)
+	}
+
+	t.Setenv("MONGO_MIN_POOL", "-1")
+	if got := loadConfig().MongoMinPool; got != defaultMongoMinPool {
+		t.Errorf("MongoMinPool = %d for a negative value, want the default %d", got, defaultMongoMinPool)
+	}
+}
+
+func TestConfigValidatePoolSizes(t *testing.T) {
+	t.Setenv("MONGO_MAX_POOL", "10")
+	t.Setenv("MONGO_MIN_POOL", "20")
+	if err := loadConfig().validate(); err == nil {
+		t.Error("validate accepted MONGO_MIN_POOL > MONGO_MAX_POOL")
+	}
+
+	t.Setenv("MONGO_MIN_POOL", "10")
+	if err := loadConfig().validate(); err != nil {
+		t.Errorf("validate rejected equal pool sizes: %v", err)
+	}
+}
+
+func TestHTTPTimeoutFromEnv(t *testing.T) {
+	t.Setenv("HTTP_TIMEOUT_SECONDS", "7")
+	if got := loadConfig().HTTPTimeout; got != 7*time.Second {
+		t.Errorf("HTTPTimeout = %v, want 7s", got)
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// e2eRouter wires the API to a fake upstream running handler and to a
+// MemoryStore standing in for MongoDB, the way main wires the real ones.
+func e2eRouter(t *testing.T, handler http.HandlerFunc) (http.Handler, *MemoryStore) {
+	t.Helper()
+
+	cfg := useUpstream(t, handler)
+	cfg.JWTSecret = testJWTSecret
+	store := useStore(t)
+	return newTestRouter(t, cfg), store
+}
+
+func failingUpstream(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "upstream is down", http.StatusInternalServerError)
+}
+
+func decodeBody(t *testing.T, body []byte, v any) {
+	t.Helper()
+	if err := json.Unmarshal(body, v); err != nil {
+		t.Fatalf("decode body %q: %v", body, err)
+	}
+}
+
+func TestE2EGetFeatures(t *testing.T) {
+	r, _ := e2eRouter(t, pagedUpstream(3))
+
+	w := serve(r, http.MethodGet, "/api/v1/?offset=0&limit=10", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var data Data
+	decodeBody(t, w.Body.Bytes(), &data)
+	if data.Total != 3 || len(data.Features) != 3 || data.Features[0].Properties.TicketID != "T0" {
+		t.Errorf("body = %+v, want the 3 upstream features", data)
+	}
+
+	for _, tc := range []struct{ query, why string }{
+		{"?limit=10", "missing offset"},
+		{"?offset=0&limit=0", "zero limit"},
+		{"?offset=0&limit=10&start=01/02/2024", "bad start date"},
+		{"?offset=0&limit=10&state=bogus", "unknown state"},
+	} {
+		w := serve(r, http.MethodGet, "/api/v1/"+tc.query, "")
+		var body map[string]any
+		decodeBody(t, w.Body.Bytes(), &body)
+		if w.Code != http.StatusBadRequest || body["error"] == nil {
+			t.Errorf("%s: status = %d, body = %v; want 400 with an error", tc.why, w.Code, body)
+		}
+	}
+}
+
+func TestE2EGetFeaturesUpstreamError(t *testing.T) {
+	r, _ := e2eRouter(t, failingUpstream)
+
+	w := serve(r, http.MethodGet, "/api/v1/?offset=0&limit=10", "")
+	var body map[string]any
+	decodeBody(t, w.Body.Bytes(), &body)
+	if w.Code != http.StatusInternalServerError || body["error"] != "Failed to fetch data" {
+		t.Errorf("status = %d, body = %v; want 500 Failed to fetch data", w.Code, body)
+	}
+}
+
+func TestE2ETopoJSON(t *testing.T) {
+	r, _ := e2eRouter(t, pagedUpstream(2))
+
+	w := serve(r, http.MethodGet, "/api/v1/topojson?offset=0&limit=10", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var complaints []Complaint
+	decodeBody(t, w.Body.Bytes(), &complaints)
+	if len(complaints) != 2 || complaints[1].TicketID != "T1" {
+		t.Errorf("body = %+v, want the 2 upstream rows", complaints)
+	}
+
+	w = serve(r, http.MethodGet, "/api/v1/topojson/valid?offset=0&limit=10", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("valid: status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var topology map[string]any
+	decodeBody(t, w.Body.Bytes(), &topology)
+	if topology["type"] != "Topology" {
+		t.Errorf("valid: type = %v, want Topology", topology["type"])
+	}
+
+	w = serve(r, http.MethodGet, "/api/v1/topojson?offset=0", "")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("missing limit: status = %d, want 400", w.Code)
+	}
+}
+
+func TestE2ETopoJSONUpstreamError(t *testing.T) {
+	r, _ := e2eRouter(t, failingUpstream)
+
+	w := serve(r, http.MethodGet, "/api/v1/topojson?offset=0&limit=10", "")
+	var body map[string]any
+	decodeBody(t, w.Body.Bytes(), &body)
+	if w.Code != http.StatusInternalServerError || body["error"] != "Failed to fetch CSV data" {
+		t.Errorf("status = %d, body = %v; want 500 Failed to fetch CSV data", w.Code, body)
+	}
+}
+
+func TestE2ESaveToMongoDB(t *testing.T) {
+	r, store := e2eRouter(t, pagedUpstream(25))
+
+	w := serve(r, http.MethodPost, "/api/v1/saveToMongoDB?offset=0&limit=10", "", "Authorization", bearer(t))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var body struct {
+		Status   string `json:"status"`
+		Inserted int    `json:"inserted"`
+		Failed   int    `json:"failed"`
+	}
+	decodeBody(t, w.Body.Bytes(), &body)
+	if body.Inserted != 25 || body.Failed != 0 {
+		t.Errorf("body = %+v, want 25 inserted", body)
+	}
+	if n, _ := store.CountComplaints(context.Background(), ComplaintFilter{Schema: schemaFeature}); n != 25 {
+		t.Errorf("store holds %d features, want 25", n)
+	}
+
+	// Saving again upserts in place.
+	serve(r, http.MethodPost, "/api/v1/saveToMongoDB?offset=0&limit=10", "", "Authorization", bearer(t))
+	if n, _ := store.CountComplaints(context.Background(), ComplaintFilter{}); n != 25 {
+		t.Errorf("store holds %d documents after a second save, want 25", n)
+	}
+}
+
+func TestE2ESaveToMongoDBInvalid(t *testing.T) {
+	r, store := e2eRouter(t, pagedUpstream(5))
+
+	for _, tc := range []struct {
+		target, auth string
+		want         int
+	}{
+		{"/api/v1/saveToMongoDB", "", http.StatusUnauthorized},
+		{"/api/v1/saveToMongoDB?limit=0", bearer(t), http.StatusBadRequest},
+		{"/api/v1/saveToMongoDB?offset=-1", bearer(t), http.StatusBadRequest},
+		{"/api/v1/saveToMongoDB?output_type=", bearer(t), http.StatusBadRequest},
+	} {
+		w := serve(r, http.MethodPost, tc.target, "", "Authorization", tc.auth)
+		if w.Code != tc.want {
+			t.Errorf("POST %s: status = %d, want %d: %s", tc.target, w.Code, tc.want, w.Body)
+		}
+	}
+	if n, _ := store.CountComplaints(context.Background(), ComplaintFilter{}); n != 0 {
+		t.Errorf("rejected requests stored %d documents", n)
+	}
+}
+
+func TestE2ESaveToMongoDBEmptyAndFailingUpstream(t *testing.T) {
+	r, store := e2eRouter(t, pagedUpstream(0))
+
+	w := serve(r, http.MethodPost, "/api/v1/saveToMongoDB", "", "Authorization", bearer(t))
+	var body map[string]any
+	decodeBody(t, w.Body.Bytes(), &body)
+	if w.Code != http.StatusOK || body["status"] != "No data to insert into MongoDB" {
+		t.Errorf("empty upstream: status = %d, body = %v", w.Code, body)
+	}
+
+	r, store = e2eRouter(t, failingUpstream)
+	w = serve(r, http.MethodPost, "/api/v1/saveToMongoDB", "", "Authorization", bearer(t))
+	decodeBody(t, w.Body.Bytes(), &body)
+	if w.Code != http.StatusInternalServerError || body["error"] != "Failed to save data to MongoDB" {
+		t.Errorf("failing upstream: status = %d, body = %v", w.Code, body)
+	}
+	if n, _ := store.CountComplaints(context.Background(), ComplaintFilter{}); n != 0 {
+		t.Errorf("failing upstream stored %d documents", n)
+	}
+}
+
+func TestE2ESaveToMongoDBCSV(t *testing.T) {
+	r, store := e2eRouter(t, pagedUpstream(25))
+
+	w := serve(r, http.MethodPost, "/api/v1/saveToMongoDBCSV?offset=0&limit=10", "", "Authorization", bearer(t))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var body struct {
+		Inserted int `json:"inserted"`
+		Failed   int `json:"failed"`
+	}
+	decodeBody(t, w.Body.Bytes(), &body)
+	if body.Inserted != 25 || body.Failed != 0 {
+		t.Errorf("body = %+v, want 25 inserted", body)
+	}
+	if n, _ := store.CountComplaints(context.Background(), ComplaintFilter{Schema: schemaComplaint}); n != 25 {
+		t.Errorf("store holds %d complaints, want 25", n)
+	}
+
+	w = serve(r, http.MethodPost, "/api/v1/saveToMongoDBCSV?offset=0&limit=10&dry_run=true", "", "Authorization", bearer(t))
+	var dryRun map[string]any
+	decodeBody(t, w.Body.Bytes(), &dryRun)
+	if w.Code != http.StatusOK || dryRun["would_insert"] != float64(25) {
+		t.Errorf("dry run: status = %d, body = %v; want 25 would_insert", w.Code, dryRun)
+	}
+	if n, _ := store.CountComplaints(context.Background(), ComplaintFilter{}); n != 25 {
+		t.Errorf("dry run changed the store to %d documents", n)
+	}
+}
+
+func TestE2ESaveDryRun(t *testing.T) {
+	r, store := e2eRouter(t, pagedUpstream(25))
+	ctx := context.Background()
+	store.InsertFeatures(ctx, []Feature{testFeature("EXISTING")})
+
+	for _, target := range []string{
+		"/api/v1/saveToMongoDB?dry_run=true",
+		"/api/v1/saveToMongoDBCSV?offset=0&limit=10&dry_run=true",
+	} {
+		w := serve(r, http.MethodPost, target, "", "Authorization", bearer(t))
+		var body map[string]any
+		decodeBody(t, w.Body.Bytes(), &body)
+		if w.Code != http.StatusOK || body["status"] != "dry_run" || body["would_insert"] != float64(25) {
+			t.Errorf("POST %s: status = %d, body = %v; want a dry_run reporting 25", target, w.Code, body)
+		}
+		if n, _ := store.CountComplaints(ctx, ComplaintFilter{}); n != 1 {
+			t.Errorf("POST %s: store holds %d documents, want the 1 it started with", target, n)
+		}
+	}
+
+	w := serve(r, http.MethodPost, "/api/v1/saveToMongoDB?dry_run=maybe", "", "Authorization", bearer(t))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("dry_run=maybe: status = %d, want 400", w.Code)
+	}
+}
+
+func TestE2ESaveToMongoDBCSVInvalid(t *testing.T) {
+	r, store := e2eRouter(t, failingUpstream)
+
+	for _, tc := range []struct {
+		target, auth string
+		want         int
+	}{
+		{"/api/v1/saveToMongoDBCSV", "", http.StatusUnauthorized},
+		{"/api/v1/saveToMongoDBCSV?start=2024-13-01", bearer(t), http.StatusBadRequest},
+		{"/api/v1/saveToMongoDBCSV?limit=abc", bearer(t), http.StatusBadRequest},
+		{"/api/v1/saveToMongoDBCSV", bearer(t), http.StatusInternalServerError},
+	} {
+		w := serve(r, http.MethodPost, tc.target, "", "Authorization", tc.auth)
+		var body map[string]any
+		decodeBody(t, w.Body.Bytes(), &body)
+		if w.Code != tc.want || body["error"] == nil {
+			t.Errorf("POST %s: status = %d, body = %v; want %d with an error", tc.target, w.Code, body, tc.want)
+		}
+	}
+	if n, _ := store.CountComplaints(context.Background(), ComplaintFilter{}); n != 0 {
+		t.Errorf("failed requests stored %d documents", n)
+	}
+}
+
+func TestE2ESaveToMongoDBStream(t *testing.T) {
+	t.Setenv("JSON_BATCH_SIZE", "2")
+	r, store := e2eRouter(t, pagedUpstream(5))
+
+	// c.Stream needs a real connection to watch for the client going away.
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/api/v1/saveToMongoDB/stream?start=2024-01-01&end=2024-01-02", nil)
+	req.Header.Set("Authorization", bearer(t))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Errorf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	type sse struct {
+		name   string
+		status IngestProgress
+	}
+	var events []sse
+	var name string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if after, ok := strings.CutPrefix(line, "event:"); ok {
+			name = after
+		} else if after, ok := strings.CutPrefix(line, "data:"); ok {
+			e := sse{name: name}
+			decodeBody(t, []byte(after), &e.status)
+			events = append(events, e)
+		}
+	}
+
+	want := []sse{
+		{"message", IngestProgress{Batch: 1, Inserted: 2, TotalSoFar: 2}},
+		{"message", IngestProgress{Batch: 2, Inserted: 2, TotalSoFar: 4}},
+		{"message", IngestProgress{Batch: 3, Inserted: 1, TotalSoFar: 5}},
+		{"done", IngestProgress{Batch: 3, Inserted: 1, TotalSoFar: 5}},
+	}
+	if !slices.Equal(events, want) {
+		t.Errorf("events =\n%+v\nwant\n%+v", events, want)
+	}
+	if n, _ := store.CountComplaints(context.Background(), ComplaintFilter{}); n != 5 {
+		t.Errorf("store holds %d features, want 5", n)
+	}
+}
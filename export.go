@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/xuri/excelize/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// complaintCSVHeaders lists the Complaint fields in the column order of the
+// upstream CSV export.
+var complaintCSVHeaders = []string{
+	"ticket_id", "type", "organization", "organization_action", "comment",
+	"coords", "photo", "photo_after", "address", "subdistrict", "district",
+	"province", "timestamp", "state", "star", "count_reopen", "last_activity",
+}
+
+func (c Complaint) csvRecord() []string {
+	return []string{
+		c.TicketID, c.Type, c.Organization, c.OrganizationAction, c.Comment,
+		c.Coords, c.Photo, c.PhotoAfter, c.Address, c.Subdistrict, c.District,
+		c.Province, c.Timestamp, c.State, c.Star, c.CountReopen, c.LastActivity,
+	}
+}
+
+// complaintXLSXLabels gives the Thai column title for each of
+// complaintCSVHeaders.
+var complaintXLSXLabels = map[string]string{
+	"ticket_id":           "รหัสเรื่อง",
+	"type":                "ประเภทปัญหา",
+	"organization":        "หน่วยงาน",
+	"organization_action": "หน่วยงานที่ดำเนินการ",
+	"comment":             "รายละเอียด",
+	"coords":              "พิกัด",
+	"photo":               "รูปภาพ",
+	"photo_after":         "รูปภาพหลังแก้ไข",
+	"address":             "ที่อยู่",
+	"subdistrict":         "แขวง",
+	"district":            "เขต",
+	"province":            "จังหวัด",
+	"timestamp":           "วันที่แจ้ง",
+	"state":               "สถานะ",
+	"star":                "คะแนน",
+	"count_reopen":        "จำนวนครั้งที่แจ้งซ้ำ",
+	"last_activity":       "อัปเดตล่าสุด",
+}
+
+// featureToComplaint flattens a Feature into the Complaint shape used by the
+// CSV API so both document schemas can be exported side by side.
+func featureToComplaint(f Feature) Complaint {
+	p := f.Properties
+
+	complaint := Complaint{
+		Address:      p.Address,
+		Comment:      p.Description,
+		CountReopen:  strconv.Itoa(p.CountReopen),
+		District:     p.District,
+		LastActivity: p.LastActivity,
+		Organization: strings.Join(p.Org, ", "),
+		Photo:        p.PhotoURL,
+		PhotoAfter:   p.AfterPhoto,
+		Province:     p.Province,
+		State:        p.State,
+		Subdistrict:  p.Subdistrict,
+		Timestamp:    p.Timestamp,
+		Type:         strings.Join(p.ProblemTypeFondue, ","),
+		TicketID:     p.TicketID,
+	}
+	if p.Star != nil {
+		complaint.Star = fmt.Sprint(p.Star)
+	}
+	if len(f.Geometry.Coordinates) == 2 {
+		complaint.Coords = fmt.Sprintf("%v,%v", f.Geometry.Coordinates[0], f.Geometry.Coordinates[1])
+	}
+
+	return complaint
+}
+
+// complaintToFeature is the inverse of featureToComplaint, for serving
+// complaints where the API returns features.
+func complaintToFeature(c Complaint) Feature {
+	feature := Feature{
+		Type: "Feature",
+		Properties: Properties{
+			Address:           c.Address,
+			Description:       c.Comment,
+			District:          c.District,
+			LastActivity:      c.LastActivity,
+			Org:               splitList(c.Organization),
+			PhotoURL:          c.Photo,
+			AfterPhoto:        c.PhotoAfter,
+			Province:          c.Province,
+			State:             c.State,
+			Subdistrict:       c.Subdistrict,
+			Timestamp:         c.Timestamp,
+			ProblemTypeFondue: splitList(c.Type),
+			TicketID:          c.TicketID,
+		},
+		Schema: c.Schema,
+	}
+	if reopen, err := strconv.Atoi(strings.TrimSpace(c.CountReopen)); err == nil {
+		feature.Properties.CountReopen = reopen
+	}
+	if c.Star != "" {
+		feature.Properties.Star = c.Star
+	}
+	if c.Geometry != nil {
+		feature.Geometry = *c.Geometry
+	}
+	feature.CreatedAt = c.CreatedAt
+	return feature
+}
+
+// decodeFeature decodes either stored schema into a Feature.
+func decodeFeature(raw bson.Raw) (Feature, error) {
+	if _, err := raw.LookupErr("properties"); err == nil {
+		var feature Feature
+		err := bson.Unmarshal(raw, &feature)
+		return feature, err
+	}
+
+	var complaint Complaint
+	if err := bson.Unmarshal(raw, &complaint); err != nil {
+		return Feature{}, err
+	}
+	return complaintToFeature(complaint), nil
+}
+
+// decodeComplaint decodes either stored schema into a Complaint.
+func decodeComplaint(raw bson.Raw) (Complaint, error) {
+	if _, err := raw.LookupErr("properties"); err == nil {
+		var feature Feature
+		if err := bson.Unmarshal(raw, &feature); err != nil {
+			return Complaint{}, err
+		}
+		return featureToComplaint(feature), nil
+	}
+
+	var complaint Complaint
+	err := bson.Unmarshal(raw, &complaint)
+	return complaint, err
+}
+
+// csvFlushRows is how many rows exportComplaintsCSV writes between flushes
+// to the client.
+const csvFlushRows = 500
+
+// exportComplaintsCSV writes every matching document to w one row at a time,
+// so memory use does not grow with the size of the result set. If w is an
+// http.Flusher the rows are pushed to the client every csvFlushRows.
+func exportComplaintsCSV(ctx context.Context, w io.Writer, filter ComplaintFilter) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(complaintCSVHeaders); err != nil {
+		return err
+	}
+
+	flusher, _ := w.(http.Flusher)
+	rows := 0
+	err := complaintStore.FindComplaints(ctx, filter, func(complaint Complaint) error {
+		if err := writer.Write(complaint.csvRecord()); err != nil {
+			return err
+		}
+
+		rows++
+		if rows%csvFlushRows == 0 {
+			writer.Flush()
+			if err := writer.Error(); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+type FeatureCollection struct {
+	Type     string    `json:"type"`
+	BBox     []float64 `json:"bbox,omitempty"`
+	Features []Feature `json:"features"`
+}
+
+var geoJSONGeometryTypes = map[string]bool{
+	"Point":              true,
+	"MultiPoint":         true,
+	"LineString":         true,
+	"MultiLineString":    true,
+	"Polygon":            true,
+	"MultiPolygon":       true,
+	"GeometryCollection": true,
+}
+
+// exportFeatureCollection returns a page of stored features as a GeoJSON
+// FeatureCollection. Features with an unknown geometry type or unusable
+// coordinates are skipped and logged instead of failing the whole export.
+func exportFeatureCollection(ctx context.Context, offset, limit int) (FeatureCollection, error) {
+	collection := FeatureCollection{Type: "FeatureCollection", Features: []Feature{}}
+
+	page, err := complaintStore.FindFeatures(ctx, ComplaintFilter{}, offset, limit, "")
+	if err != nil {
+		return collection, err
+	}
+
+	for _, f := range page.Data {
+		if !geoJSONGeometryTypes[f.Geometry.Type] {
+			slog.Warn("Skipping feature with unknown geometry type", "ticket_id", f.Properties.TicketID, "geometry_type", f.Geometry.Type)
+			continue
+		}
+		if len(f.Geometry.Coordinates) < 2 || len(f.Geometry.Coordinates)%2 != 0 {
+			slog.Warn("Skipping feature with malformed coordinates", "ticket_id", f.Properties.TicketID, "coordinates", f.Geometry.Coordinates)
+			continue
+		}
+
+		f.Type = "Feature"
+		collection.Features = append(collection.Features, f)
+		collection.BBox = extendBBox(collection.BBox, f.Geometry.Coordinates)
+	}
+
+	return collection, nil
+}
+
+// extendBBox grows bbox ([minLng, minLat, maxLng, maxLat]) to cover every
+// lng,lat pair in coords.
+func extendBBox(bbox []float64, coords []float64) []float64 {
+	for i := 0; i+1 < len(coords); i += 2 {
+		lng, lat := coords[i], coords[i+1]
+		if bbox == nil {
+			bbox = []float64{lng, lat, lng, lat}
+			continue
+		}
+		bbox[0] = math.Min(bbox[0], lng)
+		bbox[1] = math.Min(bbox[1], lat)
+		bbox[2] = math.Max(bbox[2], lng)
+		bbox[3] = math.Max(bbox[3], lat)
+	}
+	return bbox
+}
+
+// Column widths for the XLSX export, in characters.
+const (
+	minXLSXColumnWidth = 10
+	maxXLSXColumnWidth = 60
+)
+
+// exportComplaintsXLSX writes every matching document to w as a single-sheet
+// workbook with a frozen header row and columns sized to their content.
+func exportComplaintsXLSX(ctx context.Context, w io.Writer, filter ComplaintFilter) error {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	const sheet = "Complaints"
+	if err := f.SetSheetName(f.GetSheetName(0), sheet); err != nil {
+		return err
+	}
+
+	widths := make([]int, len(complaintCSVHeaders))
+	header := make([]interface{}, len(complaintCSVHeaders))
+	for i, name := range complaintCSVHeaders {
+		title := fmt.Sprintf("%s (%s)", complaintXLSXLabels[name], name)
+		header[i] = title
+		widths[i] = utf8.RuneCountInString(title)
+	}
+	if err := f.SetSheetRow(sheet, "A1", &header); err != nil {
+		return err
+	}
+
+	row := 1
+	err := complaintStore.FindComplaints(ctx, filter, func(complaint Complaint) error {
+		row++
+		record := complaint.csvRecord()
+		values := make([]interface{}, len(record))
+		for i, v := range record {
+			values[i] = v
+			widths[i] = max(widths[i], utf8.RuneCountInString(v))
+		}
+
+		cell, err := excelize.CoordinatesToCellName(1, row)
+		if err != nil {
+			return err
+		}
+		return f.SetSheetRow(sheet, cell, &values)
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, width := range widths {
+		col, err := excelize.ColumnNumberToName(i + 1)
+		if err != nil {
+			return err
+		}
+		width = min(max(width+2, minXLSXColumnWidth), maxXLSXColumnWidth)
+		if err := f.SetColWidth(sheet, col, col, float64(width)); err != nil {
+			return err
+		}
+	}
+
+	if err := f.SetPanes(sheet, &excelize.Panes{
+		Freeze:      true,
+		YSplit:      1,
+		TopLeftCell: "A2",
+		ActivePane:  "bottomLeft",
+	}); err != nil {
+		return err
+	}
+
+	return f.Write(w)
+}
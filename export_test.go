@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func TestExportXLSX(t *testing.T) {
+	store := useStore(t)
+	r := newTestRouter(t, Config{})
+
+	var complaints []Complaint
+	for i := 0; i < 3; i++ {
+		complaints = append(complaints, Complaint{TicketID: fmt.Sprintf("C%d", i), State: "start", District: "บางรัก"})
+	}
+	store.InsertComplaints(context.Background(), complaints)
+
+	w := serve(r, http.MethodGet, "/api/v1/complaints/export/xlsx", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+
+	f, err := excelize.OpenReader(bytes.NewReader(w.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("open workbook: %v", err)
+	}
+	defer f.Close()
+
+	rows, err := f.GetRows("Complaints")
+	if err != nil {
+		t.Fatalf("read Complaints sheet: %v", err)
+	}
+	if len(rows) != 1+len(complaints) {
+		t.Fatalf("sheet has %d rows, want a header and %d complaints", len(rows), len(complaints))
+	}
+
+	header := rows[0]
+	if len(header) != len(complaintCSVHeaders) {
+		t.Fatalf("header has %d columns, want %d", len(header), len(complaintCSVHeaders))
+	}
+	for i, name := range complaintCSVHeaders {
+		if want := fmt.Sprintf("%s (%s)", complaintXLSXLabels[name], name); header[i] != want {
+			t.Errorf("header column %d = %q, want %q", i+1, header[i], want)
+		}
+	}
+	for i, row := range rows[1:] {
+		if row[0] != complaints[i].TicketID {
+			t.Errorf("row %d ticket_id = %q, want %s", i+2, row[0], complaints[i].TicketID)
+		}
+	}
+}
+
+func TestExportFeatureCollectionSkipsBadGeometry(t *testing.T) {
+	store := useStore(t)
+
+	withGeometry := func(ticketID, geometryType string, coords ...float64) Feature {
+		f := testFeature(ticketID)
+		f.Geometry = Coordinates{Type: geometryType, Coordinates: coords}
+		return f
+	}
+	store.InsertFeatures(context.Background(), []Feature{
+		withGeometry("point", "Point", 100.5, 13.7),
+		withGeometry("no-coords", "Point"),
+		withGeometry("one-coord", "Point", 100.5),
+		withGeometry("odd-coords", "LineString", 100.5, 13.7, 100.6),
+		withGeometry("circle", "Circle", 100.5, 13.7),
+		withGeometry("no-type", "", 100.5, 13.7),
+		withGeometry("line", "LineString", 100.4, 13.6, 100.6, 13.9),
+	})
+
+	collection, err := exportFeatureCollection(context.Background(), 0, 100)
+	if err != nil {
+		t.Fatalf("exportFeatureCollection: %v", err)
+	}
+
+	var tickets []string
+	for _, f := range collection.Features {
+		tickets = append(tickets, f.Properties.TicketID)
+		if f.Type != "Feature" {
+			t.Errorf("%s: type = %q, want Feature", f.Properties.TicketID, f.Type)
+		}
+	}
+	if !slices.Equal(tickets, []string{"point", "line"}) {
+		t.Errorf("exported %v, want only point and line", tickets)
+	}
+	if want := []float64{100.4, 13.6, 100.6, 13.9}; !slices.Equal(collection.BBox, want) {
+		t.Errorf("bbox = %v, want %v from the exported features only", collection.BBox, want)
+	}
+}
+
+// flushRecorder is a ResponseRecorder that records how many bytes were
+// written between flushes, to show a response is streamed.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushes, pending, maxPending int
+}
+
+func (r *flushRecorder) Write(b []byte) (int, error) {
+	r.pending += len(b)
+	r.maxPending = max(r.maxPending, r.pending)
+	return r.ResponseRecorder.Write(b)
+}
+
+func (r *flushRecorder) Flush() {
+	r.flushes++
+	r.pending = 0
+	r.ResponseRecorder.Flush()
+}
+
+// seedComplaints stores n generated complaints.
+func seedComplaints(tb testing.TB, store *MemoryStore, n int) {
+	tb.Helper()
+
+	complaints := make([]Complaint, n)
+	for i := range complaints {
+		complaints[i] = Complaint{
+			TicketID:  fmt.Sprintf("C%06d", i),
+			Type:      "ถนน,ทางเท้า",
+			Comment:   "ฝาท่อชำรุด, อันตรายต่อผู้สัญจร",
+			Coords:    "100.5,13.7",
+			District:  "บางรัก",
+			Timestamp: "2024-01-02 10:00:00.000000+0700",
+			State:     "start",
+		}
+	}
+	if _, err := store.InsertComplaints(context.Background(), complaints); err != nil {
+		tb.Fatalf("InsertComplaints: %v", err)
+	}
+}
+
+func TestExportCSVTenThousandRows(t *testing.T) {
+	const rows = 10000
+	store := useStore(t)
+	seedComplaints(t, store, rows)
+	r := newTestRouter(t, Config{})
+
+	w := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/export/csv", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Content-Type"); got != "text/csv" {
+		t.Errorf("Content-Type = %q, want text/csv", got)
+	}
+	if got := w.Header().Get("Content-Disposition"); got != "attachment; filename=complaints.csv" {
+		t.Errorf("Content-Disposition = %q, want a complaints.csv attachment", got)
+	}
+
+	size := w.Body.Len()
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("parse CSV: %v", err)
+	}
+	if len(records) != rows+1 {
+		t.Fatalf("export has %d records, want a header and %d rows", len(records), rows)
+	}
+	if !slices.Equal(records[0], complaintCSVHeaders) {
+		t.Errorf("header = %q, want %q", records[0], complaintCSVHeaders)
+	}
+	if first, last := records[1][0], records[rows][0]; first != "C000000" || last != "C009999" {
+		t.Errorf("rows run from %s to %s, want C000000 to C009999", first, last)
+	}
+
+	// Rows go out every csvFlushRows, so no more than about that many are
+	// ever held back from the client.
+	if want := rows / csvFlushRows; w.flushes < want {
+		t.Errorf("response flushed %d times, want at least %d", w.flushes, want)
+	}
+	if limit := size / (rows / csvFlushRows) * 2; w.maxPending > limit {
+		t.Errorf("%d bytes written between flushes, want at most %d of the %d total", w.maxPending, limit, size)
+	}
+}
+
+// discardFlusher counts what is written to it and how often it is flushed.
+type discardFlusher struct {
+	written, flushes int
+}
+
+func (d *discardFlusher) Write(b []byte) (int, error) {
+	d.written += len(b)
+	return len(b), nil
+}
+
+func (d *discardFlusher) Flush() { d.flushes++ }
+
+// BenchmarkExportComplaintsCSV streams 100 000 stored complaints through
+// exportComplaintsCSV and reports the allocations per export.
+func BenchmarkExportComplaintsCSV(b *testing.B) {
+	const rows = 100000
+	prev := complaintStore
+	b.Cleanup(func() { complaintStore = prev })
+	store := NewMemoryStore()
+	complaintStore = store
+	seedComplaints(b, store, rows)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var w discardFlusher
+		if err := exportComplaintsCSV(context.Background(), &w, ComplaintFilter{}); err != nil {
+			b.Fatalf("exportComplaintsCSV: %v", err)
+		}
+		if w.flushes < rows/csvFlushRows {
+			b.Fatalf("flushed %d times for %d rows, want at least %d", w.flushes, rows, rows/csvFlushRows)
+		}
+		b.SetBytes(int64(w.written))
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// parseBBox parses "minLng,minLat,maxLng,maxLat" as used by map viewports.
+func parseBBox(s string) ([4]float64, error) {
+	var bbox [4]float64
+
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return bbox, errors.New("bbox must have four comma-separated values")
+	}
+
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return bbox, err
+		}
+		bbox[i] = v
+	}
+
+	minLng, minLat, maxLng, maxLat := bbox[0], bbox[1], bbox[2], bbox[3]
+	if minLng < -180 || maxLng > 180 || minLat < -90 || maxLat > 90 {
+		return bbox, errors.New("bbox is outside valid longitude/latitude ranges")
+	}
+	if minLng > maxLng || minLat > maxLat {
+		return bbox, errors.New("bbox minimums must not exceed maximums")
+	}
+
+	return bbox, nil
+}
+
+func bboxContains(bbox [4]float64, lng, lat float64) bool {
+	return lng >= bbox[0] && lng <= bbox[2] && lat >= bbox[1] && lat <= bbox[3]
+}
+
+// bboxFilter matches documents whose geometry lies inside bbox.
+func bboxFilter(bbox [4]float64) bson.M {
+	return bson.M{"$geoWithin": bson.M{"$box": bson.A{
+		bson.A{bbox[0], bbox[1]},
+		bson.A{bbox[2], bbox[3]},
+	}}}
+}
+
+// The upstream API has no spatial filter, so bbox queries against it are
+// applied to the returned page instead.
+
+func filterFeaturesByBBox(features []Feature, bbox [4]float64) []Feature {
+	filtered := []Feature{}
+	for _, f := range features {
+		coords := f.Geometry.Coordinates
+		if len(coords) >= 2 && bboxContains(bbox, coords[0], coords[1]) {
+			filtered = append(filtered, f)
+		}
+	}
+	return filtered
+}
+
+func filterComplaintsByBBox(complaints []Complaint, bbox [4]float64) []Complaint {
+	filtered := []Complaint{}
+	for _, c := range complaints {
+		lng, lat, ok := complaintLngLat(c.Coords)
+		if ok && bboxContains(bbox, lng, lat) {
+			filtered = append(filtered, c)
+		}
+	}
+	return filtered
+}
+
+// ParseCoords parses the coords string of a CSV complaint. Upstream
+// exports write it longitude first, e.g. "100.502,13.756", so the result is
+// in GeoJSON [lng, lat] order.
+func ParseCoords(s string) ([2]float64, error) {
+	var coords [2]float64
+
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return coords, errors.New("coords must be two comma-separated values")
+	}
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return coords, err
+		}
+		coords[i] = v
+	}
+
+	if coords[0] < -180 || coords[0] > 180 || coords[1] < -90 || coords[1] > 90 {
+		return coords, errors.New("coords are outside valid longitude/latitude ranges")
+	}
+
+	return coords, nil
+}
+
+// ToGeoJSONPoint converts the complaint's coords string into a Point
+// geometry so it can be stored and queried like a feature's.
+func (c Complaint) ToGeoJSONPoint() (Coordinates, error) {
+	coords, err := ParseCoords(c.Coords)
+	if err != nil {
+		return Coordinates{}, err
+	}
+	return Coordinates{Type: "Point", Coordinates: coords[:]}, nil
+}
+
+// complaintLngLat parses the "lng,lat" coords string of a CSV complaint.
+func complaintLngLat(coords string) (float64, float64, bool) {
+	parsed, err := ParseCoords(coords)
+	if err != nil {
+		return 0, 0, false
+	}
+	return parsed[0], parsed[1], true
+}
+
+// maxNearbyRadius caps /complaints/nearby searches at 50 km.
+const maxNearbyRadius = 50000
+
+const earthRadiusM = 6371008.8
+
+// haversineM returns the great-circle distance in metres between two points.
+func haversineM(lng1, lat1, lng2, lat2 float64) float64 {
+	toRad := func(d float64) float64 { return d * math.Pi / 180 }
+	dLat := toRad(lat2 - lat1)
+	dLng := toRad(lng2 - lng1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusM * math.Asin(math.Sqrt(a))
+}
+
+// nearbyComplaints returns point features within radiusM metres of lng,lat,
+// nearest first, each with its distance set in properties.distance_m.
+func nearbyComplaints(ctx context.Context, lng, lat, radiusM float64, limit int) ([]Feature, error) {
+	ctx, cancel := withMongoTimeout(ctx, mongoOpTimeout)
+	defer cancel()
+
+	// The 2dsphere index only covers points, so the query has to say so
+	// for the planner to use it.
+	query := bson.M{
+		"geometry.type": "Point",
+		"geometry": bson.M{"$nearSphere": bson.M{
+			"$geometry":    bson.M{"type": "Point", "coordinates": bson.A{lng, lat}},
+			"$maxDistance": radiusM,
+		}},
+	}
+
+	cursor, err := postsCollection.Find(ctx, query, options.Find().SetLimit(int64(limit)))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	// CSV complaints carry a point too, so each document is decoded
+	// according to its schema.
+	features := []Feature{}
+	for cursor.Next(ctx) {
+		feature, err := decodeFeature(cursor.Current)
+		if err != nil {
+			return nil, err
+		}
+		features = append(features, feature)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range features {
+		coords := features[i].Geometry.Coordinates
+		if len(coords) >= 2 {
+			d := haversineM(lng, lat, coords[0], coords[1])
+			features[i].Properties.DistanceM = &d
+		}
+	}
+
+	return features, nil
+}
+
+// Heatmap cells are between 0.001° (about 100 m) and 1° wide.
+const (
+	minHeatmapGridSize     = 0.001
+	maxHeatmapGridSize     = 1.0
+	defaultHeatmapGridSize = 0.01
+)
+
+// HeatmapCell is the number of complaints whose point snaps to lat,lng on
+// the heatmap grid.
+type HeatmapCell struct {
+	Lat   float64 `json:"lat" bson:"lat"`
+	Lng   float64 `json:"lng" bson:"lng"`
+	Count int     `json:"count" bson:"count"`
+}
+
+// heatmapCells counts point complaints per grid cell, snapping each point to
+// the nearest multiple of gridSize degrees. bbox may be nil.
+func heatmapCells(ctx context.Context, bbox *[4]float64, gridSize float64) ([]HeatmapCell, error) {
+	ctx, cancel := withMongoTimeout(ctx, mongoOpTimeout)
+	defer cancel()
+
+	match := bson.M{"geometry.type": "Point"}
+	if bbox != nil {
+		match["geometry.coordinates"] = bboxFilter(*bbox)
+	}
+
+	snap := func(index int) bson.M {
+		coord := bson.M{"$arrayElemAt": bson.A{"$geometry.coordinates", index}}
+		return bson.M{"$multiply": bson.A{
+			bson.M{"$round": bson.A{bson.M{"$divide": bson.A{coord, gridSize}}, 0}},
+			gridSize,
+		}}
+	}
+
+	pipeline := bson.A{
+		bson.M{"$match": match},
+		bson.M{"$group": bson.M{
+			"_id":   bson.M{"lng": snap(0), "lat": snap(1)},
+			"count": bson.M{"$sum": 1},
+		}},
+		bson.M{"$project": bson.M{"_id": 0, "lat": "$_id.lat", "lng": "$_id.lng", "count": 1}},
+		bson.M{"$sort": bson.M{"count": -1}},
+	}
+
+	cursor, err := postsCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	cells := []HeatmapCell{}
+	if err := cursor.All(ctx, &cells); err != nil {
+		return nil, err
+	}
+	return cells, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+const healthCheckTimeout = 3 * time.Second
+
+// pinger is the part of *mongo.Client checkHealth needs, so tests can
+// stand in for MongoDB.
+type pinger interface {
+	Ping(ctx context.Context, rp *readpref.ReadPref) error
+}
+
+// checkHealth pings MongoDB through db and the upstream API, reporting "ok"
+// or the error for each. healthy is false if either component failed.
+func checkHealth(ctx context.Context, db pinger) (gin.H, bool) {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	status := gin.H{"mongo": "ok", "upstream": "ok", "version": version}
+	healthy := true
+
+	pingStart := time.Now()
+	if err := db.Ping(ctx, nil); err != nil {
+		status["mongo"] = err.Error()
+		healthy = false
+	}
+	mongoPingLatency.Set(time.Since(pingStart).Seconds())
+
+	if err := pingUpstream(ctx); err != nil {
+		status["upstream"] = err.Error()
+		healthy = false
+	}
+
+	return status, healthy
+}
+
+func pingUpstream(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, traffyBaseURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("upstream returned %s", resp.Status)
+	}
+
+	return nil
+}
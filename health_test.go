@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+type fakePinger struct{ err error }
+
+func (p fakePinger) Ping(ctx context.Context, rp *readpref.ReadPref) error { return p.err }
+
+func TestCheckHealth(t *testing.T) {
+	healthyUpstream := func(w http.ResponseWriter, r *http.Request) {}
+	failingUpstream := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}
+
+	tests := []struct {
+		name         string
+		upstream     http.HandlerFunc
+		mongoErr     error
+		wantHealthy  bool
+		wantMongo    string
+		wantUpstream string
+	}{
+		{"healthy", healthyUpstream, nil, true, "ok", "ok"},
+		{"mongo down", healthyUpstream, errors.New("server selection timeout"), false, "server selection timeout", "ok"},
+		{"upstream down", failingUpstream, nil, false, "ok", "upstream returned 502 Bad Gateway"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var method string
+			useUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+				method = r.Method
+				tc.upstream(w, r)
+			})
+
+			status, healthy := checkHealth(context.Background(), fakePinger{tc.mongoErr})
+			if healthy != tc.wantHealthy {
+				t.Errorf("healthy = %v, want %v", healthy, tc.wantHealthy)
+			}
+			if status["mongo"] != tc.wantMongo || status["upstream"] != tc.wantUpstream {
+				t.Errorf("status = %v, want mongo %q and upstream %q", status, tc.wantMongo, tc.wantUpstream)
+			}
+			if method != http.MethodHead {
+				t.Errorf("upstream probed with %q, want HEAD", method)
+			}
+		})
+	}
+}
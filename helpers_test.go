@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+// newTestRouter registers the API under /api/v1 the way main does, with cfg
+// in place of the environment and middleware in front of every route. The
+// upstream limiters are shared across tests, so they are left wide open.
+func newTestRouter(t *testing.T, cfg Config, middleware ...gin.HandlerFunc) *gin.Engine {
+	t.Helper()
+
+	batch, err := loadBatchConfig()
+	if err != nil {
+		t.Fatalf("loadBatchConfig: %v", err)
+	}
+	if cfg.UpstreamRPS == 0 {
+		cfg.UpstreamRPS, cfg.UpstreamBurst = 1000, 1000
+	}
+
+	prevConfig, prevBatch := appConfig, batchConfig
+	t.Cleanup(func() { appConfig, batchConfig = prevConfig, prevBatch })
+	appConfig, batchConfig = cfg, batch
+
+	r := gin.New()
+	r.Use(middleware...)
+	RegisterV1Routes(r.Group("/api/v1"))
+	return r
+}
+
+// useCollection points the handlers at coll for the rest of the test.
+// Audit entries and archived versions are skipped so they don't show up as
+// extra commands.
+func useCollection(t *testing.T, coll *mongo.Collection) {
+	t.Helper()
+
+	prevPosts, prevAudit, prevHistory := postsCollection, auditCollection, historyCollection
+	t.Cleanup(func() { postsCollection, auditCollection, historyCollection = prevPosts, prevAudit, prevHistory })
+	postsCollection, auditCollection, historyCollection = coll, nil, nil
+}
+
+// serve sends one request through r and returns the recorded response.
+func serve(r http.Handler, method, target, body string, headers ...string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, target, reader)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// useUpstream points the upstream client at a test server running handler
+// through applyUpstreamConfig, with one attempt per request and empty
+// response caches. It returns the config it applied.
+func useUpstream(t *testing.T, handler http.HandlerFunc) Config {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	prevURL, prevClient, prevWorkers := traffyBaseURL, httpClient, ingestWorkers
+	prevAttempts, prevDelay := retryAttempts, retryBaseDelay
+	prevJSON, prevCSV := upstreamJSONCache, upstreamCSVCache
+	t.Cleanup(func() {
+		traffyBaseURL, httpClient, ingestWorkers = prevURL, prevClient, prevWorkers
+		retryAttempts, retryBaseDelay = prevAttempts, prevDelay
+		upstreamJSONCache, upstreamCSVCache = prevJSON, prevCSV
+	})
+
+	cfg := Config{
+		TraffyBaseURL:     server.URL + "/teamchadchart/api/fondue/export",
+		HTTPTimeout:       5 * time.Second,
+		RetryAttempts:     1,
+		RetryBaseDelay:    time.Millisecond,
+		IngestWorkers:     4,
+		UpstreamCacheSize: defaultUpstreamCacheSize,
+		UpstreamCacheTTL:  defaultUpstreamCacheTTL,
+	}
+	applyUpstreamConfig(cfg)
+	return cfg
+}
+
+// pagedUpstream serves total generated features as JSON and total rows as
+// CSV, honouring the offset and limit query parameters like the real API.
+func pagedUpstream(total int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		offset, _ := strconv.Atoi(query.Get("offset"))
+		limit, _ := strconv.Atoi(query.Get("limit"))
+		end := min(offset+limit, total)
+
+		if query.Get("output_format") == "csv" {
+			fmt.Fprintln(w, "ticket_id,state,coords")
+			for i := offset; i < end; i++ {
+				fmt.Fprintf(w, "T%d,เสร็จสิ้น,\"100.5,13.7\"\n", i)
+			}
+			return
+		}
+
+		data := Data{Status: "success", Total: total, Features: []Feature{}}
+		for i := offset; i < end; i++ {
+			data.Features = append(data.Features, testFeature(fmt.Sprintf("T%d", i)))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(data)
+	}
+}
+
+// useStore swaps complaintStore for an empty MemoryStore for the rest of
+// the test.
+func useStore(t *testing.T) *MemoryStore {
+	t.Helper()
+
+	store := NewMemoryStore()
+	prev := complaintStore
+	t.Cleanup(func() { complaintStore = prev })
+	complaintStore = store
+	return store
+}
+
+const testJWTSecret = "test-secret"
+
+// bearer returns an Authorization header value for a fresh token signed
+// with testJWTSecret.
+func bearer(t *testing.T) string {
+	t.Helper()
+
+	token, _, err := issueToken(testJWTSecret, "tester", time.Hour)
+	if err != nil {
+		t.Fatalf("issueToken: %v", err)
+	}
+	return "Bearer " + token
+}
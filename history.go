@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// historyCollection keeps the versions of stored features that
+// re-ingestion replaced. It is nil until initMongoDB runs, in which case
+// none are kept.
+var historyCollection *mongo.Collection
+
+// featureVersion is a stored feature document as it was before
+// re-ingestion changed it. ArchivedAt is when the next version replaced it.
+type featureVersion struct {
+	TicketID   string    `bson:"ticket_id"`
+	ArchivedAt time.Time `bson:"archived_at"`
+	Document   bson.Raw  `bson:"document"`
+}
+
+// ensureHistoryIndex indexes archived versions by ticket for
+// GET /features/:ticketID/history.
+func ensureHistoryIndex(ctx context.Context) error {
+	_, err := historyCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "ticket_id", Value: 1}, {Key: "archived_at", Value: 1}},
+	})
+	return err
+}
+
+// changedVersions returns the documents in existing that features are
+// about to change, as versions archived at now. New features and
+// features whose exported fields are unchanged have none.
+func changedVersions(existing []bson.Raw, features []Feature, now time.Time) ([]featureVersion, error) {
+	byTicket := map[string]bson.Raw{}
+	for _, doc := range existing {
+		byTicket[docTicketID(doc)] = doc
+	}
+
+	var versions []featureVersion
+	for _, feature := range features {
+		doc, ok := byTicket[feature.Properties.TicketID]
+		if !ok {
+			continue
+		}
+		var stored Feature
+		if err := bson.Unmarshal(doc, &stored); err != nil {
+			return nil, err
+		}
+		if len(diffComplaints(featureToComplaint(stored), featureToComplaint(feature))) > 0 {
+			versions = append(versions, featureVersion{TicketID: feature.Properties.TicketID, ArchivedAt: now, Document: doc})
+			// A ticket repeated in one batch is archived once.
+			delete(byTicket, feature.Properties.TicketID)
+		}
+	}
+	return versions, nil
+}
+
+// archiveFeatures copies the stored features that features would change
+// into historyCollection.
+func archiveFeatures(ctx context.Context, features []Feature, now time.Time) error {
+	if historyCollection == nil {
+		return nil
+	}
+
+	ticketIDs := make([]string, 0, len(features))
+	for _, feature := range features {
+		ticketIDs = append(ticketIDs, feature.Properties.TicketID)
+	}
+	cursor, err := postsCollection.Find(ctx, bson.M{"properties.ticket_id": bson.M{"$in": ticketIDs}})
+	if err != nil {
+		return err
+	}
+	var existing []bson.Raw
+	if err := cursor.All(ctx, &existing); err != nil {
+		return err
+	}
+
+	versions, err := changedVersions(existing, features, now)
+	if err != nil || len(versions) == 0 {
+		return err
+	}
+	docs := make([]any, 0, len(versions))
+	for _, v := range versions {
+		docs = append(docs, v)
+	}
+	_, err = historyCollection.InsertMany(ctx, docs)
+	return err
+}
+
+// findFeatureVersions returns the archived versions of a ticket, oldest
+// first.
+func findFeatureVersions(ctx context.Context, ticketID string) ([]featureVersion, error) {
+	versions := []featureVersion{}
+	if historyCollection == nil {
+		return versions, nil
+	}
+
+	findOptions := options.Find().SetSort(bson.D{{Key: "archived_at", Value: 1}})
+	cursor, err := historyCollection.Find(ctx, bson.M{"ticket_id": ticketID}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	err = cursor.All(ctx, &versions)
+	return versions, err
+}
+
+// storedVersion is one version of a ticket and when it was written.
+type storedVersion struct {
+	At  *time.Time
+	Doc bson.Raw
+}
+
+// ticketVersions lists every version of a ticket: the archived versions of
+// its feature, then the documents stored now, which are the current
+// feature and any CSV complaints. A feature keeps the created_at of its
+// first version, so each later version dates from when the one before it
+// was archived.
+func ticketVersions(archived []featureVersion, current []bson.Raw) []storedVersion {
+	versions := make([]storedVersion, 0, len(archived)+len(current))
+	for i, v := range archived {
+		at := createdAt(v.Document)
+		if i > 0 {
+			at = &archived[i-1].ArchivedAt
+		}
+		versions = append(versions, storedVersion{At: at, Doc: v.Document})
+	}
+	for _, doc := range current {
+		at := createdAt(doc)
+		if isFeatureDoc(doc) && len(archived) > 0 {
+			at = &archived[len(archived)-1].ArchivedAt
+		}
+		versions = append(versions, storedVersion{At: at, Doc: doc})
+	}
+	return versions
+}
+
+// buildHistory orders versions by when they were written, those without
+// a time first, and diffs each against the one before.
+func buildHistory(versions []storedVersion) ([]HistoryEntry, error) {
+	slices.SortStableFunc(versions, func(a, b storedVersion) int {
+		switch {
+		case a.At == nil && b.At == nil:
+			return 0
+		case a.At == nil:
+			return -1
+		case b.At == nil:
+			return 1
+		}
+		return a.At.Compare(*b.At)
+	})
+
+	history := []HistoryEntry{}
+	for _, v := range versions {
+		var err error
+		if history, err = appendVersion(history, v.Doc, v.At); err != nil {
+			return nil, err
+		}
+	}
+	return history, nil
+}
+
+// createdAt reads created_at from a stored document of either schema.
+// Features keep it beside properties, outside the converted record.
+func createdAt(doc bson.Raw) *time.Time {
+	ms, ok := doc.Lookup("created_at").DateTimeOK()
+	if !ok {
+		return nil
+	}
+	t := time.UnixMilli(ms)
+	return &t
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestSaveFeaturesToMongoDBArchivesVersions(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("changed state", func(mt *mtest.T) {
+		useCollection(mt.T, mt.Coll)
+		historyCollection = mt.DB.Collection("history")
+		ns := mt.DB.Name() + "." + mt.Coll.Name()
+		historyNS := mt.DB.Name() + ".history"
+
+		created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		id := primitive.NewObjectID()
+		stored := func(state string) bson.D {
+			return bson.D{
+				{Key: "_id", Value: id},
+				{Key: "type", Value: "Feature"},
+				{Key: "properties", Value: bson.D{{Key: "ticket_id", Value: "T1"}, {Key: "state", Value: state}}},
+				{Key: "created_at", Value: created},
+			}
+		}
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "upserted", Value: bson.A{
+				bson.D{{Key: "index", Value: 0}, {Key: "_id", Value: id}},
+			}}),
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, stored("start")),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+		)
+
+		feature := testFeature("T1")
+		feature.Properties.State = "start"
+		if _, err := saveFeaturesToMongoDB(context.Background(), Data{Features: []Feature{feature}}); err != nil {
+			mt.Fatalf("first save: %v", err)
+		}
+		feature.Properties.State = "finish"
+		if _, err := saveFeaturesToMongoDB(context.Background(), Data{Features: []Feature{feature}}); err != nil {
+			mt.Fatalf("second save: %v", err)
+		}
+
+		var names []string
+		var archived bson.Raw
+		for _, started := range mt.GetAllStartedEvents() {
+			names = append(names, started.CommandName)
+			if started.CommandName == "insert" {
+				archived = started.Command.Lookup("documents", "0").Document()
+			}
+		}
+		if want := "find update find insert update"; strings.Join(names, " ") != want {
+			mt.Fatalf("commands %q, want %q: the version must be archived before the second update", strings.Join(names, " "), want)
+		}
+		if state := archived.Lookup("document", "properties", "state").StringValue(); state != "start" {
+			mt.Errorf("archived state %q, want the replaced start", state)
+		}
+		archivedAt := archived.Lookup("archived_at").Time()
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, stored("finish")),
+			mtest.CreateCursorResponse(0, historyNS, mtest.FirstBatch, bson.D{
+				{Key: "ticket_id", Value: "T1"},
+				{Key: "archived_at", Value: archivedAt},
+				{Key: "document", Value: stored("start")},
+			}),
+		)
+		history, err := featureHistory(context.Background(), "T1")
+		if err != nil {
+			mt.Fatalf("featureHistory: %v", err)
+		}
+		if len(history) != 2 {
+			mt.Fatalf("history has %d versions, want 2", len(history))
+		}
+		if change := history[1].Diff["state"]; change != (FieldChange{From: "start", To: "finish"}) {
+			mt.Errorf("second version diff %+v, want state start to finish", history[1].Diff)
+		}
+		if !history[0].CreatedAt.Equal(created) || !history[1].CreatedAt.Equal(archivedAt) {
+			mt.Errorf("versions dated %v and %v, want created_at then archived_at", history[0].CreatedAt, history[1].CreatedAt)
+		}
+	})
+}
+
+func TestMemoryStoreArchivesVersions(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	feature := testFeature("T1")
+	for _, state := range []string{"start", "finish", "finish"} {
+		feature.Properties.State = state
+		if _, err := store.InsertFeatures(ctx, []Feature{feature}); err != nil {
+			t.Fatalf("insert with state %s: %v", state, err)
+		}
+	}
+
+	history, err := store.History(ctx, "T1")
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("history has %d versions, want 2; the unchanged third save is not a version", len(history))
+	}
+	if change := history[1].Diff["state"]; change != (FieldChange{From: "start", To: "finish"}) {
+		t.Errorf("second version diff %+v, want state start to finish", history[1].Diff)
+	}
+	if history[0].CreatedAt == nil || history[1].CreatedAt.Before(*history[0].CreatedAt) {
+		t.Errorf("versions dated %v and %v, want oldest first", history[0].CreatedAt, history[1].CreatedAt)
+	}
+}
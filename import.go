@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+type ImportResult struct {
+	Inserted int      `json:"inserted"`
+	Updated  int      `json:"updated,omitempty"`
+	Skipped  int      `json:"skipped"`
+	Errors   []string `json:"errors"`
+}
+
+// maxImportFeatures caps the size of one /complaints/import/json request.
+const maxImportFeatures = 10000
+
+// validateImportFeatures keeps the features of an uploaded FeatureCollection
+// that have Point geometry and the required properties. The rest are
+// skipped and reported in the result.
+func validateImportFeatures(features []Feature) ([]Feature, ImportResult) {
+	result := ImportResult{Errors: []string{}}
+
+	valid := make([]Feature, 0, len(features))
+	for i, f := range features {
+		if err := validateImportFeature(f); err != nil {
+			result.Skipped++
+			result.Errors = append(result.Errors, fmt.Sprintf("feature %d: %v", i, err))
+			continue
+		}
+		valid = append(valid, f)
+	}
+	return valid, result
+}
+
+func validateImportFeature(f Feature) error {
+	if f.Geometry.Type != "Point" {
+		return fmt.Errorf("unsupported geometry type %q, only Point is accepted", f.Geometry.Type)
+	}
+	coords := f.Geometry.Coordinates
+	if len(coords) != 2 || coords[0] < -180 || coords[0] > 180 || coords[1] < -90 || coords[1] > 90 {
+		return errors.New("geometry must be a [longitude, latitude] pair")
+	}
+	if strings.TrimSpace(f.Properties.TicketID) == "" {
+		return errors.New("missing ticket_id")
+	}
+	if strings.TrimSpace(f.Properties.Timestamp) == "" {
+		return errors.New("missing timestamp")
+	}
+	return nil
+}
+
+// decodeCharset wraps r so it yields UTF-8. Thai government exports are
+// often TIS-620, which Windows-874 is a superset of.
+func decodeCharset(r io.Reader, charset string) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "", "utf-8", "utf8":
+		return r, nil
+	case "tis-620", "tis620", "windows-874":
+		return charmap.Windows874.NewDecoder().Reader(r), nil
+	}
+	return nil, fmt.Errorf("unsupported charset %q", charset)
+}
+
+// parseComplaintsCSV maps rows onto Complaint by header name, as
+// convertCSVToComplaints does. Rows with the wrong number of columns or without a
+// ticket_id are skipped and reported in the result instead of failing the
+// whole file.
+func parseComplaintsCSV(r io.Reader) ([]Complaint, ImportResult, error) {
+	result := ImportResult{Errors: []string{}}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	headers, err := reader.Read()
+	if err == io.EOF {
+		return nil, result, errors.New("file is empty")
+	}
+	if err != nil {
+		return nil, result, err
+	}
+	if len(headers) > 0 {
+		headers[0] = strings.TrimPrefix(headers[0], "\ufeff")
+	}
+
+	var complaints []Complaint
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, result, err
+		}
+
+		if len(record) != len(headers) {
+			result.Skipped++
+			result.Errors = append(result.Errors, fmt.Sprintf("line %d: expected %d columns, got %d", line, len(headers), len(record)))
+			continue
+		}
+
+		row := make(map[string]string, len(headers))
+		for i, header := range headers {
+			row[header] = record[i]
+		}
+
+		complaint := complaintFromRow(row)
+		if complaint.TicketID == "" {
+			result.Skipped++
+			result.Errors = append(result.Errors, fmt.Sprintf("line %d: missing ticket_id", line))
+			continue
+		}
+
+		complaints = append(complaints, complaint)
+	}
+
+	return complaints, result, nil
+}
+
+// complaintFields maps CSV column names onto the Complaint field they fill.
+var complaintFields = map[string]func(*Complaint) *string{
+	"address":             func(c *Complaint) *string { return &c.Address },
+	"comment":             func(c *Complaint) *string { return &c.Comment },
+	"coords":              func(c *Complaint) *string { return &c.Coords },
+	"count_reopen":        func(c *Complaint) *string { return &c.CountReopen },
+	"district":            func(c *Complaint) *string { return &c.District },
+	"last_activity":       func(c *Complaint) *string { return &c.LastActivity },
+	"organization":        func(c *Complaint) *string { return &c.Organization },
+	"organization_action": func(c *Complaint) *string { return &c.OrganizationAction },
+	"photo":               func(c *Complaint) *string { return &c.Photo },
+	"photo_after":         func(c *Complaint) *string { return &c.PhotoAfter },
+	"province":            func(c *Complaint) *string { return &c.Province },
+	"star":                func(c *Complaint) *string { return &c.Star },
+	"state":               func(c *Complaint) *string { return &c.State },
+	"subdistrict":         func(c *Complaint) *string { return &c.Subdistrict },
+	"timestamp":           func(c *Complaint) *string { return &c.Timestamp },
+	"type":                func(c *Complaint) *string { return &c.Type },
+	"ticket_id":           func(c *Complaint) *string { return &c.TicketID },
+}
+
+func complaintFromRow(row map[string]string) Complaint {
+	var complaint Complaint
+	for name, field := range complaintFields {
+		*field(&complaint) = row[name]
+	}
+	return complaint
+}
+
+// convertCSVToComplaints decodes an upstream CSV export straight into
+// Complaints, resolving each header to its field once rather than building
+// a map or JSON document per row. Rows whose column count differs from the
+// header are logged and skipped; the number skipped is returned.
+func convertCSVToComplaints(r io.Reader) ([]Complaint, int, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	complaints := []Complaint{}
+
+	headers, err := reader.Read()
+	if err == io.EOF {
+		return complaints, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	columns := make([]func(*Complaint) *string, len(headers))
+	for i, header := range headers {
+		columns[i] = complaintFields[strings.TrimPrefix(header, "\ufeff")]
+	}
+
+	skipped := 0
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, skipped, err
+		}
+
+		if len(record) != len(columns) {
+			slog.Warn("Skipping CSV row with wrong column count", "row", line, "columns", len(record), "expected", len(columns))
+			skipped++
+			continue
+		}
+
+		var complaint Complaint
+		for i, field := range columns {
+			if field != nil {
+				*field(&complaint) = record[i]
+			}
+		}
+		complaints = append(complaints, complaint)
+	}
+
+	return complaints, skipped, nil
+}
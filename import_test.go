@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestConvertCSVToComplaintsSkipsMalformedRows(t *testing.T) {
+	csvData := "\ufeffticket_id,state,coords\n" +
+		"T1,เสร็จสิ้น,\"100.5,13.7\"\n" +
+		"T2,เสร็จสิ้น\n" +
+		"T3,เสร็จสิ้น,\"100.5,13.7\",extra\n" +
+		"T4,รอรับเรื่อง,\"100.6,13.8\"\n"
+
+	complaints, skipped, err := convertCSVToComplaints(strings.NewReader(csvData))
+	if err != nil {
+		t.Fatalf("convertCSVToComplaints: %v", err)
+	}
+	if skipped != 2 {
+		t.Errorf("skipped = %d, want 2", skipped)
+	}
+	if len(complaints) != 2 || complaints[0].TicketID != "T1" || complaints[1].TicketID != "T4" {
+		t.Fatalf("complaints = %+v, want T1 and T4", complaints)
+	}
+	if complaints[1].State != "รอรับเรื่อง" || complaints[1].Coords != "100.6,13.8" {
+		t.Errorf("T4 = %+v, want its state and coords", complaints[1])
+	}
+}
+
+func TestConvertCSVToComplaintsEmptyAndInvalid(t *testing.T) {
+	complaints, skipped, err := convertCSVToComplaints(strings.NewReader(""))
+	if err != nil || skipped != 0 || len(complaints) != 0 {
+		t.Errorf("empty input = %v, %d, %v, want no complaints and no error", complaints, skipped, err)
+	}
+
+	if _, _, err := convertCSVToComplaints(strings.NewReader("ticket_id,state\nT1,\"unterminated\n")); err == nil {
+		t.Error("unterminated quote parsed without an error")
+	}
+}
+
+// benchmarkCSV is an upstream-style export of n rows with every column.
+func benchmarkCSV(n int) string {
+	var b strings.Builder
+	b.WriteString(strings.Join(complaintCSVHeaders, ",") + "\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "T%d,\"{ถนน,ทางเท้า}\",สำนักงานเขตบางรัก,สำนักงานเขตบางรัก,ฝาท่อชำรุด,\"100.5,13.7\",https://example.com/%d.jpg,,ถนนสีลม,สุริยวงศ์,บางรัก,กรุงเทพมหานคร,2024-01-02 10:00:00.000000+0700,เสร็จสิ้น,5,0,2024-01-05 10:00:00.000000+0700\n", i, i)
+	}
+	return b.String()
+}
+
+// convertCSVViaJSON is the conversion convertCSVToComplaints replaced:
+// CSV to a JSON array of header-keyed objects, then JSON to Complaints.
+func convertCSVViaJSON(csvData string) ([]Complaint, error) {
+	reader := csv.NewReader(strings.NewReader(csvData))
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+
+	var rows []map[string]string
+	for _, record := range records[1:] {
+		if len(record) != len(records[0]) {
+			continue
+		}
+		row := make(map[string]string, len(record))
+		for i, header := range records[0] {
+			row[header] = record[i]
+		}
+		rows = append(rows, row)
+	}
+	jsonData, err := json.Marshal(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	var complaints []Complaint
+	err = json.Unmarshal(jsonData, &complaints)
+	return complaints, err
+}
+
+const benchmarkCSVRows = 25000
+
+func BenchmarkConvertCSVToComplaints(b *testing.B) {
+	csvData := benchmarkCSV(benchmarkCSVRows)
+	b.SetBytes(int64(len(csvData)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		complaints, _, err := convertCSVToComplaints(strings.NewReader(csvData))
+		if err != nil || len(complaints) != benchmarkCSVRows {
+			b.Fatalf("converted %d complaints, %v", len(complaints), err)
+		}
+	}
+}
+
+func BenchmarkConvertCSVViaJSON(b *testing.B) {
+	csvData := benchmarkCSV(benchmarkCSVRows)
+	b.SetBytes(int64(len(csvData)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		complaints, err := convertCSVViaJSON(csvData)
+		if err != nil || len(complaints) != benchmarkCSVRows {
+			b.Fatalf("converted %d complaints, %v", len(complaints), err)
+		}
+	}
+}
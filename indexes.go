@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Server error codes returned when an index with the same name or keys
+// already exists with different options.
+const (
+	indexOptionsConflict  = 85
+	indexKeySpecsConflict = 86
+)
+
+func indexModels() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			// CSV complaints have no properties.ticket_id, so the unique
+			// index only covers feature documents.
+			Keys: bson.D{{Key: "properties.ticket_id", Value: 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"properties.ticket_id": bson.M{"$exists": true}}),
+		},
+		{
+			Keys:    bson.D{{Key: "ticket_id", Value: 1}},
+			Options: options.Index().SetBackground(true),
+		},
+		{
+			Keys:    bson.D{{Key: "properties.timestamp", Value: 1}, {Key: "properties.district", Value: 1}},
+			Options: options.Index().SetBackground(true),
+		},
+		{
+			Keys:    bson.D{{Key: "properties.state", Value: 1}},
+			Options: options.Index().SetBackground(true),
+		},
+		{
+			// Features without a usable point would make inserts fail on a
+			// plain 2dsphere index, so only points are indexed.
+			Keys: bson.D{{Key: "geometry", Value: "2dsphere"}},
+			Options: options.Index().
+				SetBackground(true).
+				SetPartialFilterExpression(bson.M{"geometry.type": "Point"}),
+		},
+		{
+			// A collection may only have one text index, so both searchable
+			// fields share it.
+			Keys: bson.D{
+				{Key: "properties.description", Value: "text"},
+				{Key: "properties.address", Value: "text"},
+			},
+			Options: options.Index().SetBackground(true),
+		},
+	}
+}
+
+// ensureIndexes creates the collection's indexes one by one. An index that
+// already exists with different options is logged and left alone.
+func ensureIndexes(ctx context.Context) error {
+	for _, model := range indexModels() {
+		_, err := postsCollection.Indexes().CreateOne(ctx, model)
+
+		var cmdErr mongo.CommandError
+		if errors.As(err, &cmdErr) && (cmdErr.Code == indexOptionsConflict || cmdErr.Code == indexKeySpecsConflict) {
+			slog.Warn("Index already exists with different options", "keys", model.Keys, "error", err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
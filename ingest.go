@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+var ingestWorkers = 4
+
+// ingestBatches runs fn for each of iterations batches, at most workers at a
+// time. The i-th batch starts at offset + i*limit. Every batch runs even if
+// others fail; the returned slice holds one message per failed batch.
+func ingestBatches(workers, iterations, offset, limit int, fn func(i, batchOffset int) error) []string {
+	if workers < 1 {
+		workers = 1
+	}
+
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	errs := []string{}
+
+	for i := 0; i < iterations; i++ {
+		batchOffset := offset + i*limit
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i, batchOffset int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := fn(i, batchOffset); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Sprintf("batch %d (offset %d): %v", i, batchOffset, err))
+				mu.Unlock()
+			}
+		}(i, batchOffset)
+	}
+
+	wg.Wait()
+
+	return errs
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+func testFeature(ticketID string) Feature {
+	return Feature{
+		Type: "Feature",
+		Properties: Properties{
+			TicketID:          ticketID,
+			ProblemTypeFondue: []string{"ถนน"},
+			State:             "รอรับเรื่อง",
+		},
+	}
+}
+
+func TestSaveFeaturesToMongoDB(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("success", func(mt *mtest.T) {
+		useCollection(mt.T, mt.Coll)
+		mt.AddMockResponses(mtest.CreateSuccessResponse(
+			bson.E{Key: "n", Value: 2},
+			bson.E{Key: "nModified", Value: 0},
+			bson.E{Key: "upserted", Value: bson.A{
+				bson.D{{Key: "index", Value: 0}, {Key: "_id", Value: primitive.NewObjectID()}},
+				bson.D{{Key: "index", Value: 1}, {Key: "_id", Value: primitive.NewObjectID()}},
+			}},
+		))
+
+		result, err := saveFeaturesToMongoDB(context.Background(), Data{Features: []Feature{testFeature("T1"), testFeature("T2")}})
+		if err != nil {
+			mt.Fatalf("saveFeaturesToMongoDB: %v", err)
+		}
+		if result.Inserted != 2 || result.Failed != 0 {
+			mt.Errorf("result = %+v, want 2 inserted and none failed", result)
+		}
+
+		started := mt.GetStartedEvent()
+		if started == nil || started.CommandName != "update" {
+			mt.Fatalf("started command = %v, want update", started)
+		}
+		updates, _ := started.Command.Lookup("updates").Array().Values()
+		if len(updates) != 2 {
+			mt.Fatalf("sent %d updates, want 2", len(updates))
+		}
+		for i, want := range []string{"T1", "T2"} {
+			update := updates[i].Document()
+			if got := update.Lookup("q", "properties.ticket_id").StringValue(); got != want {
+				mt.Errorf("update %d filters on %q, want %q", i, got, want)
+			}
+			if !update.Lookup("upsert").Boolean() {
+				mt.Errorf("update %d is not an upsert", i)
+			}
+			if got := update.Lookup("u", "$set", "schema_version").Int32(); got != int32(currentSchemaVersion) {
+				mt.Errorf("update %d stamps schema_version %d, want %d", i, got, currentSchemaVersion)
+			}
+		}
+		if ordered, ok := started.Command.Lookup("ordered").BooleanOK(); !ok || ordered {
+			mt.Errorf("bulk write ordered = %v, want false", ordered)
+		}
+	})
+
+	mt.Run("bulk write exception", func(mt *mtest.T) {
+		useCollection(mt.T, mt.Coll)
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{
+			Index:   1,
+			Code:    11000,
+			Message: "E11000 duplicate key error",
+		}))
+
+		result, err := saveFeaturesToMongoDB(context.Background(), Data{Features: []Feature{testFeature("T1"), testFeature("T2")}})
+		if err != nil {
+			mt.Fatalf("per-document failures should not fail the batch: %v", err)
+		}
+		if result.Failed != 1 || len(result.Errors) != 1 {
+			mt.Fatalf("result = %+v, want one failed document", result)
+		}
+		var writeErr mongo.BulkWriteError
+		if !errors.As(result.Errors[0], &writeErr) || writeErr.Code != 11000 || writeErr.Index != 1 {
+			mt.Errorf("error = %v, want duplicate key at index 1", result.Errors[0])
+		}
+
+		if started := mt.GetStartedEvent(); started == nil || started.CommandName != "update" {
+			mt.Errorf("started command = %v, want update", started)
+		}
+		if failed := mt.GetFailedEvent(); failed != nil {
+			mt.Errorf("command failed outright: %v", failed.Failure)
+		}
+	})
+}
+
+func TestSaveFeaturesToMongoDBUpsertTwice(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("same feature twice", func(mt *mtest.T) {
+		useCollection(mt.T, mt.Coll)
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(
+				bson.E{Key: "n", Value: 1},
+				bson.E{Key: "nModified", Value: 0},
+				bson.E{Key: "upserted", Value: bson.A{
+					bson.D{{Key: "index", Value: 0}, {Key: "_id", Value: primitive.NewObjectID()}},
+				}},
+			),
+			mtest.CreateSuccessResponse(
+				bson.E{Key: "n", Value: 1},
+				bson.E{Key: "nModified", Value: 1},
+			),
+		)
+
+		// A feature read back from the API or an import file carries its
+		// own created_at; it must not end up in $set either.
+		created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		feature := testFeature("T1")
+		feature.CreatedAt = &created
+
+		first, err := saveFeaturesToMongoDB(context.Background(), Data{Features: []Feature{feature}})
+		if err != nil || first.Err() != nil {
+			mt.Fatalf("first save: %v, %v", err, first.Err())
+		}
+		second, err := saveFeaturesToMongoDB(context.Background(), Data{Features: []Feature{feature}})
+		if err != nil || second.Err() != nil {
+			mt.Fatalf("second save: %v, %v", err, second.Err())
+		}
+		if first.Inserted != 1 || first.Updated != 0 {
+			mt.Errorf("first save = %+v, want one insert", first)
+		}
+		if second.Inserted != 0 || second.Updated != 1 {
+			mt.Errorf("second save = %+v, want one update", second)
+		}
+
+		events := mt.GetAllStartedEvents()
+		if len(events) != 2 {
+			mt.Fatalf("sent %d commands, want 2", len(events))
+		}
+		for i, started := range events {
+			// Both saves must target the same document by ticket ID and
+			// upsert it, or the second one would add a duplicate.
+			if got := started.Command.Lookup("updates", "0", "q", "properties.ticket_id").StringValue(); got != "T1" {
+				mt.Errorf("save %d filters on ticket_id %q, want T1", i+1, got)
+			}
+			if upsert, ok := started.Command.Lookup("updates", "0", "upsert").BooleanOK(); !ok || !upsert {
+				mt.Errorf("save %d is not an upsert", i+1)
+			}
+			update := started.Command.Lookup("updates", "0", "u").Document()
+			if _, err := update.LookupErr("$set", "created_at"); err == nil {
+				mt.Errorf("save %d sets created_at in $set as well as $setOnInsert", i+1)
+			}
+			if _, err := update.LookupErr("$setOnInsert", "created_at"); err != nil {
+				mt.Errorf("save %d does not set created_at on insert", i+1)
+			}
+			if got := update.Lookup("$set", "properties", "ticket_id").StringValue(); got != "T1" {
+				mt.Errorf("save %d sets ticket_id %q, want T1", i+1, got)
+			}
+		}
+		if feature.CreatedAt != &created {
+			mt.Error("saveFeaturesToMongoDB modified the caller's feature")
+		}
+	})
+}
+
+func TestBulkResultErr(t *testing.T) {
+	if err := (BulkResult{Inserted: 3}).Err(); err != nil {
+		t.Errorf("Err() = %v for a clean batch, want nil", err)
+	}
+
+	writeErr := mongo.BulkWriteError{WriteError: mongo.WriteError{Index: 0, Code: 11000, Message: "duplicate key"}}
+	err := BulkResult{Inserted: 2, Failed: 1, Errors: []error{writeErr}}.Err()
+	if err == nil {
+		t.Fatal("Err() = nil with a failed document")
+	}
+	var got mongo.BulkWriteError
+	if !errors.As(err, &got) || got.Code != 11000 {
+		t.Errorf("Err() = %v, want it to wrap the write error", err)
+	}
+}
+
+func TestSaveFeaturesToMongoDBCSVEmpty(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("empty slice", func(mt *mtest.T) {
+		useCollection(mt.T, mt.Coll)
+
+		result, err := saveFeaturesToMongoDBCSV(context.Background(), []Complaint{})
+		if err != nil {
+			mt.Fatalf("saveFeaturesToMongoDBCSV: %v", err)
+		}
+		if result.Inserted != 0 || result.Failed != 0 {
+			mt.Errorf("result = %+v, want zero value", result)
+		}
+		if events := mt.GetAllStartedEvents(); len(events) != 0 {
+			mt.Errorf("sent %d commands for an empty slice, want none", len(events))
+		}
+	})
+}
+
+func TestInitMongoDBPingFailure(t *testing.T) {
+	prevClient := client
+	useCollection(t, nil)
+	t.Cleanup(func() { client = prevClient })
+
+	cfg := Config{
+		MongoURI:       "mongodb://127.0.0.1:1/?serverSelectionTimeoutMS=100&connectTimeoutMS=100",
+		DatabaseName:   "traffy",
+		CollectionName: "posts",
+		AuditName:      "audit",
+		MongoMaxPool:   1,
+		MongoOpTimeout: time.Second,
+	}
+
+	err := initMongoDB(cfg)
+	if err == nil {
+		t.Fatal("initMongoDB succeeded against an unreachable server")
+	}
+	var selectErr topology.ServerSelectionError
+	if !errors.As(err, &selectErr) {
+		t.Errorf("error = %v (%T), want a server selection failure from the ping", err, err)
+	}
+	if postsCollection != nil || auditCollection != nil {
+		t.Error("collections were set up even though the ping failed")
+	}
+	_ = client.Disconnect(context.Background())
+}
+
+func TestMongoClientOptionsReplicaSet(t *testing.T) {
+	cfg := Config{MongoURI: "mongodb://localhost:27017", MongoMaxPool: 10, MongoMinPool: 2}
+
+	opts := mongoClientOptions(cfg)
+	if opts.ReplicaSet != nil || opts.ReadPreference != nil {
+		t.Errorf("standalone: replica set %v, read preference %v, want neither set", opts.ReplicaSet, opts.ReadPreference)
+	}
+
+	cfg.MongoReplicaSet = "rs0"
+	opts = mongoClientOptions(cfg)
+	if opts.ReplicaSet == nil || *opts.ReplicaSet != "rs0" {
+		t.Errorf("replica set = %v, want rs0", opts.ReplicaSet)
+	}
+	if opts.ReadPreference == nil || opts.ReadPreference.Mode() != readpref.SecondaryPreferredMode {
+		t.Errorf("read preference = %v, want secondaryPreferred", opts.ReadPreference)
+	}
+	if *opts.MaxPoolSize != 10 || *opts.MinPoolSize != 2 {
+		t.Errorf("pool sizes %d-%d, want 2-10 kept alongside the replica set", *opts.MinPoolSize, *opts.MaxPoolSize)
+	}
+}
+
+func TestGetComplaintByTicketID(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("found", func(mt *mtest.T) {
+		useCollection(mt.T, mt.Coll)
+		r := newTestRouter(mt.T, Config{})
+
+		ns := mt.DB.Name() + "." + mt.Coll.Name()
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: primitive.NewObjectID()},
+			{Key: "ticket_id", Value: "T1"},
+			{Key: "state", Value: "เสร็จสิ้น"},
+		}))
+
+		w := serve(r, http.MethodGet, "/api/v1/complaints/T1", "")
+		if w.Code != http.StatusOK {
+			mt.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+		}
+		var body map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			mt.Fatalf("decode body: %v", err)
+		}
+		if body["ticket_id"] != "T1" || body["state"] != "เสร็จสิ้น" {
+			mt.Errorf("body = %v, want the stored document", body)
+		}
+
+		started := mt.GetStartedEvent()
+		if started == nil || started.CommandName != "find" {
+			mt.Fatalf("started command = %v, want find", started)
+		}
+		if limit := started.Command.Lookup("limit").Int64(); limit != 1 {
+			mt.Errorf("find limit = %d, want 1", limit)
+		}
+		clauses, _ := started.Command.Lookup("filter", "$or").Array().Values()
+		if len(clauses) != 2 {
+			mt.Fatalf("filter has %d $or clauses, want one per schema", len(clauses))
+		}
+		if got := clauses[0].Document().Lookup("properties.ticket_id").StringValue(); got != "T1" {
+			mt.Errorf("feature clause matches %q, want T1", got)
+		}
+		if got := clauses[1].Document().Lookup("ticket_id").StringValue(); got != "T1" {
+			mt.Errorf("complaint clause matches %q, want T1", got)
+		}
+	})
+
+	mt.Run("not found", func(mt *mtest.T) {
+		useCollection(mt.T, mt.Coll)
+		r := newTestRouter(mt.T, Config{})
+
+		ns := mt.DB.Name() + "." + mt.Coll.Name()
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch))
+
+		w := serve(r, http.MethodGet, "/api/v1/complaints/missing", "")
+		if w.Code != http.StatusNotFound {
+			mt.Errorf("status = %d, want 404: %s", w.Code, w.Body)
+		}
+		if started := mt.GetStartedEvent(); started == nil || started.CommandName != "find" {
+			mt.Errorf("started command = %v, want find", started)
+		}
+	})
+
+	mt.Run("query error", func(mt *mtest.T) {
+		useCollection(mt.T, mt.Coll)
+		r := newTestRouter(mt.T, Config{})
+
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{
+			Code:    13,
+			Name:    "Unauthorized",
+			Message: "not authorized",
+		}))
+
+		w := serve(r, http.MethodGet, "/api/v1/complaints/T1", "")
+		if w.Code != http.StatusInternalServerError {
+			mt.Errorf("status = %d, want 500: %s", w.Code, w.Body)
+		}
+		if failed := mt.GetFailedEvent(); failed == nil || failed.CommandName != "find" {
+			mt.Errorf("failed command = %v, want find", failed)
+		}
+	})
+}
+
+func TestMongoPoolOptionsFromEnv(t *testing.T) {
+	t.Setenv("MONGO_URI", "mongodb://127.0.0.1:1/?serverSelectionTimeoutMS=100&connectTimeoutMS=100")
+	t.Setenv("MONGO_MAX_POOL", "42")
+	t.Setenv("MONGO_MIN_POOL", "3")
+	t.Setenv("MONGO_IDLE_TIMEOUT_SEC", "7")
+
+	opts := mongoClientOptions(loadConfig())
+	if opts.MaxConnIdleTime == nil || *opts.MaxConnIdleTime != 7*time.Second {
+		t.Errorf("MaxConnIdleTime = %v, want 7s", opts.MaxConnIdleTime)
+	}
+
+	// The driver reports the pool it builds for each server to the pool
+	// monitor, so the sizes are checked on what it actually created.
+	created := make(chan *event.MonitorPoolOptions, 1)
+	opts.SetPoolMonitor(&event.PoolMonitor{Event: func(e *event.PoolEvent) {
+		if e.Type == event.PoolCreated {
+			select {
+			case created <- e.PoolOptions:
+			default:
+			}
+		}
+	}})
+
+	c, err := mongo.Connect(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer c.Disconnect(context.Background())
+
+	select {
+	case pool := <-created:
+		if pool.MaxPoolSize != 42 || pool.MinPoolSize != 3 {
+			t.Errorf("pool sizes %d-%d, want 3-42 from the environment", pool.MinPoolSize, pool.MaxPoolSize)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the driver never created a connection pool")
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+type SyncJob struct {
+	ID         string    `json:"job_id"`
+	Start      string    `json:"start"`
+	End        string    `json:"end"`
+	Format     string    `json:"format"`
+	Status     string    `json:"status"`
+	Count      int       `json:"count"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+}
+
+// JobRegistry keeps the state of background sync jobs in memory. Jobs are
+// lost on restart.
+type JobRegistry struct {
+	mu      sync.RWMutex
+	jobs    map[string]*SyncJob
+	running sync.WaitGroup
+}
+
+func NewJobRegistry() *JobRegistry {
+	return &JobRegistry{jobs: map[string]*SyncJob{}}
+}
+
+func (r *JobRegistry) Create(start, end, format string) SyncJob {
+	job := &SyncJob{
+		ID:        uuid.NewString(),
+		Start:     start,
+		End:       end,
+		Format:    format,
+		Status:    JobPending,
+		CreatedAt: time.Now(),
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.jobs[job.ID] = job
+
+	return *job
+}
+
+func (r *JobRegistry) Get(id string) (SyncJob, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	job, ok := r.jobs[id]
+	if !ok {
+		return SyncJob{}, false
+	}
+	return *job, true
+}
+
+func (r *JobRegistry) update(id string, fn func(job *SyncJob)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if job, ok := r.jobs[id]; ok {
+		fn(job)
+	}
+}
+
+// Start runs the job in the background. Wait blocks until it returns.
+func (r *JobRegistry) Start(ctx context.Context, job SyncJob) {
+	r.running.Add(1)
+	go func() {
+		defer r.running.Done()
+		r.Run(ctx, job)
+	}()
+}
+
+// Wait blocks until every job started with Start has returned.
+func (r *JobRegistry) Wait() {
+	r.running.Wait()
+}
+
+// Run ingests the job's date range and records the outcome.
+func (r *JobRegistry) Run(ctx context.Context, job SyncJob) {
+	r.update(job.ID, func(j *SyncJob) { j.Status = JobRunning })
+
+	ingest := syncRange
+	if job.Format == "csv" {
+		ingest = syncRangeCSV
+	}
+	count, err := ingest(ctx, job.Start, job.End)
+
+	r.update(job.ID, func(j *SyncJob) {
+		j.Count = count
+		j.FinishedAt = time.Now()
+		if err != nil {
+			j.Status = JobFailed
+			j.Error = err.Error()
+			return
+		}
+		j.Status = JobDone
+	})
+
+	if err != nil {
+		slog.Error("Sync job failed", "job_id", job.ID, "count", count, "error", err)
+		return
+	}
+	slog.Info("Sync job finished", "job_id", job.ID, "count", count)
+}
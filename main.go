@@ -1,29 +1,42 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"io"
+	"log/slog"
+	"math"
 	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
-const (
-	mongoURI       = "mongodb://localhost:27023"
-	databaseName   = "traffyFondue"
-	collectionName = "postsTraffyFondue"
-)
+// version is the API version reported by GET /health.
+const version = "1.0.0"
+
+const defaultTraffyBaseURL = "https://publicapi.traffy.in.th/teamchadchart-stat-api/geojson/v1"
+
+// traffyBaseURL is the upstream endpoint; TRAFFY_BASE_URL overrides it so a
+// fake upstream can stand in for the real one.
+var traffyBaseURL = defaultTraffyBaseURL
 
 var client *mongo.Client
+var httpClient = &http.Client{Timeout: defaultHTTPTimeout}
 var postsCollection *mongo.Collection
 
 type Data struct {
@@ -49,88 +62,218 @@ type SumState struct {
 }
 
 type Feature struct {
-	Type       string      `json:"type"`
-	Geometry   Coordinates `json:"geometry"`
-	Properties Properties  `json:"properties"`
-	CreatedAt  time.Time   `json:"created_at"`
+	Type       string      `json:"type" bson:"type"`
+	Geometry   Coordinates `json:"geometry" bson:"geometry"`
+	Properties Properties  `json:"properties" bson:"properties"`
+	// CreatedAt is only ever written by $setOnInsert, so it is left nil
+	// in the $set half of the upsert; see saveFeaturesToMongoDB.
+	CreatedAt *time.Time `json:"created_at,omitempty" bson:"created_at,omitempty"`
+	// Schema is set to schemaFeature when saving; see schemaField.
+	Schema string `json:"-" bson:"_schema_version,omitempty"`
+	// SchemaVersion is set to currentSchemaVersion when saving, so reindex
+	// skips documents that are already current.
+	SchemaVersion int32 `json:"-" bson:"schema_version,omitempty"`
+	// PhotoReachable is only set when photos were checked on ingestion.
+	PhotoReachable *bool `json:"photo_reachable,omitempty" bson:"_photo_reachable,omitempty"`
 }
 
 type Properties struct {
-	ProblemTypeFondue   []string    `json:"problem_type_fondue"`
-	Org                 []string    `json:"org"`
-	Description         string      `json:"description"`
-	TicketID            string      `json:"ticket_id"`
-	PhotoURL            string      `json:"photo_url"`
-	AfterPhoto          string      `json:"after_photo"`
-	Address             string      `json:"address"`
-	Subdistrict         string      `json:"subdistrict"`
-	District            string      `json:"district"`
-	Province            string      `json:"province"`
-	Timestamp           string      `json:"timestamp"`
-	ProblemTypeAbdul    interface{} `json:"problem_type_abdul"`
-	Star                interface{} `json:"star"`
-	CountReopen         int         `json:"count_reopen"`
-	Note                interface{} `json:"note"`
-	DescriptionReporter interface{} `json:"description_reporter"`
-	State               string      `json:"state"`
-	StateTypeLatest     string      `json:"state_type_latest"`
-	LastActivity        string      `json:"last_activity"`
-	Type                string      `json:"type"`
-	SeeInfo             bool        `json:"see_info"`
+	ProblemTypeFondue   []string    `json:"problem_type_fondue" bson:"problem_type_fondue"`
+	Org                 []string    `json:"org" bson:"org"`
+	Description         string      `json:"description" bson:"description"`
+	TicketID            string      `json:"ticket_id" bson:"ticket_id"`
+	PhotoURL            string      `json:"photo_url" bson:"photo_url"`
+	AfterPhoto          string      `json:"after_photo" bson:"after_photo"`
+	Address             string      `json:"address" bson:"address"`
+	Subdistrict         string      `json:"subdistrict" bson:"subdistrict"`
+	District            string      `json:"district" bson:"district"`
+	Province            string      `json:"province" bson:"province"`
+	Timestamp           string      `json:"timestamp" bson:"timestamp"`
+	ProblemTypeAbdul    interface{} `json:"problem_type_abdul" bson:"problem_type_abdul"`
+	Star                interface{} `json:"star" bson:"star"`
+	CountReopen         int         `json:"count_reopen" bson:"count_reopen"`
+	Note                interface{} `json:"note" bson:"note"`
+	DescriptionReporter interface{} `json:"description_reporter" bson:"description_reporter"`
+	State               string      `json:"state" bson:"state"`
+	StateTypeLatest     string      `json:"state_type_latest" bson:"state_type_latest"`
+	LastActivity        string      `json:"last_activity" bson:"last_activity"`
+	Type                string      `json:"type" bson:"type"`
+	SeeInfo             bool        `json:"see_info" bson:"see_info"`
+	// DistanceM is only set on /complaints/nearby results and never stored.
+	DistanceM *float64 `json:"distance_m,omitempty" bson:"-"`
+	// DistrictEn and ProvinceEn are only set when ?lang=en is requested.
+	DistrictEn string `json:"district_en,omitempty" bson:"-"`
+	ProvinceEn string `json:"province_en,omitempty" bson:"-"`
 }
 
 type Complaint struct {
-	Address            string `json:"address"`
-	Comment            string `json:"comment"`
-	Coords             string `json:"coords"`
-	CountReopen        string `json:"count_reopen"`
-	District           string `json:"district"`
-	LastActivity       string `json:"last_activity"`
-	Organization       string `json:"organization"`
-	OrganizationAction string `json:"organization_action"`
-	Photo              string `json:"photo"`
-	PhotoAfter         string `json:"photo_after"`
-	Province           string `json:"province"`
-	Star               string `json:"star"`
-	State              string `json:"state"`
-	Subdistrict        string `json:"subdistrict"`
-	Timestamp          string `json:"timestamp"`
-	Type               string `json:"type"`
-	TicketID           string `json:"ticket_id"`
+	Address            string `json:"address" bson:"address"`
+	Comment            string `json:"comment" bson:"comment"`
+	Coords             string `json:"coords" bson:"coords"`
+	CountReopen        string `json:"count_reopen" bson:"count_reopen"`
+	District           string `json:"district" bson:"district"`
+	LastActivity       string `json:"last_activity" bson:"last_activity"`
+	Organization       string `json:"organization" bson:"organization"`
+	OrganizationAction string `json:"organization_action" bson:"organization_action"`
+	Photo              string `json:"photo" bson:"photo"`
+	PhotoAfter         string `json:"photo_after" bson:"photo_after"`
+	Province           string `json:"province" bson:"province"`
+	Star               string `json:"star" bson:"star"`
+	State              string `json:"state" bson:"state"`
+	Subdistrict        string `json:"subdistrict" bson:"subdistrict"`
+	Timestamp          string `json:"timestamp" bson:"timestamp"`
+	Type               string `json:"type" bson:"type"`
+	TicketID           string `json:"ticket_id" bson:"ticket_id"`
+
+	// Geometry is parsed from Coords when saving, so stored complaints can
+	// be queried spatially like features.
+	Geometry *Coordinates `json:"geometry,omitempty" bson:"geometry,omitempty"`
+
+	CreatedAt *time.Time `json:"created_at,omitempty" bson:"created_at,omitempty"`
+	// Schema is set to schemaComplaint when saving; see schemaField.
+	Schema string `json:"-" bson:"_schema_version,omitempty"`
+	// SchemaVersion is set to currentSchemaVersion when saving.
+	SchemaVersion int32 `json:"-" bson:"schema_version,omitempty"`
+
+	// DistrictEn and ProvinceEn are only set when ?lang=en is requested.
+	DistrictEn string `json:"district_en,omitempty" bson:"-"`
+	ProvinceEn string `json:"province_en,omitempty" bson:"-"`
 }
 
 type Coordinates struct {
-	Type        string    `json:"type"`
-	Coordinates []float64 `json:"coordinates"`
+	Type        string    `json:"type" bson:"type"`
+	Coordinates []float64 `json:"coordinates" bson:"coordinates"`
+}
+
+// Cache holds the most recent upstream response. Handlers read it while
+// fetchData replaces it, so all access goes through the mutex.
+type Cache struct {
+	mu   sync.RWMutex
+	data Data
+}
+
+func (c *Cache) Get() Data {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.data
+}
+
+func (c *Cache) Set(data Data) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data = data
 }
 
-var dataCache Data // Data
+var dataCache Cache
 
-func fetchData(start, end string, offset, limit int) error {
-	url := fmt.Sprintf(
-		"https://publicapi.traffy.in.th/teamchadchart-stat-api/geojson/v1?output_format=json/?start=%s&end=%s&limit=%d&offset=%d",
-		start, end, limit, offset,
-	)
+const maxRetryDelay = 30 * time.Second
 
-	resp, err := http.Get(url)
+var (
+	retryAttempts  = 3
+	retryBaseDelay = 500 * time.Millisecond
+)
+
+// upstreamKey identifies an upstream request for the response caches. The
+// CSV-only fields are left empty for JSON requests.
+type upstreamKey struct {
+	Start, End    string
+	Offset, Limit int
+	Filter        UpstreamFilter
+	Name, Org     string
+	Purpose       string
+	Email         string
+}
+
+// Upstream responses for identical requests are reused for a short while.
+// main resizes both caches from the config.
+var (
+	upstreamJSONCache = NewLRUCache[upstreamKey, Data](defaultUpstreamCacheSize, defaultUpstreamCacheTTL)
+	upstreamCSVCache  = NewLRUCache[upstreamKey, string](defaultUpstreamCacheSize, defaultUpstreamCacheTTL)
+)
+
+func fetchData(ctx context.Context, start, end string, offset, limit int, filter UpstreamFilter) error {
+	key := upstreamKey{Start: start, End: end, Offset: offset, Limit: limit, Filter: filter}
+	if cached, ok := upstreamJSONCache.Get(key); ok {
+		dataCache.Set(cached)
+		return nil
+	}
+
+	newData, err := fetchPage(ctx, start, end, offset, limit, filter)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
+
+	upstreamJSONCache.Set(key, newData)
+	dataCache.Set(newData)
+
+	return nil
+}
+
+// fetchPage fetches one page from the upstream JSON API without touching
+// dataCache, so concurrent ingestion workers don't overwrite each other.
+func fetchPage(ctx context.Context, start, end string, offset, limit int, filter UpstreamFilter) (Data, error) {
+	params := url.Values{}
+	params.Add("output_format", "json")
+	params.Add("start", start)
+	params.Add("end", end)
+	params.Add("limit", fmt.Sprintf("%d", limit))
+	params.Add("offset", fmt.Sprintf("%d", offset))
+	filter.apply(params)
+
+	fetchURL, err := upstreamURL(params)
+	if err != nil {
+		return Data{}, err
+	}
 
 	var newData Data
 
-	if err := json.NewDecoder(resp.Body).Decode(&newData); err != nil {
-		return err
+	err = withRetry(retryAttempts, retryBaseDelay, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, fetchURL, nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := upstreamDo(ctx, req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return fmt.Errorf("upstream returned %s", resp.Status)
+		}
+
+		newData = Data{}
+		return json.NewDecoder(resp.Body).Decode(&newData)
+	})
+
+	return newData, err
+}
+
+// fetchDataCSV fetches one CSV page through upstreamCSVCache. Ingestion
+// walks each page once, so it uses fetchCSVPage instead and leaves the
+// cache to the read endpoints.
+func fetchDataCSV(ctx context.Context, start, end string, offset, limit int, name, org, purpose, email string, filter UpstreamFilter) (string, error) {
+	key := upstreamKey{
+		Start: start, End: end, Offset: offset, Limit: limit, Filter: filter,
+		Name: name, Org: org, Purpose: purpose, Email: email,
+	}
+	if cached, ok := upstreamCSVCache.Get(key); ok {
+		return cached, nil
+	}
+
+	data, err := fetchCSVPage(ctx, start, end, offset, limit, name, org, purpose, email, filter)
+	if err != nil {
+		return "", err
 	}
 
-	dataCache = newData
+	upstreamCSVCache.Set(key, data)
 
-	return nil
+	return data, nil
 }
 
-func fetchDataCSV(start, end string, offset, limit int, name, org, purpose, email string) (string, error) {
-	baseURL := "https://publicapi.traffy.in.th/teamchadchart-stat-api/geojson/v1"
+// fetchCSVPage fetches one page from the upstream CSV API without caching it.
+func fetchCSVPage(ctx context.Context, start, end string, offset, limit int, name, org, purpose, email string, filter UpstreamFilter) (string, error) {
 	params := url.Values{}
 	params.Add("output_format", "csv")
 	params.Add("start", start)
@@ -141,16 +284,34 @@ func fetchDataCSV(start, end string, offset, limit int, name, org, purpose, emai
 	params.Add("org", org)
 	params.Add("purpose", purpose)
 	params.Add("email", email)
+	filter.apply(params)
 
-	fetchURL := fmt.Sprintf("%s?%s", baseURL, params.Encode())
-
-	resp, err := http.Get(fetchURL)
+	fetchURL, err := upstreamURL(params)
 	if err != nil {
 		return "", err
 	}
-	defer resp.Body.Close()
 
-	data, err := io.ReadAll(resp.Body)
+	var data []byte
+
+	err = withRetry(retryAttempts, retryBaseDelay, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, fetchURL, nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := upstreamDo(ctx, req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return fmt.Errorf("upstream returned %s", resp.Status)
+		}
+
+		data, err = io.ReadAll(resp.Body)
+		return err
+	})
 	if err != nil {
 		return "", err
 	}
@@ -158,310 +319,1026 @@ func fetchDataCSV(start, end string, offset, limit int, name, org, purpose, emai
 	return string(data), nil
 }
 
-func convertCSVToJSON(csvData string) (string, error) {
-	r := csv.NewReader(bytes.NewReader([]byte(csvData)))
+// upstreamChunkDays is the longest date range requested from the upstream
+// API at once; longer ranges run into its record limits.
+const upstreamChunkDays = 31
+
+// splitDateRange splits the inclusive day range [start, end] into
+// consecutive, non-overlapping inclusive sub-ranges of at most chunkDays
+// days each. It returns nil if end is before start.
+func splitDateRange(start, end time.Time, chunkDays int) [][2]time.Time {
+	if chunkDays < 1 {
+		chunkDays = 1
+	}
 
-	records, err := r.ReadAll()
+	var chunks [][2]time.Time
+	for from := start; !from.After(end); {
+		to := from.AddDate(0, 0, chunkDays-1)
+		if to.After(end) {
+			to = end
+		}
+		chunks = append(chunks, [2]time.Time{from, to})
+		from = to.AddDate(0, 0, 1)
+	}
+	return chunks
+}
+
+// upstreamChunks splits a start/end pair of plain dates into sub-ranges of
+// upstreamChunkDays. Open-ended ranges and ranges with times are returned
+// as a single chunk.
+func upstreamChunks(start, end string) [][2]string {
+	startTime, startErr := parseDate(start)
+	endTime, endErr := parseDate(end)
+	if startErr != nil || endErr != nil || !isDateOnly(start) || !isDateOnly(end) {
+		return [][2]string{{start, end}}
+	}
+
+	var chunks [][2]string
+	for _, chunk := range splitDateRange(startTime, endTime, upstreamChunkDays) {
+		chunks = append(chunks, [2]string{chunk[0].Format(dateLayouts[0]), chunk[1].Format(dateLayouts[0])})
+	}
+	return chunks
+}
+
+// pagingChunks returns the date sub-ranges a paginated fetch walks through.
+// An explicit offset refers to the range as a whole, so it is only split
+// into upstreamChunkDays sub-ranges when starting from the beginning.
+func pagingChunks(start, end string, offset int) [][2]string {
+	if offset > 0 {
+		return [][2]string{{start, end}}
+	}
+	return upstreamChunks(start, end)
+}
+
+// fetchDataWithPagination fetches every feature between start and end in
+// pages of limit, starting at offset, and hands each page to save as soon
+// as it arrives. It returns the total the upstream reported across all
+// sub-ranges; save is never called when that is 0.
+func fetchDataWithPagination(ctx context.Context, start, end string, offset, limit int, filter UpstreamFilter, save func(Data) error) (int, error) {
+	total := 0
+	for _, chunk := range pagingChunks(start, end, offset) {
+		chunkTotal, err := fetchRangeWithPagination(ctx, chunk[0], chunk[1], offset, limit, filter, save)
+		total += chunkTotal
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// fetchRangeWithPagination fetches every feature between start and end in
+// pages of limit, starting at offset. The first page reports the total;
+// the remaining pages are fetched and saved ingestWorkers at a time, so
+// save must be safe for concurrent use.
+func fetchRangeWithPagination(ctx context.Context, start, end string, offset, limit int, filter UpstreamFilter, save func(Data) error) (int, error) {
+	first, err := fetchPage(ctx, start, end, offset, limit, filter)
 	if err != nil {
-		return "", err
+		return 0, fmt.Errorf("failed to fetch data: %w", err)
+	}
+	if first.Total == 0 {
+		return 0, nil
+	}
+	if limit > first.Total {
+		limit = first.Total
+	}
+
+	if err := save(first); err != nil {
+		return first.Total, err
 	}
 
-	if len(records) == 0 {
-		return "", nil
+	remaining := 0
+	if rest := first.Total - offset - limit; rest > 0 {
+		remaining = (rest + limit - 1) / limit
+	}
+
+	errs := ingestBatches(ingestWorkers, remaining, offset+limit, limit, func(i, batchOffset int) error {
+		data, err := fetchPage(ctx, start, end, batchOffset, limit, filter)
+		if err != nil {
+			return fmt.Errorf("failed to fetch data: %w", err)
+		}
+		return save(data)
+	})
+	if len(errs) > 0 {
+		return first.Total, errors.New(strings.Join(errs, "; "))
 	}
+	return first.Total, nil
+}
+
+// IngestProgress is reported by ingestWithProgress after each saved batch.
+type IngestProgress struct {
+	Batch      int `json:"batch"`
+	Inserted   int `json:"inserted"`
+	TotalSoFar int `json:"total_so_far"`
+	Failed     int `json:"failed"`
+}
+
+// ingestWithProgress fetches the features between start and end one page of
+// batchSize at a time and saves each page before fetching the next, calling
+// progress after every save. Unlike fetchDataWithPagination it runs
+// sequentially so batches are reported in order.
+func ingestWithProgress(ctx context.Context, start, end string, batchSize int, filter UpstreamFilter, progress func(IngestProgress)) (IngestProgress, error) {
+	var status IngestProgress
+	for _, chunk := range upstreamChunks(start, end) {
+		for offset := 0; ; offset += batchSize {
+			data, err := fetchPage(ctx, chunk[0], chunk[1], offset, batchSize, filter)
+			if err != nil {
+				return status, err
+			}
+			if len(data.Features) == 0 {
+				break
+			}
 
-	var jsonArray []map[string]string
-	headers := records[0]
+			saved, err := complaintStore.InsertFeatures(ctx, data.Features)
+			if err == nil {
+				err = saved.Err()
+			}
+			status.Failed += saved.Failed
+			if err != nil {
+				return status, err
+			}
+			status.Batch++
+			status.Inserted = saved.Inserted
+			status.TotalSoFar += saved.Inserted
+			progress(status)
 
-	for _, record := range records[1:] {
-		jsonItem := make(map[string]string)
-		for i, header := range headers {
-			jsonItem[header] = record[i]
+			if offset+batchSize >= data.Total {
+				break
+			}
 		}
-		jsonArray = append(jsonArray, jsonItem)
 	}
+	return status, nil
+}
 
-	jsonData, err := json.Marshal(jsonArray)
+// fetchDataCSVWithPagination fetches and parses every complaint between
+// start and end in pages of limit, starting at offset, and hands each
+// non-empty page to save before fetching the next. skipped counts malformed
+// rows across all pages.
+func fetchDataCSVWithPagination(ctx context.Context, start, end string, offset, limit int, name, org, purpose, email string, filter UpstreamFilter, save func([]Complaint) error) (int, error) {
+	skipped := 0
+	for _, chunk := range pagingChunks(start, end, offset) {
+		chunkSkipped, err := fetchRangeCSVWithPagination(ctx, chunk[0], chunk[1], offset, limit, name, org, purpose, email, filter, save)
+		skipped += chunkSkipped
+		if err != nil {
+			return skipped, err
+		}
+	}
+	return skipped, nil
+}
 
+// fetchRangeCSVWithPagination fetches and parses every complaint between
+// start and end in pages of limit, starting at offset. The CSV API reports
+// no total, so it stops at the first short page.
+func fetchRangeCSVWithPagination(ctx context.Context, start, end string, offset, limit int, name, org, purpose, email string, filter UpstreamFilter, save func([]Complaint) error) (int, error) {
+	skipped := 0
+	for ; ; offset += limit {
+		csvData, err := fetchCSVPage(ctx, start, end, offset, limit, name, org, purpose, email, filter)
+		if err != nil {
+			return skipped, fmt.Errorf("failed to fetch data: %w", err)
+		}
+
+		page, pageSkipped, err := convertCSVToComplaints(strings.NewReader(csvData))
+		if err != nil {
+			return skipped, fmt.Errorf("failed to parse CSV at offset %d: %w", offset, err)
+		}
+		skipped += pageSkipped
+
+		if len(page) > 0 {
+			if err := save(page); err != nil {
+				return skipped, err
+			}
+		}
+
+		if len(page)+pageSkipped < limit {
+			return skipped, nil
+		}
+	}
+}
+
+// upstreamURL adds params to the query string of traffyBaseURL, keeping any
+// query parameters the base URL already carries.
+func upstreamURL(params url.Values) (string, error) {
+	u, err := url.Parse(traffyBaseURL)
 	if err != nil {
 		return "", err
 	}
 
-	return string(jsonData), nil
+	query := u.Query()
+	for key, values := range params {
+		for _, v := range values {
+			query.Add(key, v)
+		}
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+// UpstreamFilter holds optional filters forwarded to the upstream API.
+type UpstreamFilter struct {
+	State       string
+	ProblemType string
+	Org         string
+	Province    string
+	OutputType  string
+}
+
+func (f UpstreamFilter) apply(params url.Values) {
+	if f.State != "" {
+		params.Add("state", f.State)
+	}
+	if f.ProblemType != "" {
+		params.Add("problem_type", f.ProblemType)
+	}
+	if f.Org != "" {
+		params.Add("org", f.Org)
+	}
+	if f.Province != "" {
+		params.Add("province", f.Province)
+	}
+	if f.OutputType != "" {
+		params.Add("type", f.OutputType)
+	}
+}
+
+// upstreamDo sends req with httpClient. A client timeout wraps
+// context.DeadlineExceeded like an expired ctx does, so it is reported
+// without that cause to let withRetry retry it. Only ctx ending stops the
+// retries.
+func upstreamDo(ctx context.Context, req *http.Request) (*http.Response, error) {
+	resp, err := httpClient.Do(req)
+	if err != nil && ctx.Err() == nil {
+		return nil, fmt.Errorf("upstream request failed: %v", err)
+	}
+	return resp, err
+}
+
+// withRetry calls fn up to attempts times, doubling the wait after each
+// failure starting from baseDelay and never sleeping longer than maxRetryDelay.
+func withRetry(attempts int, baseDelay time.Duration, fn func() error) error {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var err error
+	delay := baseDelay
+	for i := 0; i < attempts; i++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if i == attempts-1 || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			break
+		}
+
+		time.Sleep(delay)
+		delay *= 2
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+
+	return fmt.Errorf("after %d attempts: %w", attempts, err)
 }
 
-func initMongoDB() error {
-	clientOptions := options.Client().ApplyURI(mongoURI)
-	client, err := mongo.Connect(context.Background(), clientOptions)
+// mongoOpTimeout bounds each MongoDB operation. Handler contexts carry no
+// deadline of their own, so without it a stuck query would hang the request.
+var mongoOpTimeout = defaultMongoOpTimeout
+
+// withMongoTimeout derives a context for one MongoDB operation that ends
+// after d, or sooner if ctx already has an earlier deadline. A d of zero or
+// less leaves ctx unbounded.
+func withMongoTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// mongoClientOptions builds the client options for cfg. With a replica set
+// configured, reads go to secondaries when one is available; writes always
+// go to the primary.
+func mongoClientOptions(cfg Config) *options.ClientOptions {
+	clientOptions := options.Client().
+		ApplyURI(cfg.MongoURI).
+		SetMonitor(mongoCommandMonitor()).
+		SetMaxPoolSize(cfg.MongoMaxPool).
+		SetMinPoolSize(cfg.MongoMinPool).
+		SetMaxConnIdleTime(cfg.MongoIdleTime)
+
+	if cfg.MongoReplicaSet != "" {
+		clientOptions.
+			SetReplicaSet(cfg.MongoReplicaSet).
+			SetReadPreference(readpref.SecondaryPreferred())
+	}
+	return clientOptions
+}
+
+func initMongoDB(cfg Config) error {
+	clientOptions := mongoClientOptions(cfg)
+	slog.Info("MongoDB connection pool", "max_pool", cfg.MongoMaxPool, "min_pool", cfg.MongoMinPool, "idle_timeout", cfg.MongoIdleTime)
+	if cfg.MongoReplicaSet != "" {
+		slog.Info("MongoDB replica set", "name", cfg.MongoReplicaSet, "read_preference", "secondaryPreferred")
+	}
+	mongoOpTimeout = cfg.MongoOpTimeout
+
+	var err error
+	client, err = mongo.Connect(context.Background(), clientOptions)
 	if err != nil {
 		return err
 	}
 
-	err = client.Ping(context.Background(), nil)
+	ctx, cancel := withMongoTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+
+	err = client.Ping(ctx, nil)
 	if err != nil {
 		return err
 	}
 
-	postsCollection = client.Database(databaseName).Collection(collectionName)
+	postsCollection = client.Database(cfg.DatabaseName).Collection(cfg.CollectionName)
+	auditCollection = client.Database(cfg.DatabaseName).Collection(cfg.AuditName)
+	historyCollection = client.Database(cfg.DatabaseName).Collection(cfg.HistoryName)
 
-	return nil
+	if err := ensureAuditIndex(context.Background()); err != nil {
+		return err
+	}
+	if err := ensureHistoryIndex(context.Background()); err != nil {
+		return err
+	}
+	return ensureIndexes(context.Background())
 }
 
-func saveFeaturesToMongoDB(ctx context.Context, data Data) error {
-	var featuresAsInterfaces []interface{}
-	for _, feature := range data.Features {
-		featuresAsInterfaces = append(featuresAsInterfaces, feature)
+// BulkResult summarizes an unordered bulk write. A failed document does not
+// stop the rest of the batch; its error is collected instead.
+type BulkResult struct {
+	Inserted int
+	Updated  int
+	Failed   int
+	Errors   []error
+}
+
+// newBulkResult reads the counts from a BulkWrite and splits per-document
+// failures out of err. Any other error is returned as is.
+func newBulkResult(res *mongo.BulkWriteResult, err error) (BulkResult, error) {
+	var result BulkResult
+	if res != nil {
+		result.Inserted = int(res.InsertedCount + res.UpsertedCount)
+		result.Updated = int(res.MatchedCount)
 	}
-	_, err := postsCollection.InsertMany(ctx, featuresAsInterfaces)
-	return err
+
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) {
+		result.Failed = len(bulkErr.WriteErrors)
+		for _, writeErr := range bulkErr.WriteErrors {
+			result.Errors = append(result.Errors, writeErr)
+		}
+		if bulkErr.WriteConcernError != nil {
+			result.Errors = append(result.Errors, bulkErr.WriteConcernError)
+		}
+		return result, nil
+	}
+
+	return result, err
 }
 
-func saveFeaturesToMongoDBCSV(ctx context.Context, data []Complaint) error {
-	var featuresAsInterfaces []interface{}
-	for _, complaint := range data {
-		featuresAsInterfaces = append(featuresAsInterfaces, complaint)
+// Add accumulates the counts and errors of another batch into r.
+func (r *BulkResult) Add(other BulkResult) {
+	r.Inserted += other.Inserted
+	r.Updated += other.Updated
+	r.Failed += other.Failed
+	r.Errors = append(r.Errors, other.Errors...)
+}
+
+// Err reports the per-document failures as one error, or nil when every
+// document was saved. Callers that must not treat a partial save as success
+// check it after a nil error from the bulk write.
+func (r BulkResult) Err() error {
+	if len(r.Errors) == 0 {
+		return nil
 	}
-	_, err := postsCollection.InsertMany(ctx, featuresAsInterfaces)
-	return err
+	return fmt.Errorf("%d documents failed to save, first error: %w", r.Failed, r.Errors[0])
 }
 
-func isValidDate(date string) bool {
-	_, err := time.Parse("2006-01-02", date)
-	return err == nil
+// saveFeaturesToMongoDB upserts each feature by ticket ID in one unordered
+// bulk write, so re-ingesting a range updates documents in place. The
+// versions it changes are archived first; see archiveFeatures.
+func saveFeaturesToMongoDB(ctx context.Context, data Data) (BulkResult, error) {
+	if len(data.Features) == 0 {
+		return BulkResult{}, nil
+	}
+
+	ctx, cancel := withMongoTimeout(ctx, mongoOpTimeout)
+	defer cancel()
+
+	now := time.Now()
+	features := make([]Feature, 0, len(data.Features))
+	for _, feature := range data.Features {
+		features = append(features, feature.forStorage())
+	}
+
+	// Versions are archived before the write, so a failed archive leaves
+	// the stored features as they were.
+	if err := archiveFeatures(ctx, features, now); err != nil {
+		return BulkResult{}, fmt.Errorf("archive feature versions: %w", err)
+	}
+
+	models := make([]mongo.WriteModel, 0, len(features))
+	for _, feature := range features {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"properties.ticket_id": feature.Properties.TicketID}).
+			SetUpdate(bson.M{"$set": feature, "$setOnInsert": bson.M{"created_at": now}}).
+			SetUpsert(true))
+	}
+
+	result, err := newBulkResult(postsCollection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)))
+	recordAudit(ctx, auditInsert, "", int64(result.Inserted))
+	recordAudit(ctx, auditUpdate, "", int64(result.Updated))
+	return result, err
 }
 
-func main() {
+// forStorage prepares f to be saved by either Store. CreatedAt is cleared:
+// created_at in both $set and $setOnInsert is a conflict that fails the
+// whole update, so it is only set on insert.
+func (f Feature) forStorage() Feature {
+	f.Properties.ProblemTypeFondue = normalizeProblemTypes(f.Properties.ProblemTypeFondue)
+	f.Schema = schemaFeature
+	f.SchemaVersion = int32(currentSchemaVersion)
+	f.CreatedAt = nil
+	return f
+}
 
-	if err := initMongoDB(); err != nil {
-		fmt.Println("Failed to connect to MongoDB:", err)
-		return
+// forStorage prepares c to be saved by either Store, parsing its coords
+// into a geometry.
+func (c Complaint) forStorage(now time.Time) Complaint {
+	c.CreatedAt = &now
+	c.Schema = schemaComplaint
+	c.SchemaVersion = int32(currentSchemaVersion)
+	if point, err := c.ToGeoJSONPoint(); err == nil {
+		c.Geometry = &point
+	} else if c.Coords != "" {
+		slog.Warn("Storing complaint without geometry", "ticket_id", c.TicketID, "coords", c.Coords, "error", err)
 	}
+	return c
+}
 
-	if err := fetchData("", "", 0, 0); err != nil {
-		fmt.Println("Failed to fetch initial data:", err)
-		return
+func saveFeaturesToMongoDBCSV(ctx context.Context, data []Complaint) (BulkResult, error) {
+	if len(data) == 0 {
+		return BulkResult{}, nil
 	}
 
-	r := gin.Default()
+	ctx, cancel := withMongoTimeout(ctx, mongoOpTimeout)
+	defer cancel()
 
-	r.POST("/saveToMongoDBCSV", func(c *gin.Context) {
-		offsetStr := c.Query("offset")
-		limitStr := c.Query("limit")
-		startDate := c.Query("start")
-		endDate := c.Query("end")
-		name := c.Query("name")
-		org := c.Query("org")
-		purpose := c.Query("purpose")
-		email := c.Query("email")
-		totalCount := dataCache.CountTotal
+	now := time.Now()
+	models := make([]mongo.WriteModel, 0, len(data))
+	for _, complaint := range data {
+		models = append(models, mongo.NewInsertOneModel().SetDocument(complaint.forStorage(now)))
+	}
 
-		if startDate != "" && !isValidDate(startDate) {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date format"})
-			return
-		}
+	result, err := newBulkResult(postsCollection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)))
+	recordAudit(ctx, auditInsert, "", int64(result.Inserted))
+	return result, err
+}
 
-		if endDate != "" && !isValidDate(endDate) {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date format"})
-			return
-		}
+// bangkokTime is the offset the upstream API writes every timestamp in.
+var bangkokTime = time.FixedZone("Asia/Bangkok", 7*60*60)
 
-		offset, err := strconv.Atoi(strings.TrimSpace(offsetStr))
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
-			return
-		}
+// dateLayouts are the formats accepted for start and end parameters, tried
+// in order. Values without an offset are taken as Bangkok time.
+var dateLayouts = []string{
+	"2006-01-02",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04:05Z07:00",
+}
 
-		limit, err := strconv.Atoi(strings.TrimSpace(limitStr))
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit", "details": err.Error()})
-			return
+func parseDate(s string) (time.Time, error) {
+	var err error
+	for _, layout := range dateLayouts {
+		var t time.Time
+		if t, err = time.ParseInLocation(layout, s, bangkokTime); err == nil {
+			return t, nil
 		}
+	}
+	return time.Time{}, err
+}
 
-		totalCount = dataCache.Total
-		iterations := totalCount / 25000
+// isDateOnly reports whether s, already accepted by parseDate, has no time
+// component.
+func isDateOnly(s string) bool {
+	return len(s) == len(dateLayouts[0])
+}
 
-		if totalCount%25000 > 0 {
-			iterations++
-		}
+const maxLimit = 25000
 
-		for i := 0; i < iterations; i++ {
-			fmt.Println("Iteration", i)
-			fmt.Println("Offset", offset)
-			fmt.Println("Limit", limit)
+// parseIntParam parses the named query parameter as an integer. It writes a
+// 400 response and returns false if the value is missing or not a number.
+func parseIntParam(c *gin.Context, name string) (int, bool) {
+	v, err := strconv.Atoi(strings.TrimSpace(c.Query(name)))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name, "details": err.Error()})
+		return 0, false
+	}
+	return v, true
+}
 
-			csvData, err := fetchDataCSV(startDate, endDate, offset, limit, name, org, purpose, email)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch data"})
-				return
-			}
+// parseIntParamDefault is parseIntParam for optional parameters, returning
+// def when the parameter is absent.
+func parseIntParamDefault(c *gin.Context, name string, def int) (int, bool) {
+	if _, ok := c.GetQuery(name); !ok {
+		return def, true
+	}
+	return parseIntParam(c, name)
+}
 
-			jsonData, err := convertCSVToJSON(csvData)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to convert CSV to JSON"})
-				return
-			}
+// parsePaging reads the required offset and limit query parameters.
+func parsePaging(c *gin.Context) (int, int, bool) {
+	offset, ok := parseIntParam(c, "offset")
+	if !ok {
+		return 0, 0, false
+	}
+	limit, ok := parseIntParam(c, "limit")
+	if !ok {
+		return 0, 0, false
+	}
+	return offset, limit, validatePaging(c, offset, limit)
+}
 
-			var Complaints []Complaint
-			if err := json.Unmarshal([]byte(jsonData), &Complaints); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unmarshal JSON to dataCache", "details": err.Error()})
-				return
-			}
+// parsePagingDefault reads optional offset and limit query parameters,
+// defaulting to the first defLimit records.
+func parsePagingDefault(c *gin.Context, defLimit int) (int, int, bool) {
+	offset, ok := parseIntParamDefault(c, "offset", 0)
+	if !ok {
+		return 0, 0, false
+	}
+	limit, ok := parseIntParamDefault(c, "limit", defLimit)
+	if !ok {
+		return 0, 0, false
+	}
+	return offset, limit, validatePaging(c, offset, limit)
+}
 
-			if len(Complaints) == 0 {
-				c.JSON(http.StatusOK, gin.H{"status": "No data to insert into MongoDB"})
-				return
-			}
+func validatePaging(c *gin.Context, offset, limit int) bool {
+	if offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "offset must not be negative"})
+		return false
+	}
+	if limit <= 0 || limit > maxLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxLimit)})
+		return false
+	}
+	return true
+}
 
-			if err := saveFeaturesToMongoDBCSV(c.Request.Context(), Complaints); err != nil {
-				fmt.Println("Failed to append data to MongoDB:", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to append data to MongoDB", "details": err.Error()})
-				return
-			}
+// parseProblemType reads the optional problem_type query parameter into dst.
+// It writes a 400 response and returns false if the parameter is present but blank.
+func parseProblemType(c *gin.Context, dst *string) bool {
+	problemType, ok := c.GetQuery("problem_type")
+	if !ok {
+		return true
+	}
 
-			offset += limit
-		}
+	problemType = strings.TrimSpace(problemType)
+	if problemType == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid problem_type"})
+		return false
+	}
 
-		c.JSON(http.StatusOK, gin.H{"status": "Data successfully saved to MongoDB"})
-	})
+	*dst = problemType
+	return true
+}
 
-	r.POST("/saveToMongoDB", func(c *gin.Context) {
-		ctx := c.Request.Context()
-		offsetStr := c.Query("offset")
-		limitStr := c.Query("limit")
-		startDate := c.Query("start")
-		endDate := c.Query("end")
-		totalCount := dataCache.CountTotal
+const maxOrgLength = 100
 
-		offset, err := strconv.Atoi(strings.TrimSpace(offsetStr))
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
-			return
-		}
+// parseOrg validates an org filter value. It writes a 400 response and
+// returns false if the value is too long.
+func parseOrg(c *gin.Context, org string) bool {
+	if len([]rune(org)) > maxOrgLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("org must be at most %d characters", maxOrgLength)})
+		return false
+	}
+	return true
+}
 
-		limit, err := strconv.Atoi(strings.TrimSpace(limitStr))
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
-			return
-		}
+const maxProvinceLength = 100
 
-		totalCount = dataCache.Total
-		iterations := totalCount / 1000
+// parseProvince reads the optional province query parameter into dst. It
+// writes a 400 response and returns false if the value is too long.
+func parseProvince(c *gin.Context, dst *string) bool {
+	province := strings.TrimSpace(c.Query("province"))
+	if len([]rune(province)) > maxProvinceLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("province must be at most %d characters", maxProvinceLength)})
+		return false
+	}
+	*dst = province
+	return true
+}
 
-		if totalCount%1000 > 0 {
-			iterations++
-		}
+// outputTypePattern is what ?output_type= accepts. It is forwarded as the
+// upstream type parameter and matched against Properties.Type, whose only
+// value seen so far is "traffy_fondue".
+var outputTypePattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// parseOutputType reads the optional output_type query parameter into dst.
+// It writes a 400 response and returns false if it is given but empty or
+// not alphanumeric.
+func parseOutputType(c *gin.Context, dst *string) bool {
+	outputType, ok := c.GetQuery("output_type")
+	if !ok {
+		return true
+	}
+	if !outputTypePattern.MatchString(outputType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "output_type must be a non-empty alphanumeric string"})
+		return false
+	}
+	*dst = outputType
+	return true
+}
 
-		for i := 0; i < iterations; i++ {
-			fmt.Println("iterations", i)
-			fmt.Println("offset", offset)
-			fmt.Println("limit", limit)
-			if err := fetchData(startDate, endDate, offset, limit); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch data"})
-				return
-			}
+const maxDistricts = 20
 
-			if err := saveFeaturesToMongoDB(ctx, dataCache); err != nil { // Assuming dataCache is of type Data
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to append data to MongoDB"})
-				return
-			}
+// parseDistricts reads the optional comma-separated district query
+// parameter. It writes a 400 response and returns false if more than
+// maxDistricts are listed.
+func parseDistricts(c *gin.Context) ([]string, bool) {
+	districts := splitList(c.Query("district"))
+	if len(districts) > maxDistricts {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("district accepts at most %d values", maxDistricts)})
+		return nil, false
+	}
+	return districts, true
+}
 
-			offset += limit
-		}
+// parseBBoxParam reads the optional bbox query parameter. It writes a 400
+// response and returns false if the parameter is malformed.
+func parseBBoxParam(c *gin.Context) (*[4]float64, bool) {
+	raw, ok := c.GetQuery("bbox")
+	if !ok {
+		return nil, true
+	}
 
-		c.JSON(http.StatusOK, gin.H{"status": "Data successfully saved to MongoDB"})
-	})
+	bbox, err := parseBBox(raw)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid bbox", "details": err.Error()})
+		return nil, false
+	}
 
-	r.GET("/", func(c *gin.Context) {
-		offsetStr := c.Query("offset")
-		limitStr := c.Query("limit")
-		startDate := c.Query("start")
-		endDate := c.Query("end")
+	return &bbox, true
+}
 
-		if startDate != "" && !isValidDate(startDate) {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date format"})
-			return
+// parseStarRange reads the optional min_star and max_star query parameters
+// into filter. It writes a 400 response and returns false if either is not a
+// number or the range is empty.
+func parseStarRange(c *gin.Context, filter *ComplaintFilter) bool {
+	for _, p := range []struct {
+		name string
+		dst  **float64
+	}{{"min_star", &filter.MinStar}, {"max_star", &filter.MaxStar}} {
+		raw, ok := c.GetQuery(p.name)
+		if !ok {
+			continue
 		}
-
-		if endDate != "" && !isValidDate(endDate) {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date format"})
-			return
+		v, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s must be a number", p.name)})
+			return false
 		}
+		*p.dst = &v
+	}
 
-		offset, err := strconv.Atoi(strings.TrimSpace(offsetStr))
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
-			return
-		}
+	if filter.MinStar != nil && filter.MaxStar != nil && *filter.MinStar > *filter.MaxStar {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "min_star must not exceed max_star"})
+		return false
+	}
+	return true
+}
 
-		limit, err := strconv.Atoi(strings.TrimSpace(limitStr))
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit", "details": err.Error()})
-			return
+// parseReopenRange reads the optional min_reopen and max_reopen query
+// parameters into filter. It writes a 400 response and returns false if
+// either is not a non-negative integer or the range is empty.
+func parseReopenRange(c *gin.Context, filter *ComplaintFilter) bool {
+	for _, p := range []struct {
+		name string
+		dst  **int
+	}{{"min_reopen", &filter.MinReopen}, {"max_reopen", &filter.MaxReopen}} {
+		raw, ok := c.GetQuery(p.name)
+		if !ok {
+			continue
 		}
-
-		if err := fetchData(startDate, endDate, offset, limit); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch data"})
-			return
+		v, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil || v < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s must be a non-negative integer", p.name)})
+			return false
 		}
+		*p.dst = &v
+	}
 
-		c.JSON(http.StatusOK, dataCache)
-	})
+	if filter.MinReopen != nil && filter.MaxReopen != nil && *filter.MinReopen > *filter.MaxReopen {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "min_reopen must not exceed max_reopen"})
+		return false
+	}
+	return true
+}
 
-	r.GET("/topojson", func(c *gin.Context) {
-		offsetStr := c.Query("offset")
-		limitStr := c.Query("limit")
-		startDate := c.Query("start")
-		endDate := c.Query("end")
-		name := c.Query("name")
-		org := c.Query("org")
-		purpose := c.Query("purpose")
-		email := c.Query("email")
-
-		if startDate != "" && !isValidDate(startDate) {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date format"})
-			return
-		}
+// parseDryRun reads the optional dry_run query parameter. It writes a 400
+// response and returns false as its second value if it is not a boolean.
+func parseDryRun(c *gin.Context) (bool, bool) {
+	raw, ok := c.GetQuery("dry_run")
+	if !ok {
+		return false, true
+	}
 
-		if endDate != "" && !isValidDate(endDate) {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date format"})
-			return
-		}
+	dryRun, err := strconv.ParseBool(raw)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "dry_run must be true or false"})
+		return false, false
+	}
+	return dryRun, true
+}
 
-		offset, err := strconv.Atoi(strings.TrimSpace(offsetStr))
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
-			return
-		}
+// parseValidatePhotos reads the optional validate_photos query parameter,
+// writing a 400 response and returning ok=false if it is not a boolean.
+func parseValidatePhotos(c *gin.Context) (validate, ok bool) {
+	raw, present := c.GetQuery("validate_photos")
+	if !present {
+		return false, true
+	}
 
-		limit, err := strconv.Atoi(strings.TrimSpace(limitStr))
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit", "details": err.Error()})
-			return
-		}
+	validate, err := strconv.ParseBool(raw)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "validate_photos must be true or false"})
+		return false, false
+	}
+	return validate, true
+}
 
-		csvData, err := fetchDataCSV(startDate, endDate, offset, limit, name, org, purpose, email)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch CSV data"})
-			return
-		}
+// parseSeeInfo reads the optional see_info query parameter into filter. It
+// writes a 400 response and returns false for anything but true or false.
+func parseSeeInfo(c *gin.Context, filter *ComplaintFilter) bool {
+	raw, ok := c.GetQuery("see_info")
+	if !ok {
+		return true
+	}
 
-		jsonData, err := convertCSVToJSON(csvData)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to convert CSV to JSON"})
-			return
-		}
+	seeInfo, err := strconv.ParseBool(raw)
+	if err != nil || (raw != "true" && raw != "false") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "see_info must be true or false"})
+		return false
+	}
+	filter.SeeInfo = &seeInfo
+	return true
+}
+
+// parseSchema reads the optional schema query parameter into filter. It
+// writes a 400 response and returns false for anything but "feature" or
+// "complaint".
+func parseSchema(c *gin.Context, filter *ComplaintFilter) bool {
+	schema := c.Query("schema")
+	if schema != "" && schema != schemaFeature && schema != schemaComplaint {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "schema must be feature or complaint"})
+		return false
+	}
+	filter.Schema = schema
+	return true
+}
+
+// parseLastActivityRange reads the optional last_activity_after and
+// last_activity_before query parameters into filter. It writes a 400
+// response and returns false if either is malformed or after is later than
+// before.
+func parseLastActivityRange(c *gin.Context, filter *ComplaintFilter) bool {
+	filter.LastActivityAfter = c.Query("last_activity_after")
+	filter.LastActivityBefore = c.Query("last_activity_before")
+
+	if _, err := parseDate(filter.LastActivityAfter); filter.LastActivityAfter != "" && err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid last_activity_after format"})
+		return false
+	}
+
+	if _, err := parseDate(filter.LastActivityBefore); filter.LastActivityBefore != "" && err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid last_activity_before format"})
+		return false
+	}
+
+	after, before := rangeBounds(filter.LastActivityAfter, filter.LastActivityBefore)
+	if !after.IsZero() && !before.IsZero() && !after.Before(before) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "last_activity_after must not be later than last_activity_before"})
+		return false
+	}
+	return true
+}
+
+// parseComplaintFilter reads the filter query parameters shared by the
+// endpoints that list or count complaints. It writes a 400 response and
+// returns false if any is malformed.
+func parseComplaintFilter(c *gin.Context) (ComplaintFilter, bool) {
+	filter := ComplaintFilter{
+		Start: c.Query("start"),
+		End:   c.Query("end"),
+		State: c.Query("state"),
+		Org:   strings.TrimSpace(c.Query("org")),
+	}
+
+	if !isValidState(filter.State) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid state", "allowed": knownStates})
+		return filter, false
+	}
+
+	if !parseOrg(c, filter.Org) {
+		return filter, false
+	}
+
+	if !parseProblemType(c, &filter.ProblemType) {
+		return filter, false
+	}
+
+	if !parseProvince(c, &filter.Province) {
+		return filter, false
+	}
+
+	if !parseOutputType(c, &filter.OutputType) {
+		return filter, false
+	}
+
+	bbox, ok := parseBBoxParam(c)
+	if !ok {
+		return filter, false
+	}
+	filter.BBox = bbox
+
+	filter.Districts, ok = parseDistricts(c)
+	if !ok {
+		return filter, false
+	}
+
+	if !parseStarRange(c, &filter) || !parseReopenRange(c, &filter) || !parseSeeInfo(c, &filter) {
+		return filter, false
+	}
+
+	if !parseSchema(c, &filter) || !parseLastActivityRange(c, &filter) {
+		return filter, false
+	}
+
+	if _, err := parseDate(filter.Start); filter.Start != "" && err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date format"})
+		return filter, false
+	}
+
+	if _, err := parseDate(filter.End); filter.End != "" && err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date format"})
+		return filter, false
+	}
+	return filter, true
+}
+
+// knownStates mirrors the keys of SumState.
+var knownStates = []string{"finish", "follow", "forward", "inprogress", "irrelevant", "start"}
 
-		var Complaints []Complaint
-		if err := json.Unmarshal([]byte(jsonData), &Complaints); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unmarshal JSON to dataCache", "details": err.Error()})
-			return
+// isValidState reports whether state is empty or one of knownStates.
+func isValidState(state string) bool {
+	if state == "" {
+		return true
+	}
+	for _, s := range knownStates {
+		if s == state {
+			return true
 		}
+	}
+	return false
+}
 
-		c.JSON(http.StatusOK, Complaints)
-	})
+// applyUpstreamConfig sets the upstream client settings the fetch helpers
+// read from package variables.
+func applyUpstreamConfig(cfg Config) {
+	traffyBaseURL = cfg.TraffyBaseURL
+	retryAttempts = cfg.RetryAttempts
+	retryBaseDelay = cfg.RetryBaseDelay
+	httpClient = &http.Client{Timeout: cfg.HTTPTimeout}
+	ingestWorkers = cfg.IngestWorkers
+	upstreamJSONCache = NewLRUCache[upstreamKey, Data](cfg.UpstreamCacheSize, cfg.UpstreamCacheTTL)
+	upstreamCSVCache = NewLRUCache[upstreamKey, string](cfg.UpstreamCacheSize, cfg.UpstreamCacheTTL)
+}
 
-	err := r.Run(":8000")
+func main() {
+	cfg := loadConfig()
+	initLogger(cfg.LogFormat)
+
+	if err := cfg.validate(); err != nil {
+		slog.Error("Invalid configuration", "error", err)
+		return
+	}
+
+	batch, err := loadBatchConfig()
 	if err != nil {
+		slog.Error("Invalid batch configuration", "error", err)
+		return
+	}
+	applyUpstreamConfig(cfg)
+
+	if err := initMongoDB(cfg); err != nil {
+		slog.Error("Failed to connect to MongoDB", "error", err)
+		return
+	}
+
+	if err := fetchData(context.Background(), "", "", 0, 0, UpstreamFilter{}); err != nil {
+		slog.Error("Failed to fetch initial data", "error", err)
 		return
 	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var scheduler *Scheduler
+	if cfg.SyncInterval > 0 {
+		scheduler = NewScheduler(cfg.SyncInterval, syncRecent)
+		scheduler.Start(ctx)
+	}
+
+	r := gin.New()
+	r.Use(RequestID(), RequestLogger(slog.Default()), gin.Recovery(), PrometheusMetrics(), CORSMiddleware(cfg.CORSOrigins), BodyLimit(cfg.MaxRequestBodyBytes))
+
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	if cfg.JWTSecret == "" {
+		slog.Warn("JWT_SECRET is not set; write endpoints will reject every request")
+	}
+
+	appConfig = cfg
+	batchConfig = batch
+	syncScheduler = scheduler
+	backgroundCtx = ctx
+
+	v1 := r.Group("/api/v1")
+	RegisterV1Routes(v1)
+
+	// The unversioned paths predate /api/v1 and stay as deprecated aliases.
+	legacy := r.Group("", Deprecated("/api/v1"))
+	RegisterV1Routes(legacy)
+
+	server := &http.Server{Addr: ":" + cfg.ServerPort, Handler: r}
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case sig := <-quit:
+		slog.Info("Shutting down", "signal", sig.String())
+	case err := <-serverErr:
+		slog.Error("Server stopped", "error", err)
+	}
+
+	// Background syncs are cancelled right away; in-flight requests get
+	// until the timeout to finish their writes.
+	cancel()
+
+	shutdownCtx, stop := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer stop()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Failed to drain in-flight requests", "error", err)
+	}
+
+	waits := []func(){syncJobs.Wait}
+	if scheduler != nil {
+		waits = append(waits, scheduler.Wait)
+	}
+	if !waitAll(shutdownCtx, waits...) {
+		slog.Error("Background syncs did not stop before the shutdown timeout")
+	}
+
+	if err := client.Disconnect(shutdownCtx); err != nil {
+		slog.Error("Failed to disconnect from MongoDB", "error", err)
+	}
+}
+
+// shutdownTimeout bounds how long the server waits for in-flight work on
+// SIGINT or SIGTERM.
+const shutdownTimeout = 30 * time.Second
+
+// waitAll runs each wait concurrently and reports whether all of them
+// returned before ctx was done.
+func waitAll(ctx context.Context, waits ...func()) bool {
+	done := make(chan struct{})
+	go func() {
+		var wg sync.WaitGroup
+		for _, wait := range waits {
+			wg.Add(1)
+			go func(wait func()) {
+				defer wg.Done()
+				wait()
+			}(wait)
+		}
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
 }
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// recordPaging wraps handler and records the offset and limit of every
+// upstream request it serves.
+func recordPaging(handler http.HandlerFunc) (http.HandlerFunc, func() [][2]int) {
+	var mu sync.Mutex
+	var requests [][2]int
+	return func(w http.ResponseWriter, r *http.Request) {
+			offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+			limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+			mu.Lock()
+			requests = append(requests, [2]int{offset, limit})
+			mu.Unlock()
+			handler(w, r)
+		}, func() [][2]int {
+			mu.Lock()
+			defer mu.Unlock()
+			sorted := slices.Clone(requests)
+			slices.SortFunc(sorted, func(a, b [2]int) int { return a[0] - b[0] })
+			return sorted
+		}
+}
+
+func TestFetchDataWithPaginationSavesEachPage(t *testing.T) {
+	handler, requests := recordPaging(pagedUpstream(250))
+	useUpstream(t, handler)
+
+	var mu sync.Mutex
+	var pages []int
+	seen := map[string]bool{}
+	total, err := fetchDataWithPagination(context.Background(), "", "", 0, 100, UpstreamFilter{}, func(data Data) error {
+		mu.Lock()
+		defer mu.Unlock()
+		pages = append(pages, len(data.Features))
+		for _, f := range data.Features {
+			seen[f.Properties.TicketID] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("fetchDataWithPagination: %v", err)
+	}
+	if total != 250 {
+		t.Errorf("total = %d, want 250", total)
+	}
+
+	slices.Sort(pages)
+	if !slices.Equal(pages, []int{50, 100, 100}) {
+		t.Errorf("saved pages of %v, want 100, 100 and 50", pages)
+	}
+	if len(seen) != 250 {
+		t.Errorf("saved %d distinct features, want 250", len(seen))
+	}
+	if got := requests(); !slices.Equal(got, [][2]int{{0, 100}, {100, 100}, {200, 100}}) {
+		t.Errorf("upstream requests = %v, want offsets 0, 100 and 200", got)
+	}
+}
+
+func TestFetchDataWithPaginationOffsetAndCap(t *testing.T) {
+	handler, requests := recordPaging(pagedUpstream(30))
+	useUpstream(t, handler)
+
+	saved := 0
+	total, err := fetchDataWithPagination(context.Background(), "2024-01-01", "2024-01-10", 10, 500, UpstreamFilter{}, func(data Data) error {
+		saved += len(data.Features)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("fetchDataWithPagination: %v", err)
+	}
+	if total != 30 || saved != 20 {
+		t.Errorf("total = %d, saved = %d; want 30 and the 20 records after offset 10", total, saved)
+	}
+	if got := requests(); !slices.Equal(got, [][2]int{{10, 500}}) {
+		t.Errorf("upstream requests = %v, want a single page from offset 10", got)
+	}
+}
+
+func TestFetchDataWithPaginationZeroTotal(t *testing.T) {
+	useUpstream(t, pagedUpstream(0))
+
+	total, err := fetchDataWithPagination(context.Background(), "", "", 0, 100, UpstreamFilter{}, func(Data) error {
+		t.Error("save called for an empty upstream")
+		return nil
+	})
+	if err != nil || total != 0 {
+		t.Errorf("fetchDataWithPagination = %d, %v; want 0, nil", total, err)
+	}
+}
+
+func TestFetchDataCSVWithPaginationSavesEachPage(t *testing.T) {
+	handler, requests := recordPaging(pagedUpstream(25))
+	useUpstream(t, handler)
+
+	var pages []int
+	skipped, err := fetchDataCSVWithPagination(context.Background(), "", "", 0, 10, "", "", "", "", UpstreamFilter{}, func(complaints []Complaint) error {
+		pages = append(pages, len(complaints))
+		return nil
+	})
+	if err != nil || skipped != 0 {
+		t.Fatalf("fetchDataCSVWithPagination = %d, %v; want 0, nil", skipped, err)
+	}
+	if !slices.Equal(pages, []int{10, 10, 5}) {
+		t.Errorf("saved pages of %v, want 10, 10 and 5", pages)
+	}
+	if got := requests(); !slices.Equal(got, [][2]int{{0, 10}, {10, 10}, {20, 10}}) {
+		t.Errorf("upstream requests = %v, want offsets 0, 10 and 20", got)
+	}
+}
+
+func TestUpstreamCacheServesRepeatRequests(t *testing.T) {
+	var mu sync.Mutex
+	calls := map[string]int{}
+	upstream := pagedUpstream(5)
+	useUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls[r.URL.Query().Get("output_format")]++
+		mu.Unlock()
+		upstream(w, r)
+	})
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := fetchData(ctx, "2024-01-01", "2024-01-31", 0, 10, UpstreamFilter{}); err != nil {
+			t.Fatalf("fetchData: %v", err)
+		}
+		if _, err := fetchDataCSV(ctx, "2024-01-01", "2024-01-31", 0, 10, "", "", "", "", UpstreamFilter{}); err != nil {
+			t.Fatalf("fetchDataCSV: %v", err)
+		}
+	}
+	if calls["json"] != 1 || calls["csv"] != 1 {
+		t.Errorf("upstream calls = %v, want one per format within the TTL", calls)
+	}
+
+	if _, err := fetchDataCSV(ctx, "2024-01-01", "2024-01-31", 10, 10, "", "", "", "", UpstreamFilter{}); err != nil {
+		t.Fatalf("fetchDataCSV: %v", err)
+	}
+	if calls["csv"] != 2 {
+		t.Errorf("csv calls = %d after a different page, want 2", calls["csv"])
+	}
+}
+
+func TestCSVIngestionBypassesCache(t *testing.T) {
+	useUpstream(t, pagedUpstream(25))
+
+	_, err := fetchDataCSVWithPagination(context.Background(), "", "", 0, 10, "", "", "", "", UpstreamFilter{}, func([]Complaint) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("fetchDataCSVWithPagination: %v", err)
+	}
+	if n := upstreamCSVCache.Stats().Entries; n != 0 {
+		t.Errorf("ingestion left %d pages in the CSV cache, want none", n)
+	}
+}
+
+// Run with -race: handlers read dataCache while fetchData replaces it.
+func TestDataCacheConcurrentAccess(t *testing.T) {
+	useUpstream(t, pagedUpstream(5))
+	prev := dataCache.Get()
+	t.Cleanup(func() { dataCache.Set(prev) })
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			// Distinct offsets so every call misses the upstream cache and
+			// writes dataCache.
+			if err := fetchData(context.Background(), "", "", i, 5, UpstreamFilter{}); err != nil {
+				t.Errorf("fetchData: %v", err)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				data := dataCache.Get()
+				if data.Total != 0 && data.Total != 5 {
+					t.Errorf("read a torn Data with total %d", data.Total)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := dataCache.Get().Total; got != 5 {
+		t.Errorf("dataCache total = %d, want 5", got)
+	}
+}
+
+func TestFetchPageBuildsWellFormedURL(t *testing.T) {
+	var requestURI string
+	upstream := pagedUpstream(1)
+	useUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		requestURI = r.URL.RequestURI()
+		upstream(w, r)
+	})
+
+	if _, err := fetchPage(context.Background(), "2024-01-01", "2024-01-31", 20, 10, UpstreamFilter{State: "เสร็จสิ้น"}); err != nil {
+		t.Fatalf("fetchPage: %v", err)
+	}
+	if strings.Count(requestURI, "?") != 1 || strings.Contains(requestURI, "/?") {
+		t.Fatalf("request URI %q is malformed", requestURI)
+	}
+
+	query, err := url.ParseQuery(requestURI[strings.Index(requestURI, "?")+1:])
+	if err != nil {
+		t.Fatalf("parse query: %v", err)
+	}
+	want := map[string]string{
+		"output_format": "json", "start": "2024-01-01", "end": "2024-01-31",
+		"offset": "20", "limit": "10", "state": "เสร็จสิ้น",
+	}
+	for key, value := range want {
+		if got := query.Get(key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+}
+
+func TestUpstreamURLKeepsBaseQuery(t *testing.T) {
+	prev := traffyBaseURL
+	t.Cleanup(func() { traffyBaseURL = prev })
+	traffyBaseURL = "https://example.com/export?key=abc"
+
+	got, err := upstreamURL(url.Values{"start": {"2024-01-01"}})
+	if err != nil {
+		t.Fatalf("upstreamURL: %v", err)
+	}
+	if want := "https://example.com/export?key=abc&start=2024-01-01"; got != want {
+		t.Errorf("upstreamURL = %q, want %q", got, want)
+	}
+}
+
+// queryContext returns a gin context for a GET request with the given query
+// string, and the recorder its response is written to.
+func queryContext(query string) (*gin.Context, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/?"+query, nil)
+	return c, w
+}
+
+func TestParseIntParam(t *testing.T) {
+	tests := []struct {
+		query  string
+		want   int
+		wantOK bool
+	}{
+		{"n=42", 42, true},
+		{"n=%2042%20", 42, true},
+		{"n=-3", -3, true},
+		{"n=abc", 0, false},
+		{"n=", 0, false},
+		{"", 0, false},
+		{"n=1.5", 0, false},
+	}
+	for _, tc := range tests {
+		c, w := queryContext(tc.query)
+		got, ok := parseIntParam(c, "n")
+		if got != tc.want || ok != tc.wantOK {
+			t.Errorf("parseIntParam(%q) = %d, %v, want %d, %v", tc.query, got, ok, tc.want, tc.wantOK)
+		}
+		if !ok && w.Code != http.StatusBadRequest {
+			t.Errorf("parseIntParam(%q) wrote status %d, want 400", tc.query, w.Code)
+		}
+		if ok && c.Writer.Written() {
+			t.Errorf("parseIntParam(%q) wrote a response for a valid value", tc.query)
+		}
+	}
+}
+
+func TestParseIntParamDefault(t *testing.T) {
+	c, _ := queryContext("")
+	if got, ok := parseIntParamDefault(c, "n", 7); got != 7 || !ok {
+		t.Errorf("absent parameter = %d, %v, want the default 7", got, ok)
+	}
+
+	c, w := queryContext("n=x")
+	if _, ok := parseIntParamDefault(c, "n", 7); ok || w.Code != http.StatusBadRequest {
+		t.Errorf("invalid parameter accepted, status %d", w.Code)
+	}
+}
+
+func TestParsePaging(t *testing.T) {
+	tests := []struct {
+		query  string
+		wantOK bool
+	}{
+		{"offset=0&limit=1", true},
+		{"offset=10&limit=" + strconv.Itoa(maxLimit), true},
+		{"offset=-1&limit=10", false},
+		{"offset=0&limit=0", false},
+		{"offset=0&limit=" + strconv.Itoa(maxLimit+1), false},
+		{"limit=10", false},
+		{"offset=0", false},
+	}
+	for _, tc := range tests {
+		c, w := queryContext(tc.query)
+		if _, _, ok := parsePaging(c); ok != tc.wantOK {
+			t.Errorf("parsePaging(%q) ok = %v, want %v", tc.query, ok, tc.wantOK)
+		}
+		if !tc.wantOK && w.Code != http.StatusBadRequest {
+			t.Errorf("parsePaging(%q) wrote status %d, want 400", tc.query, w.Code)
+		}
+	}
+}
+
+func TestSplitDateRange(t *testing.T) {
+	day := func(s string) time.Time {
+		d, err := time.Parse(time.DateOnly, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	tests := []struct {
+		name       string
+		start, end string
+		chunkDays  int
+		want       [][2]string
+	}{
+		{"single day", "2024-03-05", "2024-03-05", 7, [][2]string{{"2024-03-05", "2024-03-05"}}},
+		{"shorter than a chunk", "2024-03-01", "2024-03-04", 7, [][2]string{{"2024-03-01", "2024-03-04"}}},
+		{"exactly one chunk", "2024-03-01", "2024-03-07", 7, [][2]string{{"2024-03-01", "2024-03-07"}}},
+		{"exactly two chunks", "2024-03-01", "2024-03-14", 7, [][2]string{
+			{"2024-03-01", "2024-03-07"}, {"2024-03-08", "2024-03-14"},
+		}},
+		{"partial final chunk", "2024-03-01", "2024-03-10", 7, [][2]string{
+			{"2024-03-01", "2024-03-07"}, {"2024-03-08", "2024-03-10"},
+		}},
+		{"leap-year February", "2024-02-01", "2024-03-01", 29, [][2]string{
+			{"2024-02-01", "2024-02-29"}, {"2024-03-01", "2024-03-01"},
+		}},
+		{"non-leap February", "2023-02-01", "2023-03-01", 29, [][2]string{
+			{"2023-02-01", "2023-03-01"},
+		}},
+		{"across a year end", "2023-12-25", "2024-01-05", 7, [][2]string{
+			{"2023-12-25", "2023-12-31"}, {"2024-01-01", "2024-01-05"},
+		}},
+		{"zero chunk size means one day", "2024-03-01", "2024-03-02", 0, [][2]string{
+			{"2024-03-01", "2024-03-01"}, {"2024-03-02", "2024-03-02"},
+		}},
+		{"end before start", "2024-03-02", "2024-03-01", 7, nil},
+	}
+	for _, tc := range tests {
+		var got [][2]string
+		for _, chunk := range splitDateRange(day(tc.start), day(tc.end), tc.chunkDays) {
+			got = append(got, [2]string{chunk[0].Format(time.DateOnly), chunk[1].Format(time.DateOnly)})
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%s: splitDateRange = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestShutdownWaitsForInFlightWork(t *testing.T) {
+	useStore(t)
+
+	jobStarted, jobRelease := make(chan struct{}), make(chan struct{})
+	var startOnce sync.Once
+	useUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		startOnce.Do(func() { close(jobStarted) })
+		<-jobRelease
+		pagedUpstream(3)(w, r)
+	})
+
+	jobs := NewJobRegistry()
+	job := jobs.Create("2024-01-01", "2024-01-02", "json")
+	jobs.Start(context.Background(), job)
+	<-jobStarted
+
+	requestStarted, requestRelease := make(chan struct{}), make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(requestStarted)
+		<-requestRelease
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	status := make(chan int, 1)
+	go func() {
+		resp, err := http.Get(server.URL)
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+	<-requestStarted
+
+	shutdownCtx, stop := context.WithTimeout(context.Background(), 5*time.Second)
+	defer stop()
+
+	shutdownErr := make(chan error, 1)
+	go func() { shutdownErr <- server.Config.Shutdown(shutdownCtx) }()
+	select {
+	case err := <-shutdownErr:
+		t.Fatalf("Shutdown returned %v with a request in flight", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(requestRelease)
+	if err := <-shutdownErr; err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+	if got := <-status; got != http.StatusOK {
+		t.Errorf("in-flight request got status %d, want 200", got)
+	}
+
+	expired, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if waitAll(expired, jobs.Wait) {
+		t.Fatal("waitAll returned true with a sync job still running")
+	}
+
+	close(jobRelease)
+	if !waitAll(shutdownCtx, jobs.Wait) {
+		t.Fatal("waitAll timed out after the sync job was released")
+	}
+	if got, _ := jobs.Get(job.ID); got.Status != JobDone || got.Count != 3 {
+		t.Errorf("job = %+v, want done with 3 saved", got)
+	}
+}
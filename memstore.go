@@ -0,0 +1,673 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryStore keeps documents in memory in the form MongoStore saves them,
+// so they decode with the same helpers. Queries MongoDB answers with
+// indexes or aggregations are done in Go instead. The zero value is ready
+// to use.
+type MemoryStore struct {
+	mu sync.RWMutex
+	// docs is in _id order: documents are appended with new ObjectIDs and
+	// replaced in place.
+	docs []bson.Raw
+	// versions holds what historyCollection would, oldest first.
+	versions []featureVersion
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// InsertFeatures upserts features by ticket ID, overwriting the top-level
+// fields of an existing document the way MongoStore's $set does.
+func (s *MemoryStore) InsertFeatures(ctx context.Context, features []Feature) (BulkResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var result BulkResult
+	for _, feature := range features {
+		feature = feature.forStorage()
+		fields, err := toM(feature)
+		if err != nil {
+			return result, err
+		}
+
+		i := slices.IndexFunc(s.docs, func(doc bson.Raw) bool {
+			return isFeatureDoc(doc) && docTicketID(doc) == feature.Properties.TicketID
+		})
+		doc := bson.M{"_id": primitive.NewObjectID(), "created_at": now}
+		if i >= 0 {
+			versions, err := changedVersions([]bson.Raw{s.docs[i]}, []Feature{feature}, now)
+			if err != nil {
+				return result, err
+			}
+			s.versions = append(s.versions, versions...)
+
+			doc = bson.M{}
+			if err := bson.Unmarshal(s.docs[i], &doc); err != nil {
+				return result, err
+			}
+		}
+		for k, v := range fields {
+			doc[k] = v
+		}
+
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			return result, err
+		}
+		if i >= 0 {
+			s.docs[i] = raw
+			result.Updated++
+		} else {
+			s.docs = append(s.docs, raw)
+			result.Inserted++
+		}
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) InsertComplaints(ctx context.Context, complaints []Complaint) (BulkResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var result BulkResult
+	for _, complaint := range complaints {
+		doc, err := toM(complaint.forStorage(now))
+		if err != nil {
+			return result, err
+		}
+		doc["_id"] = primitive.NewObjectID()
+
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			return result, err
+		}
+		s.docs = append(s.docs, raw)
+		result.Inserted++
+	}
+	return result, nil
+}
+
+// FindComplaints calls fn on a snapshot, so fn may use the store itself.
+func (s *MemoryStore) FindComplaints(ctx context.Context, filter ComplaintFilter, fn func(Complaint) error) error {
+	docs, err := s.find(filter)
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		complaint, err := decodeComplaint(doc)
+		if err != nil {
+			return err
+		}
+		if err := fn(complaint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// find returns the documents filter matches, of either schema unless
+// filter.Schema selects one, like storeFilter.
+func (s *MemoryStore) find(filter ComplaintFilter) ([]bson.Raw, error) {
+	var found []bson.Raw
+	for _, doc := range s.snapshot() {
+		ok, err := filter.matchesDoc(doc)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			found = append(found, doc)
+		}
+	}
+	return found, nil
+}
+
+func (s *MemoryStore) snapshot() []bson.Raw {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return slices.Clone(s.docs)
+}
+
+func (s *MemoryStore) CountComplaints(ctx context.Context, filter ComplaintFilter) (int64, error) {
+	docs, err := s.find(filter)
+	return int64(len(docs)), err
+}
+
+func (s *MemoryStore) DeleteOne(ctx context.Context, ticketID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.docs, func(doc bson.Raw) bool { return docTicketID(doc) == ticketID })
+	if i < 0 {
+		return errTicketNotFound
+	}
+	s.docs = slices.Delete(s.docs, i, i+1)
+	return nil
+}
+
+func (s *MemoryStore) DeleteComplaints(ctx context.Context, filter ComplaintFilter) (int64, error) {
+	docs, err := s.find(filter)
+	if err != nil {
+		return 0, err
+	}
+	return s.deleteIDs(docIDs(docs)), nil
+}
+
+// deleteIDs removes the documents with the given IDs and reports how many
+// there were.
+func (s *MemoryStore) deleteIDs(ids []primitive.ObjectID) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	before := len(s.docs)
+	s.docs = slices.DeleteFunc(s.docs, func(doc bson.Raw) bool { return slices.Contains(ids, docID(doc)) })
+	return int64(before - len(s.docs))
+}
+
+// FindFeatures pages through the documents of the schema filter selects,
+// features if it is unset, in _id order like findComplaints.
+func (s *MemoryStore) FindFeatures(ctx context.Context, filter ComplaintFilter, offset, limit int, cursor string) (ComplaintsPage, error) {
+	if filter.Schema == "" {
+		filter.Schema = schemaFeature
+	}
+	docs, err := s.find(filter)
+	if err != nil {
+		return ComplaintsPage{}, err
+	}
+	total := len(docs)
+
+	if cursor != "" {
+		after, err := primitive.ObjectIDFromHex(cursor)
+		if err != nil {
+			return ComplaintsPage{}, err
+		}
+		docs = slices.DeleteFunc(docs, func(doc bson.Raw) bool {
+			id := docID(doc)
+			return bytes.Compare(id[:], after[:]) <= 0
+		})
+	} else {
+		docs = docs[min(offset, len(docs)):]
+	}
+
+	hasMore := len(docs) > limit
+	if hasMore {
+		docs = docs[:limit]
+	}
+
+	features, err := decodeFeatures(docs)
+	if err != nil {
+		return ComplaintsPage{}, err
+	}
+	page := ComplaintsPage{Data: features, Meta: newPageMeta(total, offset, limit, hasMore)}
+	if hasMore {
+		page.Meta.NextCursor = docID(docs[len(docs)-1]).Hex()
+	}
+	return page, nil
+}
+
+// FindStale matches staleComplaints: features whose last_activity sorts
+// before threshold, compared as strings, least recently updated first.
+func (s *MemoryStore) FindStale(ctx context.Context, threshold time.Time, state string, offset, limit int) (ComplaintsPage, error) {
+	before := staleBefore(threshold)
+
+	var docs []bson.Raw
+	for _, doc := range s.snapshot() {
+		activity, _ := doc.Lookup("properties", "last_activity").StringValueOK()
+		docState, _ := doc.Lookup("properties", "state").StringValueOK()
+		if activity > "" && activity < before && (state == "" || docState == state) {
+			docs = append(docs, doc)
+		}
+	}
+	slices.SortStableFunc(docs, func(a, b bson.Raw) int {
+		x, _ := a.Lookup("properties", "last_activity").StringValueOK()
+		y, _ := b.Lookup("properties", "last_activity").StringValueOK()
+		return strings.Compare(x, y)
+	})
+
+	return pageOf(docs, offset, limit)
+}
+
+// SearchFeatures stands in for the $text search in searchComplaints. A
+// feature matches when its description or address contains any word of
+// q, ignoring case, and ranks by how many words it contains.
+func (s *MemoryStore) SearchFeatures(ctx context.Context, q string, filter ComplaintFilter, offset, limit int) (ComplaintsPage, error) {
+	filter.Schema = schemaFeature
+	docs, err := s.find(filter)
+	if err != nil {
+		return ComplaintsPage{}, err
+	}
+
+	terms := strings.Fields(strings.ToLower(q))
+	scores := map[primitive.ObjectID]int{}
+	docs = slices.DeleteFunc(docs, func(doc bson.Raw) bool {
+		description, _ := doc.Lookup("properties", "description").StringValueOK()
+		address, _ := doc.Lookup("properties", "address").StringValueOK()
+		text := strings.ToLower(description + " " + address)
+		for _, term := range terms {
+			if strings.Contains(text, term) {
+				scores[docID(doc)]++
+			}
+		}
+		return scores[docID(doc)] == 0
+	})
+	slices.SortStableFunc(docs, func(a, b bson.Raw) int {
+		return cmp.Compare(scores[docID(b)], scores[docID(a)])
+	})
+
+	return pageOf(docs, offset, limit)
+}
+
+// FindNearby returns the point documents of either schema within radiusM
+// of lng, lat, nearest first.
+func (s *MemoryStore) FindNearby(ctx context.Context, lng, lat, radiusM float64, limit int) ([]Feature, error) {
+	features := []Feature{}
+	for _, doc := range s.snapshot() {
+		if docGeometryType(doc) != "Point" {
+			continue
+		}
+		feature, err := decodeFeature(doc)
+		if err != nil {
+			return nil, err
+		}
+		coords := feature.Geometry.Coordinates
+		if len(coords) < 2 {
+			continue
+		}
+		if d := haversineM(lng, lat, coords[0], coords[1]); d <= radiusM {
+			feature.Properties.DistanceM = &d
+			features = append(features, feature)
+		}
+	}
+
+	slices.SortStableFunc(features, func(a, b Feature) int {
+		return cmp.Compare(*a.Properties.DistanceM, *b.Properties.DistanceM)
+	})
+	return features[:min(limit, len(features))], nil
+}
+
+// Heatmap snaps points the way heatmapCells does. MongoDB's $round rounds
+// half to even, so math.RoundToEven is used rather than math.Round.
+func (s *MemoryStore) Heatmap(ctx context.Context, bbox *[4]float64, gridSize float64) ([]HeatmapCell, error) {
+	type key struct{ lng, lat float64 }
+	counts := map[key]int{}
+	for _, doc := range s.snapshot() {
+		if docGeometryType(doc) != "Point" {
+			continue
+		}
+		feature, err := decodeFeature(doc)
+		if err != nil {
+			return nil, err
+		}
+		coords := feature.Geometry.Coordinates
+		if len(coords) < 2 || (bbox != nil && !bboxContains(*bbox, coords[0], coords[1])) {
+			continue
+		}
+		snap := func(v float64) float64 { return math.RoundToEven(v/gridSize) * gridSize }
+		counts[key{snap(coords[0]), snap(coords[1])}]++
+	}
+
+	cells := []HeatmapCell{}
+	for k, n := range counts {
+		cells = append(cells, HeatmapCell{Lat: k.lat, Lng: k.lng, Count: n})
+	}
+	slices.SortFunc(cells, func(a, b HeatmapCell) int {
+		if a.Count != b.Count {
+			return cmp.Compare(b.Count, a.Count)
+		}
+		if a.Lat != b.Lat {
+			return cmp.Compare(a.Lat, b.Lat)
+		}
+		return cmp.Compare(a.Lng, b.Lng)
+	})
+	return cells, nil
+}
+
+func (s *MemoryStore) FindOne(ctx context.Context, ticketID string) (bson.M, error) {
+	for _, doc := range s.snapshot() {
+		if docTicketID(doc) == ticketID {
+			var found bson.M
+			err := bson.Unmarshal(doc, &found)
+			return found, err
+		}
+	}
+	return nil, errTicketNotFound
+}
+
+func (s *MemoryStore) UpdateOne(ctx context.Context, ticketID string, update ComplaintUpdate) (bson.M, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.docs, func(doc bson.Raw) bool { return docTicketID(doc) == ticketID })
+	if i < 0 {
+		return nil, errTicketNotFound
+	}
+	updated, _, err := s.apply(i, update)
+	return updated, err
+}
+
+func (s *MemoryStore) UpdateMany(ctx context.Context, ticketIDs []string, update ComplaintUpdate) (int64, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var matched, modified int64
+	for i, doc := range s.docs {
+		if !slices.Contains(ticketIDs, docTicketID(doc)) {
+			continue
+		}
+		matched++
+		_, changed, err := s.apply(i, update)
+		if err != nil {
+			return matched, modified, err
+		}
+		if changed {
+			modified++
+		}
+	}
+	return matched, modified, nil
+}
+
+// apply sets the fields of update on s.docs[i], named for the document's
+// schema, and reports whether any stored value changed. s.mu must be held.
+func (s *MemoryStore) apply(i int, update ComplaintUpdate) (bson.M, bool, error) {
+	raw := s.docs[i]
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, false, err
+	}
+
+	changed := false
+	for path, value := range update.set(isFeatureDoc(raw)) {
+		keys := strings.Split(path, ".")
+		parent := doc
+		for _, key := range keys[:len(keys)-1] {
+			child, ok := parent[key].(bson.M)
+			if !ok {
+				child = bson.M{}
+				parent[key] = child
+			}
+			parent = child
+		}
+		parent[keys[len(keys)-1]] = value
+
+		// Compare encoded values, as MongoDB does, so an int set over the
+		// same stored number isn't a change.
+		valueType, data, err := bson.MarshalValue(value)
+		if err != nil {
+			return nil, false, err
+		}
+		if old := raw.Lookup(keys...); old.Type != valueType || !bytes.Equal(old.Value, data) {
+			changed = true
+		}
+	}
+
+	updated, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, false, err
+	}
+	s.docs[i] = updated
+	return doc, changed, nil
+}
+
+// History combines archived and current versions as featureHistory does.
+func (s *MemoryStore) History(ctx context.Context, ticketID string) ([]HistoryEntry, error) {
+	s.mu.RLock()
+	var archived []featureVersion
+	for _, v := range s.versions {
+		if v.TicketID == ticketID {
+			archived = append(archived, v)
+		}
+	}
+	var current []bson.Raw
+	for _, doc := range s.docs {
+		if docTicketID(doc) == ticketID {
+			current = append(current, doc)
+		}
+	}
+	s.mu.RUnlock()
+
+	return buildHistory(ticketVersions(archived, current))
+}
+
+// duplicates groups documents as duplicateGroups does: by ticket ID and
+// schema, keeping groups of more than one. Each group's IDs are in _id
+// order.
+func (s *MemoryStore) duplicates() [][]bson.Raw {
+	type key struct {
+		ticketID string
+		feature  bool
+	}
+	var keys []key
+	groups := map[key][]bson.Raw{}
+	for _, doc := range s.snapshot() {
+		ticketID, ok := doc.Lookup("properties", "ticket_id").StringValueOK()
+		if !ok {
+			if ticketID, ok = doc.Lookup("ticket_id").StringValueOK(); !ok {
+				continue
+			}
+		}
+		k := key{ticketID, isFeatureDoc(doc)}
+		if groups[k] == nil {
+			keys = append(keys, k)
+		}
+		groups[k] = append(groups[k], doc)
+	}
+
+	var duplicates [][]bson.Raw
+	for _, k := range keys {
+		if len(groups[k]) > 1 {
+			duplicates = append(duplicates, groups[k])
+		}
+	}
+	slices.SortStableFunc(duplicates, func(a, b []bson.Raw) int {
+		if len(a) != len(b) {
+			return cmp.Compare(len(b), len(a))
+		}
+		return strings.Compare(docTicketID(a[0]), docTicketID(b[0]))
+	})
+	return duplicates
+}
+
+func (s *MemoryStore) FindDuplicates(ctx context.Context, offset, limit int) (int, []DuplicateTicket, error) {
+	duplicates := s.duplicates()
+
+	items := []DuplicateTicket{}
+	for _, group := range duplicates[min(offset, len(duplicates)):min(offset+limit, len(duplicates))] {
+		items = append(items, DuplicateTicket{TicketID: docTicketID(group[0]), Count: len(group)})
+	}
+	return len(duplicates), items, nil
+}
+
+// DeleteDuplicates keeps the copy with the highest _id in each group, like
+// deleteDuplicates.
+func (s *MemoryStore) DeleteDuplicates(ctx context.Context) (int64, error) {
+	var stale []primitive.ObjectID
+	for _, group := range s.duplicates() {
+		stale = append(stale, docIDs(group[:len(group)-1])...)
+	}
+	return s.deleteIDs(stale), nil
+}
+
+// pageOf returns the offset/limit page of docs, which have already been
+// filtered and sorted, as features.
+func pageOf(docs []bson.Raw, offset, limit int) (ComplaintsPage, error) {
+	total := len(docs)
+	docs = docs[min(offset, total):min(offset+limit, total)]
+
+	features, err := decodeFeatures(docs)
+	if err != nil {
+		return ComplaintsPage{}, err
+	}
+	return ComplaintsPage{
+		Data: features,
+		Meta: newPageMeta(total, offset, limit, offset+len(features) < total),
+	}, nil
+}
+
+func decodeFeatures(docs []bson.Raw) ([]Feature, error) {
+	features := make([]Feature, 0, len(docs))
+	for _, doc := range docs {
+		feature, err := decodeFeature(doc)
+		if err != nil {
+			return nil, err
+		}
+		features = append(features, feature)
+	}
+	return features, nil
+}
+
+// toM encodes v and decodes it back as a document whose fields can be
+// changed before it is stored.
+func toM(v any) (bson.M, error) {
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.M
+	err = bson.Unmarshal(raw, &doc)
+	return doc, err
+}
+
+func docID(doc bson.Raw) primitive.ObjectID {
+	id, _ := doc.Lookup("_id").ObjectIDOK()
+	return id
+}
+
+func docIDs(docs []bson.Raw) []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, 0, len(docs))
+	for _, doc := range docs {
+		ids = append(ids, docID(doc))
+	}
+	return ids
+}
+
+// docTicketID reads the ticket ID of a document of either schema, the
+// fields ticketFilter matches on.
+func docTicketID(doc bson.Raw) string {
+	if id, ok := doc.Lookup("properties", "ticket_id").StringValueOK(); ok {
+		return id
+	}
+	id, _ := doc.Lookup("ticket_id").StringValueOK()
+	return id
+}
+
+func isFeatureDoc(doc bson.Raw) bool {
+	_, ok := doc.Lookup("properties").DocumentOK()
+	return ok
+}
+
+func docSchema(doc bson.Raw) string {
+	if isFeatureDoc(doc) {
+		return schemaFeature
+	}
+	return schemaComplaint
+}
+
+func docGeometryType(doc bson.Raw) string {
+	t, _ := doc.Lookup("geometry", "type").StringValueOK()
+	return t
+}
+
+// matchesDoc applies f to a stored document of either schema. Only
+// features carry see_info and an output type, so those are checked on the
+// document before the rest goes through matches.
+func (f ComplaintFilter) matchesDoc(doc bson.Raw) (bool, error) {
+	complaint, err := decodeComplaint(doc)
+	if err != nil {
+		return false, err
+	}
+	complaint.Schema = docSchema(doc)
+
+	if isFeatureDoc(doc) {
+		if outputType, _ := doc.Lookup("properties", "type").StringValueOK(); f.OutputType != "" && outputType != f.OutputType {
+			return false, nil
+		}
+		if seeInfo, ok := doc.Lookup("properties", "see_info").BooleanOK(); f.SeeInfo != nil && (!ok || seeInfo != *f.SeeInfo) {
+			return false, nil
+		}
+		f.OutputType, f.SeeInfo = "", nil
+	}
+	return f.matches(complaint), nil
+}
+
+// matches applies filter to c the way bsonFor("") does in MongoDB.
+// Complaints carry no see_info or output type, so filters on either never
+// match.
+func (f ComplaintFilter) matches(c Complaint) bool {
+	lower, upper := rangeBounds(f.Start, f.End)
+	if !lower.IsZero() && c.Timestamp < lower.In(bangkokTime).Format(storedTimestampLayout) {
+		return false
+	}
+	if !upper.IsZero() && c.Timestamp >= upper.In(bangkokTime).Format(storedTimestampLayout) {
+		return false
+	}
+	if f.State != "" && c.State != f.State {
+		return false
+	}
+	if f.ProblemType != "" && !strings.Contains(c.Type, f.ProblemType) {
+		return false
+	}
+	if f.Org != "" && !strings.Contains(strings.ToLower(c.Organization), strings.ToLower(f.Org)) {
+		return false
+	}
+	if f.Province != "" && c.Province != f.Province {
+		return false
+	}
+	if len(f.Districts) > 0 && !slices.Contains(f.Districts, c.District) {
+		return false
+	}
+	if f.BBox != nil {
+		lng, lat, ok := complaintLngLat(c.Coords)
+		if !ok || !bboxContains(*f.BBox, lng, lat) {
+			return false
+		}
+	}
+	if f.SeeInfo != nil || f.OutputType != "" {
+		return false
+	}
+	if f.Schema != "" && c.Schema != f.Schema {
+		return false
+	}
+	if f.MinStar != nil || f.MaxStar != nil {
+		star, err := strconv.ParseFloat(strings.TrimSpace(c.Star), 64)
+		if err != nil || !inRange(star, f.MinStar, f.MaxStar) {
+			return false
+		}
+	}
+	if f.MinReopen != nil || f.MaxReopen != nil {
+		reopen, err := strconv.ParseFloat(strings.TrimSpace(c.CountReopen), 64)
+		if err != nil || !inRange(reopen, intToFloat(f.MinReopen), intToFloat(f.MaxReopen)) {
+			return false
+		}
+	}
+
+	lower, upper = rangeBounds(f.LastActivityAfter, f.LastActivityBefore)
+	if !lower.IsZero() || !upper.IsZero() {
+		activity, err := time.Parse(upstreamTimestampLayout, c.LastActivity)
+		if err != nil || (!lower.IsZero() && activity.Before(lower)) || (!upper.IsZero() && !activity.Before(upper)) {
+			return false
+		}
+	}
+	return true
+}
+
+func inRange(v float64, min, max *float64) bool {
+	return (min == nil || v >= *min) && (max == nil || v <= *max)
+}
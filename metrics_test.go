@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// scrapeMetric returns the value of the series named exactly series in the
+// text exposition served at /metrics, or 0 if it is absent.
+func scrapeMetric(t *testing.T, r http.Handler, series string) float64 {
+	t.Helper()
+
+	w := serve(r, http.MethodGet, "/metrics", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /metrics: status = %d, want 200", w.Code)
+	}
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), " ")
+		if !ok || name != series {
+			continue
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			t.Fatalf("%s: parse value %q: %v", series, value, err)
+		}
+		return v
+	}
+	return 0
+}
+
+func TestMetricsCountRequests(t *testing.T) {
+	r := newTestRouter(t, Config{}, PrometheusMetrics())
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	const route = "/api/v1/statistics/timeseries"
+	counter := `traffy_http_requests_total{route="` + route + `",status="400"}`
+	latency := `traffy_http_request_duration_seconds_count{route="` + route + `"}`
+	unmatched := `traffy_http_requests_total{route="unmatched",status="404"}`
+
+	before := []float64{scrapeMetric(t, r, counter), scrapeMetric(t, r, latency), scrapeMetric(t, r, unmatched)}
+
+	serve(r, http.MethodGet, route+"?bucket=year", "")
+	serve(r, http.MethodGet, route+"?bucket=hour", "")
+	serve(r, http.MethodGet, "/api/v1/no-such-route", "")
+
+	after := []float64{scrapeMetric(t, r, counter), scrapeMetric(t, r, latency), scrapeMetric(t, r, unmatched)}
+	for i, series := range []string{counter, latency, unmatched} {
+		want := before[i] + 2
+		if series == unmatched {
+			want = before[i] + 1
+		}
+		if after[i] != want || after[i] == 0 {
+			t.Errorf("%s = %v, want %v", series, after[i], want)
+		}
+	}
+}
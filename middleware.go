@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/time/rate"
+)
+
+const (
+	requestIDHeader = "X-Request-ID"
+	requestIDKey    = "request_id"
+
+	maxRequestIDLength = 128
+)
+
+// RequestID tags each request with the caller's X-Request-ID, or a new UUID
+// when none (or an unusable one) is sent, and echoes it in the response.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !isValidRequestID(id) {
+			id = uuid.NewString()
+		}
+
+		c.Set(requestIDKey, id)
+		c.Header(requestIDHeader, id)
+
+		c.Next()
+	}
+}
+
+// isValidRequestID accepts short, printable ASCII IDs so a caller can't
+// inject line breaks or huge values into the logs.
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestLogger writes one access log line per request.
+func RequestLogger(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		attrs := []any{
+			"request_id", c.GetString(requestIDKey),
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"latency_ms", time.Since(start).Milliseconds(),
+			"client_ip", c.ClientIP(),
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "error", c.Errors.String())
+		}
+
+		logger.Info("request", attrs...)
+	}
+}
+
+// requestLog returns the default logger tagged with the request's ID, for
+// log lines written from inside handlers.
+func requestLog(c *gin.Context) *slog.Logger {
+	return slog.With("request_id", c.GetString(requestIDKey))
+}
+
+// CORSMiddleware lets browsers on allowedOrigins call the API. A "*" entry
+// allows any origin. Preflight OPTIONS requests are answered with 204
+// without reaching the route handlers.
+func CORSMiddleware(allowedOrigins []string) gin.HandlerFunc {
+	allowed := make(map[string]bool, len(allowedOrigins))
+	for _, origin := range allowedOrigins {
+		allowed[origin] = true
+	}
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin != "" && (allowed["*"] || allowed[origin]) {
+			c.Header("Access-Control-Allow-Origin", origin)
+			c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			c.Header("Access-Control-Allow-Headers", "Authorization, Content-Type, "+requestIDHeader)
+			c.Header("Access-Control-Expose-Headers", requestIDHeader)
+			c.Header("Access-Control-Max-Age", "600")
+		}
+		c.Writer.Header().Add("Vary", "Origin")
+
+		if c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != "" {
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// splitList parses a comma-separated setting, dropping blank entries.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// RateLimiter allows at most rps requests per second, with bursts of up to
+// burst, through the routes it guards and answers the rest with 429. Each
+// call builds its own bucket, so applying it per route limits that route
+// across all clients.
+func RateLimiter(rps float64, burst int) gin.HandlerFunc {
+	limiter := rate.NewLimiter(rate.Limit(rps), burst)
+
+	return func(c *gin.Context) {
+		if !limiter.Allow() {
+			c.Header("Retry-After", "1")
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests to the upstream API, try again shortly"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// Deprecated marks responses from a legacy route and links to the same path
+// under successorPrefix.
+func Deprecated(successorPrefix string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Deprecation", "true")
+		c.Header("Link", "<"+successorPrefix+c.Request.URL.Path+`>; rel="successor-version"`)
+		c.Next()
+	}
+}
+
+// BodyLimit caps request bodies at maxBytes. Reads past the limit fail with
+// *http.MaxBytesError, which respondBodyError turns into a 413.
+func BodyLimit(maxBytes int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Body != nil {
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+		}
+		c.Next()
+	}
+}
+
+// respondBodyError answers a failed body read with 413 if the body was over
+// the BodyLimit, and with 400 and message otherwise.
+func respondBodyError(c *gin.Context, err error, message string) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body too large", "limit_bytes": tooLarge.Limit})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": message, "details": err.Error()})
+}
+
+// RequireJSON rejects requests whose body is not declared as JSON with 415.
+// It belongs only on routes that bind a JSON body.
+func RequireJSON() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !strings.HasPrefix(c.Request.Header.Get("Content-Type"), "application/json") {
+			c.AbortWithStatusJSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be application/json"})
+			return
+		}
+		c.Next()
+	}
+}
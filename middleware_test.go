@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func TestRequestID(t *testing.T) {
+	r := gin.New()
+	r.Use(RequestID())
+	r.GET("/", func(c *gin.Context) { c.String(http.StatusOK, c.GetString(requestIDKey)) })
+
+	w := serve(r, http.MethodGet, "/", "", requestIDHeader, "abc-123")
+	if got := w.Header().Get(requestIDHeader); got != "abc-123" {
+		t.Errorf("echoed %s = %q, want abc-123", requestIDHeader, got)
+	}
+	if w.Body.String() != "abc-123" {
+		t.Errorf("handler saw request ID %q, want abc-123", w.Body)
+	}
+
+	for _, sent := range []string{"", "bad id\r\nX-Injected: 1", strings.Repeat("a", maxRequestIDLength+1)} {
+		w := serve(r, http.MethodGet, "/", "", requestIDHeader, sent)
+		got := w.Header().Get(requestIDHeader)
+		if _, err := uuid.Parse(got); err != nil {
+			t.Errorf("sent %q: %s = %q, want a generated UUID", sent, requestIDHeader, got)
+		}
+		if w.Body.String() != got {
+			t.Errorf("sent %q: handler saw request ID %q, response carries %q", sent, w.Body, got)
+		}
+	}
+
+	first := serve(r, http.MethodGet, "/", "").Header().Get(requestIDHeader)
+	second := serve(r, http.MethodGet, "/", "").Header().Get(requestIDHeader)
+	if first == second {
+		t.Errorf("two requests without an ID both got %q", first)
+	}
+}
+
+func TestRequestLogger(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&out, nil))
+
+	r := gin.New()
+	r.Use(RequestID(), RequestLogger(logger))
+	r.GET("/complaints/:id", func(c *gin.Context) { c.Status(http.StatusTeapot) })
+
+	serve(r, http.MethodGet, "/complaints/42?state=finish", "", requestIDHeader, "log-me")
+
+	var entry map[string]any
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("decode log line %q: %v", out.String(), err)
+	}
+	want := map[string]any{
+		"msg":        "request",
+		"request_id": "log-me",
+		"method":     http.MethodGet,
+		"path":       "/complaints/42",
+		"status":     float64(http.StatusTeapot),
+	}
+	for key, value := range want {
+		if entry[key] != value {
+			t.Errorf("%s = %v, want %v", key, entry[key], value)
+		}
+	}
+	if _, ok := entry["latency_ms"].(float64); !ok {
+		t.Errorf("latency_ms = %v, want a number", entry["latency_ms"])
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	r := gin.New()
+	r.Use(CORSMiddleware([]string{"https://allowed.example"}))
+	handled := false
+	r.POST("/", func(c *gin.Context) { handled = true })
+	r.OPTIONS("/", func(c *gin.Context) { handled = true })
+
+	preflight := func(origin string) *http.Response {
+		return serve(r, http.MethodOptions, "/", "",
+			"Origin", origin, "Access-Control-Request-Method", http.MethodPost).Result()
+	}
+
+	resp := preflight("https://allowed.example")
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("whitelisted origin: status = %d, want 204", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "https://allowed.example" {
+		t.Errorf("whitelisted origin: Access-Control-Allow-Origin = %q", got)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Methods"); !strings.Contains(got, http.MethodPost) {
+		t.Errorf("whitelisted origin: Access-Control-Allow-Methods = %q, want POST", got)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Headers"); !strings.Contains(got, "Authorization") {
+		t.Errorf("whitelisted origin: Access-Control-Allow-Headers = %q, want Authorization", got)
+	}
+
+	resp = preflight("https://evil.example")
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("non-listed origin: Access-Control-Allow-Origin = %q, want none", got)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Methods"); got != "" {
+		t.Errorf("non-listed origin: Access-Control-Allow-Methods = %q, want none", got)
+	}
+	if got := resp.Header.Get("Vary"); got != "Origin" {
+		t.Errorf("Vary = %q, want Origin", got)
+	}
+
+	if handled {
+		t.Error("a preflight request reached the route handler")
+	}
+}
+
+func TestCORSWildcardAndSimpleRequest(t *testing.T) {
+	r := gin.New()
+	r.Use(CORSMiddleware([]string{"*"}))
+	r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	w := serve(r, http.MethodGet, "/", "", "Origin", "https://any.example")
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200", w.Code)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://any.example" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want the request origin", got)
+	}
+	if got := w.Header().Get("Access-Control-Expose-Headers"); got != requestIDHeader {
+		t.Errorf("Access-Control-Expose-Headers = %q, want %s", got, requestIDHeader)
+	}
+}
+
+func TestBodyLimit(t *testing.T) {
+	useStore(t)
+
+	// An empty collection padded with spaces is still valid JSON, so a body
+	// within the limit gets past the read and fails validation instead. The
+	// padding goes before the closing brace so the decoder has to read it.
+	const limit = 64
+	padded := func(n int) string {
+		doc := `{"type":"FeatureCollection","features":[]`
+		return doc + strings.Repeat(" ", n-len(doc)-1) + "}"
+	}
+	r := newTestRouter(t, Config{JWTSecret: testJWTSecret}, BodyLimit(limit))
+
+	w := serve(r, http.MethodPost, "/api/v1/complaints/import/json", padded(limit),
+		"Content-Type", "application/json", "Authorization", bearer(t))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("JSON body at the limit: status = %d, want 400: %s", w.Code, w.Body)
+	}
+
+	w = serve(r, http.MethodPost, "/api/v1/complaints/import/json", padded(limit+1),
+		"Content-Type", "application/json", "Authorization", bearer(t))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("JSON body one byte over: status = %d, want 413: %s", w.Code, w.Body)
+	}
+	var got struct {
+		LimitBytes int64 `json:"limit_bytes"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if got.LimitBytes != limit {
+		t.Errorf("limit_bytes = %d, want %d", got.LimitBytes, limit)
+	}
+}
+
+func TestBodyLimitMultipart(t *testing.T) {
+	useStore(t)
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("file", "complaints.csv")
+	part.Write([]byte("ticket_id,state\nC1,เสร็จสิ้น\n"))
+	form.Close()
+	size := int64(body.Len())
+
+	for _, tc := range []struct {
+		limit int64
+		want  int
+	}{
+		{size, http.StatusOK},
+		{size - 1, http.StatusRequestEntityTooLarge},
+	} {
+		r := newTestRouter(t, Config{JWTSecret: testJWTSecret}, BodyLimit(tc.limit))
+		w := serve(r, http.MethodPost, "/api/v1/import/csv", body.String(),
+			"Content-Type", form.FormDataContentType(), "Authorization", bearer(t))
+		if w.Code != tc.want {
+			t.Errorf("%d-byte upload with a %d-byte limit: status = %d, want %d: %s", size, tc.limit, w.Code, tc.want, w.Body)
+		}
+	}
+}
+
+func TestRequireJSON(t *testing.T) {
+	useStore(t)
+	r := newTestRouter(t, Config{JWTSecret: testJWTSecret})
+	body := `{"type":"FeatureCollection","features":[]}`
+
+	for _, contentType := range []string{"text/plain", ""} {
+		w := serve(r, http.MethodPost, "/api/v1/complaints/import/json", body,
+			"Content-Type", contentType, "Authorization", bearer(t))
+		if w.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("Content-Type %q: status = %d, want 415: %s", contentType, w.Code, w.Body)
+		}
+	}
+
+	w := serve(r, http.MethodPost, "/api/v1/complaints/import/json", body,
+		"Content-Type", "application/json; charset=utf-8", "Authorization", bearer(t))
+	if w.Code == http.StatusUnsupportedMediaType {
+		t.Errorf("application/json with a charset was rejected with 415")
+	}
+}
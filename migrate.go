@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// migrations[i] upgrades a stored document from schema version i to i+1.
+// Documents written before versioning was introduced count as version 0.
+// The save paths stamp currentSchemaVersion, so only older documents are
+// rewritten by a reindex.
+var migrations = []func(doc bson.M) bson.M{
+	// 0 -> 1: normalize problem types saved before normalizeProblemType
+	// existed and add the geometry field that flat complaints now carry.
+	func(doc bson.M) bson.M {
+		if props, ok := asDocument(doc["properties"]); ok {
+			normalizeStoredProblemTypes(props)
+			doc["properties"] = props
+			return doc
+		}
+
+		if _, ok := doc["geometry"]; !ok {
+			if coords, ok := doc["coords"].(string); ok {
+				if point, err := (Complaint{Coords: coords}).ToGeoJSONPoint(); err == nil {
+					doc["geometry"] = point
+				}
+			}
+		}
+		return doc
+	},
+	// 1 -> 2: rename fields saved under the driver's default names (the
+	// lowercased Go field name) before the structs had bson tags, then
+	// normalize the problem types step 0 -> 1 missed under the old name.
+	func(doc bson.M) bson.M {
+		if props, ok := asDocument(doc["properties"]); ok {
+			renameFields(doc, legacyFeatureFields)
+			renameFields(props, legacyPropertiesFields)
+			normalizeStoredProblemTypes(props)
+			doc["properties"] = props
+			return doc
+		}
+
+		renameFields(doc, legacyComplaintFields)
+		return doc
+	},
+}
+
+// Field names the driver used before the structs had bson tags, mapped to
+// the tagged names. Fields whose lowercased name already matched are left
+// out.
+var (
+	legacyFeatureFields = map[string]string{
+		"createdat": "created_at",
+	}
+	legacyPropertiesFields = map[string]string{
+		"problemtypefondue":   "problem_type_fondue",
+		"ticketid":            "ticket_id",
+		"photourl":            "photo_url",
+		"afterphoto":          "after_photo",
+		"problemtypeabdul":    "problem_type_abdul",
+		"countreopen":         "count_reopen",
+		"descriptionreporter": "description_reporter",
+		"statetypelatest":     "state_type_latest",
+		"lastactivity":        "last_activity",
+		"seeinfo":             "see_info",
+	}
+	legacyComplaintFields = map[string]string{
+		"countreopen":        "count_reopen",
+		"lastactivity":       "last_activity",
+		"organizationaction": "organization_action",
+		"photoafter":         "photo_after",
+		"ticketid":           "ticket_id",
+	}
+)
+
+// renameFields moves each legacy key in doc to its current name. A value
+// already stored under the current name wins over the legacy one.
+func renameFields(doc bson.M, names map[string]string) {
+	for legacy, current := range names {
+		v, ok := doc[legacy]
+		if !ok {
+			continue
+		}
+		if _, exists := doc[current]; !exists {
+			doc[current] = v
+		}
+		delete(doc, legacy)
+	}
+}
+
+// normalizeStoredProblemTypes runs normalizeProblemTypes over a stored
+// properties document's problem types.
+func normalizeStoredProblemTypes(props bson.M) {
+	raw, ok := props["problem_type_fondue"].(bson.A)
+	if !ok {
+		return
+	}
+	types := make([]string, 0, len(raw))
+	for _, v := range raw {
+		if t, ok := v.(string); ok {
+			types = append(types, t)
+		}
+	}
+	props["problem_type_fondue"] = normalizeProblemTypes(types)
+}
+
+// currentSchemaVersion is the version every document has after a reindex.
+var currentSchemaVersion = len(migrations)
+
+// MigrateDocument applies every migration newer than the document's
+// schema_version and stamps it with currentSchemaVersion.
+func MigrateDocument(doc bson.M) bson.M {
+	for v := schemaVersionOf(doc); v < currentSchemaVersion; v++ {
+		doc = migrations[v](doc)
+	}
+	doc["schema_version"] = int32(currentSchemaVersion)
+	return doc
+}
+
+// schemaVersionOf reads a document's schema_version, which is an int32 when
+// written by this package but may be any numeric type if set elsewhere.
+// A missing version counts as 0.
+func schemaVersionOf(doc bson.M) int {
+	switch v := doc["schema_version"].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int:
+		return v
+	case float64:
+		return int(v)
+	}
+	return 0
+}
+
+// asDocument returns v as a bson.M if it holds an embedded document.
+func asDocument(v interface{}) (bson.M, bool) {
+	switch d := v.(type) {
+	case bson.M:
+		return d, true
+	case bson.D:
+		m := make(bson.M, len(d))
+		for _, e := range d {
+			m[e.Key] = e.Value
+		}
+		return m, true
+	}
+	return nil, false
+}
+
+const reindexBatchSize = 500
+
+type ReindexProgress struct {
+	Processed int  `json:"processed"`
+	Modified  int  `json:"modified"`
+	Done      bool `json:"done"`
+}
+
+// reindexDocuments rewrites every document older than currentSchemaVersion
+// through MigrateDocument, calling progress after each batch.
+func reindexDocuments(ctx context.Context, progress func(ReindexProgress) error) (ReindexProgress, error) {
+	var status ReindexProgress
+
+	filter := bson.M{"schema_version": bson.M{"$not": bson.M{"$gte": currentSchemaVersion}}}
+	cursor, err := postsCollection.Find(ctx, filter, options.Find().SetBatchSize(reindexBatchSize))
+	if err != nil {
+		return status, err
+	}
+	defer cursor.Close(ctx)
+
+	models := make([]mongo.WriteModel, 0, reindexBatchSize)
+	flush := func() error {
+		if len(models) == 0 {
+			return nil
+		}
+		// The cursor stays open for the whole reindex; only each batch's
+		// write is bounded.
+		writeCtx, cancel := withMongoTimeout(ctx, mongoOpTimeout)
+		defer cancel()
+
+		res, err := postsCollection.BulkWrite(writeCtx, models, options.BulkWrite().SetOrdered(false))
+		if err != nil {
+			return err
+		}
+		status.Processed += len(models)
+		status.Modified += int(res.ModifiedCount)
+		models = models[:0]
+		return progress(status)
+	}
+
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return status, err
+		}
+
+		models = append(models, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"_id": doc["_id"]}).
+			SetReplacement(MigrateDocument(doc)))
+
+		if len(models) == reindexBatchSize {
+			if err := flush(); err != nil {
+				return status, err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return status, err
+	}
+	if err := flush(); err != nil {
+		return status, err
+	}
+	recordAudit(ctx, auditUpdate, "", int64(status.Modified))
+
+	status.Done = true
+	return status, nil
+}
+
+// backfillSchema sets schemaField on every document that lacks it, telling
+// the schemas apart by whether a document has properties. It returns how
+// many documents of each schema were updated.
+func backfillSchema(ctx context.Context) (features, complaints int64, err error) {
+	missing := bson.M{"$exists": false}
+
+	res, err := postsCollection.UpdateMany(ctx,
+		bson.M{schemaField: missing, "properties": bson.M{"$exists": true}},
+		bson.M{"$set": bson.M{schemaField: schemaFeature}})
+	if err != nil {
+		return 0, 0, err
+	}
+	features = res.ModifiedCount
+
+	res, err = postsCollection.UpdateMany(ctx,
+		bson.M{schemaField: missing, "properties": missing},
+		bson.M{"$set": bson.M{schemaField: schemaComplaint}})
+	if err != nil {
+		recordAudit(ctx, auditUpdate, "", features)
+		return features, 0, err
+	}
+	complaints = res.ModifiedCount
+
+	recordAudit(ctx, auditUpdate, "", features+complaints)
+	return features, complaints, nil
+}
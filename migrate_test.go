@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMigrateDocumentVersionTypes(t *testing.T) {
+	for _, version := range []interface{}{int32(currentSchemaVersion), int64(currentSchemaVersion), currentSchemaVersion, float64(currentSchemaVersion)} {
+		doc := bson.M{
+			"schema_version": version,
+			"properties":     bson.M{"problem_type_fondue": bson.A{"ถนน "}},
+		}
+		got := MigrateDocument(doc)
+
+		// A current document is left alone, so the untrimmed type survives.
+		types, ok := got["properties"].(bson.M)["problem_type_fondue"].(bson.A)
+		if !ok || types[0] != "ถนน " {
+			t.Errorf("version %T: document was migrated again", version)
+		}
+		if got["schema_version"] != int32(currentSchemaVersion) {
+			t.Errorf("version %T: stamped %#v, want int32(%d)", version, got["schema_version"], currentSchemaVersion)
+		}
+	}
+}
+
+func TestMigrateDocumentUnversioned(t *testing.T) {
+	doc := MigrateDocument(bson.M{"ticket_id": "T1", "coords": "100.5,13.7"})
+
+	if doc["schema_version"] != int32(currentSchemaVersion) {
+		t.Errorf("schema_version = %#v, want int32(%d)", doc["schema_version"], currentSchemaVersion)
+	}
+	if _, ok := doc["geometry"]; !ok {
+		t.Error("complaint was not given a geometry")
+	}
+}
+
+func TestMigrateDocumentRenamesLegacyFields(t *testing.T) {
+	created := "2023-05-01T00:00:00Z"
+	for _, version := range []interface{}{nil, int32(1)} {
+		doc := bson.M{
+			"type":      "Feature",
+			"createdat": created,
+			"properties": bson.M{
+				"ticketid":          "T1",
+				"problemtypefondue": bson.A{"ถนน"},
+				"countreopen":       int32(2),
+				"state":             "เสร็จสิ้น",
+			},
+		}
+		if version != nil {
+			doc["schema_version"] = version
+		}
+
+		got := MigrateDocument(doc)
+		props := got["properties"].(bson.M)
+		if props["ticket_id"] != "T1" || props["count_reopen"] != int32(2) || props["state"] != "เสร็จสิ้น" {
+			t.Errorf("version %v: properties = %v, want snake_case names", version, props)
+		}
+		if _, ok := props["problem_type_fondue"]; !ok {
+			t.Errorf("version %v: problem types were not renamed", version)
+		}
+		for _, legacy := range []string{"ticketid", "problemtypefondue", "countreopen"} {
+			if _, ok := props[legacy]; ok {
+				t.Errorf("version %v: legacy field %q was kept", version, legacy)
+			}
+		}
+		if got["created_at"] != created || got["createdat"] != nil {
+			t.Errorf("version %v: created_at = %v, createdat = %v", version, got["created_at"], got["createdat"])
+		}
+	}
+}
+
+func TestMigrateDocumentRenamesLegacyComplaintFields(t *testing.T) {
+	got := MigrateDocument(bson.M{
+		"ticketid":     "T2",
+		"ticket_id":    "T2-current",
+		"lastactivity": "2023-05-01",
+		"photoafter":   "https://example.com/after.jpg",
+	})
+
+	if got["ticket_id"] != "T2-current" {
+		t.Errorf("ticket_id = %v, want the current value to win", got["ticket_id"])
+	}
+	if got["last_activity"] != "2023-05-01" || got["photo_after"] != "https://example.com/after.jpg" {
+		t.Errorf("complaint = %v, want snake_case names", got)
+	}
+	for _, legacy := range []string{"ticketid", "lastactivity", "photoafter"} {
+		if _, ok := got[legacy]; ok {
+			t.Errorf("legacy field %q was kept", legacy)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pageCursor is the position an opaque ?cursor= value encodes. Clients
+// should treat the encoded form as opaque.
+type pageCursor struct {
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+}
+
+func encodeCursor(offset, limit int) string {
+	raw, _ := json.Marshal(pageCursor{Offset: offset, Limit: limit})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(s string) (pageCursor, error) {
+	var cursor pageCursor
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(raw, &cursor)
+	return cursor, err
+}
+
+// parsePageCursor decodes cursor into offset and limit, replacing the
+// values read from the offset and limit parameters. It writes a 400
+// response and returns false if the cursor is malformed or out of range.
+func parsePageCursor(c *gin.Context, cursor string, offset, limit *int) bool {
+	decoded, err := decodeCursor(cursor)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor", "details": err.Error()})
+		return false
+	}
+	if !validatePaging(c, decoded.Offset, decoded.Limit) {
+		return false
+	}
+	*offset, *limit = decoded.Offset, decoded.Limit
+	return true
+}
+
+// newPageMeta describes a page of limit results starting at offset out of
+// total. next_offset is -1 and cursor is empty on the last page.
+func newPageMeta(total, offset, limit int, hasMore bool) PageMeta {
+	meta := PageMeta{
+		Total:      total,
+		Offset:     offset,
+		Limit:      limit,
+		HasMore:    hasMore,
+		TotalPages: (total + limit - 1) / limit,
+		NextOffset: -1,
+	}
+	if hasMore {
+		meta.NextOffset = offset + limit
+		meta.Cursor = encodeCursor(meta.NextOffset, limit)
+	}
+	return meta
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestNewPageMeta(t *testing.T) {
+	tests := []struct {
+		name                 string
+		total, offset, limit int
+		hasMore              bool
+		wantPages, wantNext  int
+	}{
+		{"empty result", 0, 0, 10, false, 0, -1},
+		{"single partial page", 3, 0, 10, false, 1, -1},
+		{"first of several", 25, 0, 10, true, 3, 10},
+		{"middle page", 25, 10, 10, true, 3, 20},
+		{"partial last page", 25, 20, 10, false, 3, -1},
+		{"exactly full last page", 20, 10, 10, false, 2, -1},
+		{"offset past the end", 5, 10, 10, false, 1, -1},
+		{"limit of one", 2, 0, 1, true, 2, 1},
+	}
+	for _, tc := range tests {
+		meta := newPageMeta(tc.total, tc.offset, tc.limit, tc.hasMore)
+		if meta.TotalPages != tc.wantPages || meta.NextOffset != tc.wantNext {
+			t.Errorf("%s: total_pages %d, next_offset %d, want %d and %d", tc.name, meta.TotalPages, meta.NextOffset, tc.wantPages, tc.wantNext)
+		}
+		if !tc.hasMore {
+			if meta.Cursor != "" {
+				t.Errorf("%s: cursor %q on the last page, want none", tc.name, meta.Cursor)
+			}
+			continue
+		}
+		cursor, err := decodeCursor(meta.Cursor)
+		if err != nil || cursor != (pageCursor{Offset: tc.wantNext, Limit: tc.limit}) {
+			t.Errorf("%s: cursor decodes to %+v, %v, want offset %d limit %d", tc.name, cursor, err, tc.wantNext, tc.limit)
+		}
+	}
+}
+
+func TestParsePageCursor(t *testing.T) {
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	tests := []struct {
+		cursor     string
+		wantOffset int
+		wantLimit  int
+		wantOK     bool
+	}{
+		{encodeCursor(40, 20), 40, 20, true},
+		{encodeCursor(0, maxLimit), 0, maxLimit, true},
+		{"not base64!", 0, 0, false},
+		{encode("not json"), 0, 0, false},
+		{encodeCursor(-1, 20), 0, 0, false},
+		{encodeCursor(0, 0), 0, 0, false},
+		{encodeCursor(0, maxLimit+1), 0, 0, false},
+	}
+	for _, tc := range tests {
+		c, w := queryContext("")
+		offset, limit := 5, 5
+		ok := parsePageCursor(c, tc.cursor, &offset, &limit)
+		if ok != tc.wantOK {
+			t.Errorf("parsePageCursor(%q) = %v, want %v", tc.cursor, ok, tc.wantOK)
+			continue
+		}
+		if !ok {
+			if w.Code != http.StatusBadRequest || offset != 5 || limit != 5 {
+				t.Errorf("parsePageCursor(%q): status %d, offset %d, limit %d; want 400 and the old values kept", tc.cursor, w.Code, offset, limit)
+			}
+			continue
+		}
+		if offset != tc.wantOffset || limit != tc.wantLimit {
+			t.Errorf("parsePageCursor(%q) = offset %d limit %d, want %d and %d", tc.cursor, offset, limit, tc.wantOffset, tc.wantLimit)
+		}
+	}
+}
+
+func TestPaginationBoundariesThroughStore(t *testing.T) {
+	store := useStore(t)
+	r := newTestRouter(t, Config{})
+
+	w := serve(r, http.MethodGet, "/api/v1/complaints?offset=0&limit=2", "")
+	page, _ := decodePage(t, w)
+	if !strings.Contains(w.Body.String(), `"data":[]`) || page.Meta.TotalPages != 0 || page.Meta.NextOffset != -1 || page.Meta.HasMore {
+		t.Errorf("empty store: body %s, want empty data, 0 pages and next_offset -1", w.Body)
+	}
+
+	var features []Feature
+	for i := 0; i < 5; i++ {
+		features = append(features, testFeature(fmt.Sprintf("F%d", i)))
+	}
+	store.InsertFeatures(context.Background(), features)
+
+	var tickets []string
+	target := "/api/v1/complaints?offset=0&limit=2"
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatalf("still paging after %d pages", pages)
+		}
+		page, got := decodePage(t, serve(r, http.MethodGet, target, ""))
+		tickets = append(tickets, got...)
+		if page.Meta.TotalPages != 3 {
+			t.Errorf("%s: total_pages %d, want 3", target, page.Meta.TotalPages)
+		}
+		if !page.Meta.HasMore {
+			if page.Meta.NextOffset != -1 || page.Meta.Cursor != "" || len(got) != 1 {
+				t.Errorf("last page %+v with %v, want one ticket, next_offset -1 and no cursor", page.Meta, got)
+			}
+			break
+		}
+		target = "/api/v1/complaints?cursor=" + page.Meta.Cursor
+	}
+	if strings.Join(tickets, ",") != "F0,F1,F2,F3,F4" {
+		t.Errorf("paging by cursor returned %v, want every ticket once", tickets)
+	}
+
+	page, got := decodePage(t, serve(r, http.MethodGet, "/api/v1/complaints?offset=10&limit=2", ""))
+	if len(got) != 0 || page.Meta.Total != 5 || page.Meta.NextOffset != -1 {
+		t.Errorf("offset past the end: %v with %+v, want no data and next_offset -1", got, page.Meta)
+	}
+}
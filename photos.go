@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// maxPhotoChecks caps concurrent HEAD requests to photo hosts.
+	maxPhotoChecks    = 10
+	photoCheckTimeout = 10 * time.Second
+)
+
+var photoClient = &http.Client{Timeout: photoCheckTimeout}
+
+// PhotoSummary is how many distinct photo URLs validatePhotos checked and
+// how many of them could not be reached.
+type PhotoSummary struct {
+	TotalPhotos int `json:"total_photos"`
+	Unreachable int `json:"unreachable"`
+}
+
+// validatePhotos sends a HEAD request to every distinct photo and after
+// photo URL in features, at most maxPhotoChecks at a time, and sets each
+// feature's PhotoReachable to whether all of its photos answered. Features
+// without photos are left unmarked.
+func validatePhotos(ctx context.Context, features []Feature) PhotoSummary {
+	reachable := map[string]bool{}
+	for _, f := range features {
+		for _, url := range []string{f.Properties.PhotoURL, f.Properties.AfterPhoto} {
+			if url != "" {
+				reachable[url] = false
+			}
+		}
+	}
+
+	sem := make(chan struct{}, maxPhotoChecks)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for url := range reachable {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(url string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			ok := photoReachable(ctx, url)
+			mu.Lock()
+			reachable[url] = ok
+			mu.Unlock()
+		}(url)
+	}
+	wg.Wait()
+
+	summary := PhotoSummary{TotalPhotos: len(reachable)}
+	for _, ok := range reachable {
+		if !ok {
+			summary.Unreachable++
+		}
+	}
+
+	for i := range features {
+		checked, ok := false, true
+		for _, url := range []string{features[i].Properties.PhotoURL, features[i].Properties.AfterPhoto} {
+			if url != "" {
+				checked = true
+				ok = ok && reachable[url]
+			}
+		}
+		if checked {
+			features[i].PhotoReachable = &ok
+		}
+	}
+	return summary
+}
+
+// photoReachable reports whether a HEAD request to url succeeds. Some hosts
+// refuse HEAD outright; a 405 still shows the photo is being served.
+func photoReachable(ctx context.Context, url string) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return false
+	}
+
+	resp, err := photoClient.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode < http.StatusBadRequest || resp.StatusCode == http.StatusMethodNotAllowed
+}
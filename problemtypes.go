@@ -0,0 +1,105 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+)
+
+// canonicalProblemTypes maps the spellings seen in upstream data, keyed in
+// lower case, to the Thai category names the Traffy Fondue app itself uses.
+// Every canonical name maps to itself so normalizing twice is a no-op.
+var canonicalProblemTypes = map[string]string{
+	"ถนน":  "ถนน",
+	"road": "ถนน",
+
+	"ทางเท้า":  "ทางเท้า",
+	"sidewalk": "ทางเท้า",
+	"footpath": "ทางเท้า",
+
+	"ความสะอาด":   "ความสะอาด",
+	"cleanliness": "ความสะอาด",
+	"garbage":     "ความสะอาด",
+
+	"น้ำท่วม":  "น้ำท่วม",
+	"flood":    "น้ำท่วม",
+	"flooding": "น้ำท่วม",
+
+	"ท่อระบายน้ำ": "ท่อระบายน้ำ",
+	"drainage":    "ท่อระบายน้ำ",
+	"drain":       "ท่อระบายน้ำ",
+
+	"แสงสว่าง": "แสงสว่าง",
+	"lighting": "แสงสว่าง",
+	"light":    "แสงสว่าง",
+
+	"สายไฟ":         "สายไฟ",
+	"electric wire": "สายไฟ",
+	"cable":         "สายไฟ",
+
+	"จราจร":   "จราจร",
+	"traffic": "จราจร",
+
+	"ต้นไม้": "ต้นไม้",
+	"tree":   "ต้นไม้",
+	"trees":  "ต้นไม้",
+
+	"สะพาน":  "สะพาน",
+	"bridge": "สะพาน",
+
+	"คลอง":  "คลอง",
+	"canal": "คลอง",
+
+	"กีดขวาง":     "กีดขวาง",
+	"obstruction": "กีดขวาง",
+
+	"ความปลอดภัย": "ความปลอดภัย",
+	"safety":      "ความปลอดภัย",
+
+	"เสียงรบกวน": "เสียงรบกวน",
+	"noise":      "เสียงรบกวน",
+
+	"ป้าย": "ป้าย",
+	"sign": "ป้าย",
+
+	"คนจรจัด":  "คนจรจัด",
+	"homeless": "คนจรจัด",
+
+	"ห้องน้ำ":  "ห้องน้ำ",
+	"restroom": "ห้องน้ำ",
+	"toilet":   "ห้องน้ำ",
+
+	"pm2.5": "PM2.5",
+}
+
+// normalizeProblemType returns the canonical name for raw. Unknown values
+// are returned unchanged and logged so the table can be extended.
+func normalizeProblemType(raw string) string {
+	key := strings.ToLower(strings.TrimSpace(raw))
+	if canonical, ok := canonicalProblemTypes[key]; ok {
+		return canonical
+	}
+	if key != "" {
+		slog.Warn("Unknown problem type", "problem_type", raw)
+	}
+	return raw
+}
+
+// normalizeProblemTypes normalizes every entry, dropping duplicates that
+// differed only in spelling.
+func normalizeProblemTypes(raw []string) []string {
+	if raw == nil {
+		return nil
+	}
+
+	seen := make(map[string]bool, len(raw))
+	normalized := make([]string, 0, len(raw))
+	for _, r := range raw {
+		n := normalizeProblemType(r)
+		if seen[n] {
+			continue
+		}
+		seen[n] = true
+		normalized = append(normalized, n)
+	}
+	return normalized
+}
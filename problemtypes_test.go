@@ -0,0 +1,53 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNormalizeProblemType(t *testing.T) {
+	tests := []struct {
+		raw, want string
+	}{
+		{"ถนน", "ถนน"},
+		{"road", "ถนน"},
+		{"Road", "ถนน"},
+		{"  ROAD  ", "ถนน"},
+		{"Sidewalk", "ทางเท้า"},
+		{"footpath", "ทางเท้า"},
+		{"garbage", "ความสะอาด"},
+		{"Flooding", "น้ำท่วม"},
+		{"น้ำท่วม", "น้ำท่วม"},
+		{"drain", "ท่อระบายน้ำ"},
+		{"Light", "แสงสว่าง"},
+		{"Electric Wire", "สายไฟ"},
+		{"traffic", "จราจร"},
+		{"trees", "ต้นไม้"},
+		{"Bridge", "สะพาน"},
+		{"canal", "คลอง"},
+		{"obstruction", "กีดขวาง"},
+		{"noise", "เสียงรบกวน"},
+		{"toilet", "ห้องน้ำ"},
+		{"pm2.5", "PM2.5"},
+
+		{"pothole", "pothole"},
+		{"  Weird Thing ", "  Weird Thing "},
+		{"", ""},
+	}
+	for _, tc := range tests {
+		if got := normalizeProblemType(tc.raw); got != tc.want {
+			t.Errorf("normalizeProblemType(%q) = %q, want %q", tc.raw, got, tc.want)
+		}
+	}
+}
+
+func TestNormalizeProblemTypesDropsDuplicates(t *testing.T) {
+	got := normalizeProblemTypes([]string{"road", "ถนน", "Flood", "pothole", "ROAD"})
+	want := []string{"ถนน", "น้ำท่วม", "pothole"}
+	if !slices.Equal(got, want) {
+		t.Errorf("normalizeProblemTypes = %q, want %q", got, want)
+	}
+	if normalizeProblemTypes(nil) != nil {
+		t.Error("normalizeProblemTypes(nil) is not nil")
+	}
+}
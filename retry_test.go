@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFetchPageRetriesServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	upstream := pagedUpstream(3)
+	useUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= 2 {
+			http.Error(w, "try again", http.StatusInternalServerError)
+			return
+		}
+		upstream(w, r)
+	})
+	retryAttempts = 3
+
+	data, err := fetchPage(context.Background(), "", "", 0, 10, UpstreamFilter{})
+	if err != nil {
+		t.Fatalf("fetchPage: %v", err)
+	}
+	if len(data.Features) != 3 {
+		t.Errorf("got %d features, want 3", len(data.Features))
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("upstream called %d times, want 3", got)
+	}
+}
+
+func TestFetchPageGivesUpAfterAttempts(t *testing.T) {
+	var calls atomic.Int32
+	useUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "down", http.StatusBadGateway)
+	})
+	retryAttempts = 3
+
+	_, err := fetchPage(context.Background(), "", "", 0, 10, UpstreamFilter{})
+	if err == nil || !strings.Contains(err.Error(), "after 3 attempts") || !strings.Contains(err.Error(), "502") {
+		t.Errorf("err = %v, want the last 502 after 3 attempts", err)
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("upstream called %d times, want 3", got)
+	}
+}
+
+func TestFetchPageRetriesTimeouts(t *testing.T) {
+	var calls atomic.Int32
+	upstream := pagedUpstream(1)
+	useUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+			}
+			return
+		}
+		upstream(w, r)
+	})
+	retryAttempts = 2
+	httpClient = &http.Client{Timeout: 50 * time.Millisecond}
+
+	data, err := fetchPage(context.Background(), "", "", 0, 10, UpstreamFilter{})
+	if err != nil {
+		t.Fatalf("fetchPage: %v", err)
+	}
+	if len(data.Features) != 1 || calls.Load() != 2 {
+		t.Errorf("got %d features after %d calls, want 1 after a timed-out first attempt", len(data.Features), calls.Load())
+	}
+}
+
+func TestWithRetryStopsOnCanceledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	calls := 0
+	err := withRetry(5, time.Millisecond, func() error {
+		calls++
+		return ctx.Err()
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("withRetry = %v after %d calls, want one call for a canceled context", err, calls)
+	}
+}
+
+func TestFetchPageCanceledContext(t *testing.T) {
+	var calls atomic.Int32
+	useUpstream(t, func(w http.ResponseWriter, r *http.Request) { calls.Add(1) })
+	retryAttempts, retryBaseDelay = 5, time.Second
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	began := time.Now()
+	_, err := fetchPage(ctx, "", "", 0, 10, UpstreamFilter{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(began); elapsed >= retryBaseDelay {
+		t.Errorf("fetchPage took %v, want it to return without a retry delay", elapsed)
+	}
+	if got := calls.Load(); got != 0 {
+		t.Errorf("upstream called %d times, want none", got)
+	}
+}
+
+func TestHTTPClientTimesOutSlowUpstream(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	cfg := useUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+	cfg.HTTPTimeout = 50 * time.Millisecond
+	applyUpstreamConfig(cfg)
+
+	start := time.Now()
+	if _, err := fetchPage(context.Background(), "", "", 0, 10, UpstreamFilter{}); err == nil {
+		t.Error("fetchPage returned no error for an upstream slower than the timeout")
+	}
+	if _, err := fetchDataCSV(context.Background(), "", "", 0, 10, "", "", "", "", UpstreamFilter{}); err == nil {
+		t.Error("fetchDataCSV returned no error for an upstream slower than the timeout")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("requests took %v to give up, want about the 50ms timeout each", elapsed)
+	}
+}
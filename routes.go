@@ -0,0 +1,1563 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Dependencies shared by the route handlers. main fills them in before
+// registering any routes.
+var (
+	appConfig     Config
+	batchConfig   BatchConfig
+	syncJobs      = NewJobRegistry()
+	syncScheduler *Scheduler
+	backgroundCtx = context.Background()
+
+	// upstreamLimiters holds one token bucket per upstream-backed route, so
+	// a route registered under several prefixes still shares one limit.
+	upstreamLimiters = map[string]gin.HandlerFunc{}
+)
+
+// upstreamLimit returns the rate limiter for route, creating it on first use.
+func upstreamLimit(route string) gin.HandlerFunc {
+	if limiter, ok := upstreamLimiters[route]; ok {
+		return limiter
+	}
+	limiter := RateLimiter(appConfig.UpstreamRPS, appConfig.UpstreamBurst)
+	upstreamLimiters[route] = limiter
+	return limiter
+}
+
+// RegisterV1Routes registers every API endpoint on r.
+func RegisterV1Routes(r *gin.RouterGroup) {
+	cfg := appConfig
+	batch := batchConfig
+	jobs := syncJobs
+	scheduler := syncScheduler
+	ctx := backgroundCtx
+
+	requireAuth := JWTMiddleware(cfg.JWTSecret)
+	requireJSON := RequireJSON()
+	webhook := NewWebhookNotifier(cfg.WebhookURL)
+
+	r.POST("/auth/token", requireJSON, func(c *gin.Context) {
+		var creds Credentials
+		if err := c.ShouldBindJSON(&creds); err != nil {
+			respondBodyError(c, err, "Invalid request body")
+			return
+		}
+
+		if !checkCredentials(cfg, creds) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
+			return
+		}
+
+		token, expires, err := issueToken(cfg.JWTSecret, creds.Username, cfg.TokenTTL)
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to issue token", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"token": token, "expires_at": expires})
+	})
+
+	// POST /auth/refresh exchanges a valid token, or one expired less than
+	// JWTRefreshGrace ago, for a new one with a fresh expiry.
+	r.POST("/auth/refresh", func(c *gin.Context) {
+		raw, ok := bearerToken(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing bearer token"})
+			return
+		}
+
+		if cfg.JWTSecret == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication is not configured"})
+			return
+		}
+
+		claims, err := parseToken(cfg.JWTSecret, raw, jwt.WithLeeway(cfg.JWTRefreshGrace))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token", "details": err.Error()})
+			return
+		}
+
+		token, expires, err := issueToken(cfg.JWTSecret, claims.Subject, cfg.TokenTTL)
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to issue token", "details": err.Error()})
+			return
+		}
+
+		requestLog(c).Info("Token refreshed", "username", claims.Subject, "client_ip", c.ClientIP())
+		c.JSON(http.StatusOK, gin.H{"token": token, "expires_at": expires})
+	})
+
+	r.POST("/sync/range", requireAuth, requireJSON, upstreamLimit("/sync/range"), func(c *gin.Context) {
+		var body struct {
+			Start  string `json:"start"`
+			End    string `json:"end"`
+			Format string `json:"format"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			respondBodyError(c, err, "Invalid request body")
+			return
+		}
+
+		if _, err := parseDate(body.Start); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date format"})
+			return
+		}
+
+		if _, err := parseDate(body.End); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date format"})
+			return
+		}
+
+		if body.Format == "" {
+			body.Format = "json"
+		}
+		if body.Format != "json" && body.Format != "csv" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format, expected json or csv"})
+			return
+		}
+
+		job := jobs.Create(body.Start, body.End, body.Format)
+		jobs.Start(ctx, job)
+
+		c.JSON(http.StatusAccepted, gin.H{"job_id": job.ID})
+	})
+
+	r.GET("/sync/status/:jobID", func(c *gin.Context) {
+		job, ok := jobs.Get(c.Param("jobID"))
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, job)
+	})
+
+	r.GET("/sync/status", func(c *gin.Context) {
+		if scheduler == nil {
+			c.JSON(http.StatusOK, SyncStatus{})
+			return
+		}
+
+		c.JSON(http.StatusOK, scheduler.Status())
+	})
+
+	r.GET("/cache/stats", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"json": upstreamJSONCache.Stats(),
+			"csv":  upstreamCSVCache.Stats(),
+		})
+	})
+
+	r.GET("/health", func(c *gin.Context) {
+		status, healthy := checkHealth(c.Request.Context(), client)
+		if !healthy {
+			c.JSON(http.StatusServiceUnavailable, status)
+			return
+		}
+
+		c.JSON(http.StatusOK, status)
+	})
+
+	r.POST("/saveToMongoDBCSV", requireAuth, upstreamLimit("/saveToMongoDBCSV"), func(c *gin.Context) {
+		startDate := c.Query("start")
+		endDate := c.Query("end")
+		name := c.Query("name")
+		org := c.Query("org")
+		purpose := c.Query("purpose")
+		email := c.Query("email")
+
+		if _, err := parseDate(startDate); startDate != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date format"})
+			return
+		}
+
+		if _, err := parseDate(endDate); endDate != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date format"})
+			return
+		}
+
+		dryRun, ok := parseDryRun(c)
+		if !ok {
+			return
+		}
+
+		var filter UpstreamFilter
+		if !parseOutputType(c, &filter.OutputType) {
+			return
+		}
+
+		offset, limit, ok := parsePagingDefault(c, batch.CSVBatchSize)
+		if !ok {
+			return
+		}
+
+		var saved BulkResult
+		fetched := 0
+		ingestStart := time.Now()
+		skipped, err := fetchDataCSVWithPagination(c.Request.Context(), startDate, endDate, offset, limit, name, org, purpose, email, filter, func(complaints []Complaint) error {
+			fetched += len(complaints)
+			if dryRun {
+				return nil
+			}
+
+			page, err := complaintStore.InsertComplaints(c.Request.Context(), complaints)
+			saved.Add(page)
+			if err != nil {
+				return fmt.Errorf("failed to append data to MongoDB: %w", err)
+			}
+			return page.Err()
+		})
+		if skipped > 0 {
+			requestLog(c).Warn("Skipped malformed CSV rows", "skipped", skipped)
+		}
+		if err != nil {
+			requestLog(c).Error("Failed to ingest CSV data", "offset", offset, "limit", limit, "inserted", saved.Inserted, "failed", saved.Failed, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save data to MongoDB", "details": err.Error(), "inserted": saved.Inserted, "failed": saved.Failed})
+			return
+		}
+		requestLog(c).Info("Ingested CSV data", "count", fetched, "duration", time.Since(ingestStart))
+
+		if fetched == 0 {
+			c.JSON(http.StatusOK, gin.H{"status": "No data to insert into MongoDB"})
+			return
+		}
+
+		if dryRun {
+			c.JSON(http.StatusOK, gin.H{"status": "dry_run", "would_insert": fetched})
+			return
+		}
+
+		webhook.NotifyAsync(SyncEvent{Event: "sync_complete", Inserted: saved.Inserted, Start: startDate, End: endDate, Timestamp: time.Now()})
+
+		c.JSON(http.StatusOK, gin.H{"status": "Data successfully saved to MongoDB", "inserted": saved.Inserted, "failed": saved.Failed})
+	})
+
+	r.POST("/saveToMongoDB", requireAuth, upstreamLimit("/saveToMongoDB"), func(c *gin.Context) {
+		ctx := c.Request.Context()
+		startDate := c.Query("start")
+		endDate := c.Query("end")
+
+		dryRun, ok := parseDryRun(c)
+		if !ok {
+			return
+		}
+
+		validatePhotoURLs, ok := parseValidatePhotos(c)
+		if !ok {
+			return
+		}
+
+		var filter UpstreamFilter
+		if !parseOutputType(c, &filter.OutputType) {
+			return
+		}
+
+		offset, limit, ok := parsePagingDefault(c, batch.JSONBatchSize)
+		if !ok {
+			return
+		}
+
+		// Each page is saved by the worker that fetched it, so nothing
+		// beyond ingestWorkers pages is held in memory at once.
+		var mu sync.Mutex
+		var saved BulkResult
+		var photos *PhotoSummary
+		fetched := 0
+		ingestStart := time.Now()
+		total, err := fetchDataWithPagination(ctx, startDate, endDate, offset, limit, filter, func(data Data) error {
+			if dryRun {
+				mu.Lock()
+				fetched += len(data.Features)
+				mu.Unlock()
+				return nil
+			}
+
+			if validatePhotoURLs {
+				summary := validatePhotos(ctx, data.Features)
+				mu.Lock()
+				if photos == nil {
+					photos = &PhotoSummary{}
+				}
+				photos.TotalPhotos += summary.TotalPhotos
+				photos.Unreachable += summary.Unreachable
+				mu.Unlock()
+			}
+
+			page, err := complaintStore.InsertFeatures(ctx, data.Features)
+			mu.Lock()
+			fetched += len(data.Features)
+			saved.Add(page)
+			mu.Unlock()
+			if err != nil {
+				return fmt.Errorf("failed to append data to MongoDB: %w", err)
+			}
+			return page.Err()
+		})
+		if err != nil {
+			requestLog(c).Error("Failed to ingest data", "offset", offset, "limit", limit, "inserted", saved.Inserted, "failed", saved.Failed, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save data to MongoDB", "details": err.Error(), "inserted": saved.Inserted, "failed": saved.Failed})
+			return
+		}
+		requestLog(c).Info("Ingested JSON data", "total", total, "count", fetched, "duration", time.Since(ingestStart))
+
+		if total == 0 {
+			c.JSON(http.StatusOK, gin.H{"status": "No data to insert into MongoDB", "details": "upstream reported a total of 0 records"})
+			return
+		}
+
+		if dryRun {
+			c.JSON(http.StatusOK, gin.H{"status": "dry_run", "would_insert": fetched})
+			return
+		}
+
+		if photos != nil {
+			requestLog(c).Info("Validated photo URLs", "total", photos.TotalPhotos, "unreachable", photos.Unreachable)
+		}
+
+		webhook.NotifyAsync(SyncEvent{Event: "sync_complete", Inserted: saved.Inserted, Start: startDate, End: endDate, Timestamp: time.Now()})
+
+		response := gin.H{"status": "Data successfully saved to MongoDB", "inserted": saved.Inserted, "failed": saved.Failed}
+		if photos != nil {
+			response["photos"] = photos
+		}
+		c.JSON(http.StatusOK, response)
+	})
+
+	// GET /saveToMongoDB/stream ingests like /saveToMongoDB but saves page by
+	// page, reporting each batch as a server-sent event so clients can show
+	// progress. It is a GET because EventSource cannot send anything else.
+	// The stream ends with a "done" or "error" event.
+	r.GET("/saveToMongoDB/stream", requireAuth, upstreamLimit("/saveToMongoDB"), func(c *gin.Context) {
+		ctx := c.Request.Context()
+		startDate := c.Query("start")
+		endDate := c.Query("end")
+
+		if _, err := parseDate(startDate); startDate != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date format"})
+			return
+		}
+
+		if _, err := parseDate(endDate); endDate != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date format"})
+			return
+		}
+
+		var filter UpstreamFilter
+		if !parseOutputType(c, &filter.OutputType) {
+			return
+		}
+
+		type event struct {
+			name string
+			data interface{}
+		}
+		events := make(chan event)
+		send := func(e event) {
+			select {
+			case events <- e:
+			case <-ctx.Done():
+			}
+		}
+
+		// The handler may return before the goroutine when the client goes
+		// away, so it must not touch c.
+		log := requestLog(c)
+		go func() {
+			defer close(events)
+
+			status, err := ingestWithProgress(ctx, startDate, endDate, batch.JSONBatchSize, filter, func(p IngestProgress) {
+				send(event{"message", p})
+			})
+			if err != nil {
+				log.Error("Streamed ingestion failed", "batch", status.Batch, "error", err)
+				send(event{"error", gin.H{"error": "Failed to save data to MongoDB", "details": err.Error(), "total_so_far": status.TotalSoFar}})
+				return
+			}
+
+			log.Info("Streamed ingestion finished", "batches", status.Batch, "inserted", status.TotalSoFar)
+			webhook.NotifyAsync(SyncEvent{Event: "sync_complete", Inserted: status.TotalSoFar, Start: startDate, End: endDate, Timestamp: time.Now()})
+			send(event{"done", status})
+		}()
+
+		c.Stream(func(w io.Writer) bool {
+			e, ok := <-events
+			if !ok {
+				return false
+			}
+			c.SSEvent(e.name, e.data)
+			return true
+		})
+	})
+
+	r.POST("/saveToMongoDB/range", requireAuth, requireJSON, upstreamLimit("/saveToMongoDB/range"), func(c *gin.Context) {
+		var ranges []DateRange
+		if err := c.ShouldBindJSON(&ranges); err != nil {
+			respondBodyError(c, err, "Invalid request body")
+			return
+		}
+
+		if len(ranges) == 0 || len(ranges) > maxIngestRanges {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Expected between 1 and %d ranges", maxIngestRanges)})
+			return
+		}
+
+		for i, rng := range ranges {
+			start, err := parseDate(rng.Start)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date format", "index": i})
+				return
+			}
+			end, err := parseDate(rng.End)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date format", "index": i})
+				return
+			}
+			if start.After(end) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "start must not be after end", "index": i})
+				return
+			}
+		}
+
+		results := ingestRanges(c.Request.Context(), ranges)
+
+		c.JSON(http.StatusOK, results)
+	})
+
+	// GET / proxies the upstream JSON API. Optional state filter accepts
+	// finish, follow, forward, inprogress, irrelevant or start.
+	r.GET("/", upstreamLimit("/"), func(c *gin.Context) {
+		filter, ok := parseComplaintFilter(c)
+		if !ok {
+			return
+		}
+
+		english, ok := parseLang(c)
+		if !ok {
+			return
+		}
+
+		offset, limit, ok := parsePaging(c)
+		if !ok {
+			return
+		}
+
+		if err := fetchData(c.Request.Context(), filter.Start, filter.End, offset, limit, filter.upstream()); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch data"})
+			return
+		}
+
+		data := dataCache.Get()
+		if filter.BBox != nil {
+			data.Features = filterFeaturesByBBox(data.Features, *filter.BBox)
+			data.Count = len(data.Features)
+		}
+		if len(filter.Districts) > 0 {
+			data.Features = filterFeaturesByDistrict(data.Features, filter.Districts)
+			data.Count = len(data.Features)
+		}
+
+		if english {
+			data.Features = slices.Clone(data.Features)
+			romanizeFeatures(data.Features)
+		}
+
+		c.JSON(http.StatusOK, data)
+	})
+
+	// topojsonHandler proxies the upstream CSV API. /topojson returns the
+	// complaint records as plain JSON; /topojson/valid (or ?format=topojson)
+	// encodes them as a TopoJSON Topology. Optional state filter accepts
+	// finish, follow, forward, inprogress, irrelevant or start.
+	topojsonHandler := func(c *gin.Context) {
+		name := c.Query("name")
+		purpose := c.Query("purpose")
+		email := c.Query("email")
+
+		filter, ok := parseComplaintFilter(c)
+		if !ok {
+			return
+		}
+
+		english, ok := parseLang(c)
+		if !ok {
+			return
+		}
+
+		offset, limit, ok := parsePaging(c)
+		if !ok {
+			return
+		}
+
+		// The CSV API takes org beside the other filters.
+		upstream := filter.upstream()
+		upstream.Org = ""
+		csvData, err := fetchDataCSV(c.Request.Context(), filter.Start, filter.End, offset, limit, name, filter.Org, purpose, email, upstream)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch CSV data"})
+			return
+		}
+
+		Complaints, skipped, err := convertCSVToComplaints(strings.NewReader(csvData))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse CSV", "details": err.Error()})
+			return
+		}
+		if skipped > 0 {
+			requestLog(c).Warn("Skipped malformed CSV rows", "skipped", skipped)
+		}
+
+		if filter.BBox != nil {
+			Complaints = filterComplaintsByBBox(Complaints, *filter.BBox)
+		}
+		if len(filter.Districts) > 0 {
+			Complaints = filterComplaintsByDistrict(Complaints, filter.Districts)
+		}
+
+		if english {
+			romanizeComplaints(Complaints)
+		}
+
+		if strings.HasSuffix(c.FullPath(), "/topojson/valid") || c.Query("format") == "topojson" {
+			c.JSON(http.StatusOK, encodeTopology(Complaints))
+			return
+		}
+
+		c.JSON(http.StatusOK, Complaints)
+	}
+
+	r.GET("/topojson", upstreamLimit("/topojson"), topojsonHandler)
+	r.GET("/topojson/valid", upstreamLimit("/topojson/valid"), topojsonHandler)
+
+	// GET /complaints reads stored features from MongoDB. Optional state
+	// filter accepts finish, follow, forward, inprogress, irrelevant or start.
+	r.GET("/complaints", func(c *gin.Context) {
+		filter, ok := parseComplaintFilter(c)
+		if !ok {
+			return
+		}
+		cursor := c.Query("cursor")
+
+		english, ok := parseLang(c)
+		if !ok {
+			return
+		}
+
+		offset, limit, ok := parsePagingDefault(c, 100)
+		if !ok {
+			return
+		}
+
+		// An ObjectID is the keyset next_cursor; anything else is the opaque
+		// offset cursor.
+		if cursor != "" && !primitive.IsValidObjectID(cursor) {
+			if !parsePageCursor(c, cursor, &offset, &limit) {
+				return
+			}
+			cursor = ""
+		}
+
+		page, err := complaintStore.FindFeatures(c.Request.Context(), filter, offset, limit, cursor)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query MongoDB", "details": err.Error()})
+			return
+		}
+
+		if english {
+			romanizeFeatures(page.Data)
+		}
+
+		c.JSON(http.StatusOK, page)
+	})
+
+	if cfg.DebugMode {
+		// GET /debug/explain shows the query plan for the filter /complaints
+		// would build from the same parameters.
+		r.GET("/debug/explain", func(c *gin.Context) {
+			filter, ok := parseComplaintFilter(c)
+			if !ok {
+				return
+			}
+
+			_, limit, ok := parsePagingDefault(c, 100)
+			if !ok {
+				return
+			}
+
+			plan, err := explainComplaints(c.Request.Context(), filter, limit)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to explain query", "details": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, plan)
+		})
+	}
+
+	r.GET("/features/count", func(c *gin.Context) {
+		filter, ok := parseComplaintFilter(c)
+		if !ok {
+			return
+		}
+
+		count, source, err := countFeatures(c.Request.Context(), filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count features", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"count": count, "source": source})
+	})
+
+	r.GET("/complaints/search", func(c *gin.Context) {
+		q := strings.TrimSpace(c.Query("q"))
+		if utf8.RuneCountInString(q) < minSearchLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("q must be at least %d characters", minSearchLength)})
+			return
+		}
+
+		var filter ComplaintFilter
+		if !parseLastActivityRange(c, &filter) {
+			return
+		}
+
+		english, ok := parseLang(c)
+		if !ok {
+			return
+		}
+
+		offset, limit, ok := parsePagingDefault(c, 100)
+		if !ok {
+			return
+		}
+
+		if cursor := c.Query("cursor"); cursor != "" && !parsePageCursor(c, cursor, &offset, &limit) {
+			return
+		}
+
+		page, err := complaintStore.SearchFeatures(c.Request.Context(), q, filter, offset, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query MongoDB", "details": err.Error()})
+			return
+		}
+
+		if english {
+			romanizeFeatures(page.Data)
+		}
+
+		c.JSON(http.StatusOK, page)
+	})
+
+	r.GET("/complaints/nearby", func(c *gin.Context) {
+		lat, latErr := strconv.ParseFloat(strings.TrimSpace(c.Query("lat")), 64)
+		lng, lngErr := strconv.ParseFloat(strings.TrimSpace(c.Query("lng")), 64)
+		if latErr != nil || lat < -90 || lat > 90 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "lat must be a number between -90 and 90"})
+			return
+		}
+		if lngErr != nil || lng < -180 || lng > 180 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "lng must be a number between -180 and 180"})
+			return
+		}
+
+		radius, ok := parseIntParamDefault(c, "radius_m", 1000)
+		if !ok {
+			return
+		}
+		if radius <= 0 || radius > maxNearbyRadius {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("radius_m must be between 1 and %d", maxNearbyRadius)})
+			return
+		}
+
+		english, ok := parseLang(c)
+		if !ok {
+			return
+		}
+
+		limit, ok := parseIntParamDefault(c, "limit", 100)
+		if !ok {
+			return
+		}
+		if !validatePaging(c, 0, limit) {
+			return
+		}
+
+		features, err := complaintStore.FindNearby(c.Request.Context(), lng, lat, float64(radius), limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query MongoDB", "details": err.Error()})
+			return
+		}
+
+		if english {
+			romanizeFeatures(features)
+		}
+
+		c.JSON(http.StatusOK, FeatureCollection{Type: "FeatureCollection", Features: features})
+	})
+
+	r.GET("/complaints/stale", func(c *gin.Context) {
+		state := c.Query("state")
+		if !isValidState(state) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid state", "allowed": knownStates})
+			return
+		}
+
+		days, ok := parseIntParamDefault(c, "days", 30)
+		if !ok {
+			return
+		}
+		if days <= 0 || days > maxStaleDays {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("days must be between 1 and %d", maxStaleDays)})
+			return
+		}
+
+		english, ok := parseLang(c)
+		if !ok {
+			return
+		}
+
+		offset, limit, ok := parsePagingDefault(c, 100)
+		if !ok {
+			return
+		}
+
+		if cursor := c.Query("cursor"); cursor != "" && !parsePageCursor(c, cursor, &offset, &limit) {
+			return
+		}
+
+		threshold := time.Now().AddDate(0, 0, -days)
+		page, err := complaintStore.FindStale(c.Request.Context(), threshold, state, offset, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query MongoDB", "details": err.Error()})
+			return
+		}
+
+		if english {
+			romanizeFeatures(page.Data)
+		}
+
+		c.JSON(http.StatusOK, page)
+	})
+
+	r.GET("/complaints/heatmap", func(c *gin.Context) {
+		bbox, ok := parseBBoxParam(c)
+		if !ok {
+			return
+		}
+
+		gridSize := defaultHeatmapGridSize
+		if raw, ok := c.GetQuery("grid_size"); ok {
+			v, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+			if err != nil || !(v >= minHeatmapGridSize && v <= maxHeatmapGridSize) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("grid_size must be a number between %g and %g", minHeatmapGridSize, maxHeatmapGridSize)})
+				return
+			}
+			gridSize = v
+		}
+
+		cells, err := complaintStore.Heatmap(c.Request.Context(), bbox, gridSize)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query MongoDB", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, cells)
+	})
+
+	r.POST("/import/csv", requireAuth, func(c *gin.Context) {
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			respondBodyError(c, err, "Missing file upload")
+			return
+		}
+
+		file, err := fileHeader.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to open upload", "details": err.Error()})
+			return
+		}
+		defer file.Close()
+
+		reader, err := decodeCharset(file, c.Query("charset"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid charset", "details": err.Error()})
+			return
+		}
+
+		complaints, result, err := parseComplaintsCSV(reader)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse CSV", "details": err.Error()})
+			return
+		}
+
+		saved, err := complaintStore.InsertComplaints(c.Request.Context(), complaints)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to append data to MongoDB", "details": err.Error()})
+			return
+		}
+		result.Inserted = saved.Inserted
+		result.Skipped += saved.Failed
+		for _, err := range saved.Errors {
+			result.Errors = append(result.Errors, err.Error())
+		}
+
+		c.JSON(http.StatusOK, result)
+	})
+
+	r.POST("/complaints/import/json", requireAuth, requireJSON, func(c *gin.Context) {
+		var body FeatureCollection
+		if err := c.ShouldBindJSON(&body); err != nil {
+			respondBodyError(c, err, "Invalid request body")
+			return
+		}
+
+		if body.Type != "FeatureCollection" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "type must be FeatureCollection"})
+			return
+		}
+		if len(body.Features) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "features must not be empty"})
+			return
+		}
+		if len(body.Features) > maxImportFeatures {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("at most %d features can be imported per request", maxImportFeatures)})
+			return
+		}
+
+		features, result := validateImportFeatures(body.Features)
+		if len(features) == 0 {
+			c.JSON(http.StatusOK, result)
+			return
+		}
+
+		saved, err := complaintStore.InsertFeatures(c.Request.Context(), features)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to append data to MongoDB", "details": err.Error()})
+			return
+		}
+		if err := saved.Err(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save some features to MongoDB", "details": err.Error(), "inserted": saved.Inserted, "updated": saved.Updated, "failed": saved.Failed})
+			return
+		}
+		result.Inserted = saved.Inserted
+		result.Updated = saved.Updated
+
+		c.JSON(http.StatusOK, result)
+	})
+
+	r.PATCH("/complaints/bulk-state", requireAuth, requireJSON, func(c *gin.Context) {
+		var body struct {
+			TicketIDs    []string `json:"ticket_ids"`
+			State        string   `json:"state"`
+			LastActivity string   `json:"last_activity"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			respondBodyError(c, err, "Invalid request body")
+			return
+		}
+
+		if len(body.TicketIDs) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ticket_ids must not be empty"})
+			return
+		}
+
+		if len(body.TicketIDs) > maxBulkTicketIDs {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("at most %d ticket_ids per request", maxBulkTicketIDs)})
+			return
+		}
+
+		if body.State == "" || !isValidState(body.State) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid state", "allowed": knownStates})
+			return
+		}
+
+		update := ComplaintUpdate{State: &body.State}
+		if body.LastActivity != "" {
+			update.LastActivity = &body.LastActivity
+		}
+		if err := update.validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid update", "details": err.Error()})
+			return
+		}
+
+		matched, modified, err := complaintStore.UpdateMany(c.Request.Context(), body.TicketIDs, update)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update complaints", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"matched": matched, "modified": modified})
+	})
+
+	// POST /admin/reindex migrates stored documents to the current schema,
+	// streaming one JSON progress line per batch.
+	r.POST("/admin/reindex", requireAuth, func(c *gin.Context) {
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Status(http.StatusOK)
+
+		enc := json.NewEncoder(c.Writer)
+		progress := func(p ReindexProgress) error {
+			if err := enc.Encode(p); err != nil {
+				return err
+			}
+			c.Writer.Flush()
+			return nil
+		}
+
+		// Headers are already sent, so a failure is reported as the last line.
+		status, err := reindexDocuments(c.Request.Context(), progress)
+		if err != nil {
+			requestLog(c).Error("Reindex failed", "processed", status.Processed, "error", err)
+			_ = enc.Encode(gin.H{"error": "Reindex failed", "details": err.Error(), "processed": status.Processed})
+			return
+		}
+
+		requestLog(c).Info("Reindex finished", "processed", status.Processed, "modified", status.Modified)
+		_ = progress(status)
+	})
+
+	// GET /admin/audit lists recorded writes, newest first, optionally
+	// limited to an inclusive [start, end] range.
+	r.GET("/admin/audit", requireAuth, func(c *gin.Context) {
+		startDate := c.Query("start")
+		endDate := c.Query("end")
+
+		if _, err := parseDate(startDate); startDate != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date format"})
+			return
+		}
+
+		if _, err := parseDate(endDate); endDate != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date format"})
+			return
+		}
+
+		offset, limit, ok := parsePagingDefault(c, 100)
+		if !ok {
+			return
+		}
+
+		total, entries, err := findAuditEntries(c.Request.Context(), startDate, endDate, offset, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read audit log", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"entries": entries,
+			"meta":    newPageMeta(int(total), offset, limit, offset+len(entries) < int(total)),
+		})
+	})
+
+	// POST /admin/backfill-schema sets the schema discriminator on documents
+	// saved before it was stamped at insert time.
+	r.POST("/admin/backfill-schema", requireAuth, func(c *gin.Context) {
+		features, complaints, err := backfillSchema(c.Request.Context())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to backfill schema", "details": err.Error()})
+			return
+		}
+
+		requestLog(c).Info("Schema backfill finished", "features", features, "complaints", complaints)
+		c.JSON(http.StatusOK, gin.H{"features": features, "complaints": complaints})
+	})
+
+	r.GET("/complaints/duplicates", func(c *gin.Context) {
+		offset, limit, ok := parsePagingDefault(c, 100)
+		if !ok {
+			return
+		}
+
+		if cursor := c.Query("cursor"); cursor != "" && !parsePageCursor(c, cursor, &offset, &limit) {
+			return
+		}
+
+		total, items, err := complaintStore.FindDuplicates(c.Request.Context(), offset, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find duplicates", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"total_duplicates": total,
+			"items":            items,
+			"meta":             newPageMeta(total, offset, limit, offset+len(items) < total),
+		})
+	})
+
+	r.DELETE("/complaints/duplicates", requireAuth, func(c *gin.Context) {
+		deleted, err := complaintStore.DeleteDuplicates(c.Request.Context())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete duplicates", "details": err.Error()})
+			return
+		}
+
+		requestLog(c).Info("Deleted duplicate complaints", "deleted", deleted, "user", c.GetString("username"))
+		c.JSON(http.StatusOK, gin.H{"deleted": deleted})
+	})
+
+	r.GET("/complaints/:ticketID", func(c *gin.Context) {
+		ticketID := strings.TrimSpace(c.Param("ticketID"))
+		if ticketID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing ticket ID"})
+			return
+		}
+
+		complaint, err := complaintStore.FindOne(c.Request.Context(), ticketID)
+		if errors.Is(err, errTicketNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "ticket not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query MongoDB", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, complaint)
+	})
+
+	r.GET("/features/:ticketID/history", func(c *gin.Context) {
+		ticketID := strings.TrimSpace(c.Param("ticketID"))
+		if ticketID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing ticket ID"})
+			return
+		}
+
+		history, err := complaintStore.History(c.Request.Context(), ticketID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query MongoDB", "details": err.Error()})
+			return
+		}
+
+		if len(history) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "ticket not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, history)
+	})
+
+	r.PUT("/complaints/:ticketID", requireAuth, requireJSON, func(c *gin.Context) {
+		ticketID := strings.TrimSpace(c.Param("ticketID"))
+		if ticketID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing ticket ID"})
+			return
+		}
+
+		var update ComplaintUpdate
+		if err := c.ShouldBindJSON(&update); err != nil {
+			respondBodyError(c, err, "Invalid request body")
+			return
+		}
+
+		if err := update.validate(); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Invalid update", "details": err.Error()})
+			return
+		}
+
+		updated, err := complaintStore.UpdateOne(c.Request.Context(), ticketID, update)
+		if errors.Is(err, errTicketNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "ticket not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update complaint", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, updated)
+	})
+
+	// clearRangeHandler deletes everything stored for a date range so it can
+	// be re-ingested. Both bounds are required to avoid wiping the collection
+	// by accident.
+	clearRangeHandler := func(c *gin.Context) {
+		startDate := c.Query("start")
+		endDate := c.Query("end")
+
+		start, err := parseDate(startDate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date format"})
+			return
+		}
+
+		end, err := parseDate(endDate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date format"})
+			return
+		}
+
+		if start.After(end) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "start must not be after end"})
+			return
+		}
+
+		deleted, err := complaintStore.DeleteComplaints(c.Request.Context(), ComplaintFilter{Start: startDate, End: endDate})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete complaints", "details": err.Error()})
+			return
+		}
+
+		requestLog(c).Info("Cleared complaints", "start", startDate, "end", endDate, "deleted", deleted, "user", c.GetString("username"))
+		c.JSON(http.StatusOK, gin.H{"deleted": deleted})
+	}
+
+	r.DELETE("/complaints", requireAuth, clearRangeHandler)
+	r.DELETE("/saveToMongoDB/clear", requireAuth, clearRangeHandler)
+
+	r.DELETE("/complaints/:ticketID", requireAuth, func(c *gin.Context) {
+		ticketID := strings.TrimSpace(c.Param("ticketID"))
+		if ticketID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing ticket ID"})
+			return
+		}
+
+		err := complaintStore.DeleteOne(c.Request.Context(), ticketID)
+		if errors.Is(err, errTicketNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "ticket not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete complaint", "details": err.Error()})
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	})
+
+	sumStateHandler := func(c *gin.Context) {
+		startDate := c.Query("start")
+		endDate := c.Query("end")
+
+		if _, err := parseDate(startDate); startDate != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date format"})
+			return
+		}
+
+		if _, err := parseDate(endDate); endDate != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date format"})
+			return
+		}
+
+		sum, err := aggregateSumState(c.Request.Context(), startDate, endDate)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to aggregate states", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, sum)
+	}
+
+	r.GET("/sumstate", sumStateHandler)
+	r.GET("/statistics/by-state", sumStateHandler)
+
+	// exportCSVHandler streams matching documents of either schema as CSV
+	// straight from the MongoDB cursor.
+	exportCSVHandler := func(c *gin.Context) {
+		filter := ComplaintFilter{
+			Start: c.Query("start"),
+			End:   c.Query("end"),
+			State: c.Query("state"),
+		}
+
+		if _, err := parseDate(filter.Start); filter.Start != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date format"})
+			return
+		}
+
+		if _, err := parseDate(filter.End); filter.End != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date format"})
+			return
+		}
+
+		if !isValidState(filter.State) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid state", "allowed": knownStates})
+			return
+		}
+
+		c.Header("Content-Type", "text/csv")
+		c.Header("Content-Disposition", "attachment; filename=complaints.csv")
+		c.Status(http.StatusOK)
+
+		// Headers are already sent, so a failure here can only be logged.
+		if err := exportComplaintsCSV(c.Request.Context(), c.Writer, filter); err != nil {
+			requestLog(c).Error("Failed to export CSV", "error", err)
+		}
+	}
+
+	r.GET("/export/csv", exportCSVHandler)
+	r.GET("/complaints/csv", exportCSVHandler)
+
+	r.GET("/complaints/export/xlsx", func(c *gin.Context) {
+		filter := ComplaintFilter{
+			Start: c.Query("start"),
+			End:   c.Query("end"),
+		}
+
+		if _, err := parseDate(filter.Start); filter.Start != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date format"})
+			return
+		}
+
+		if _, err := parseDate(filter.End); filter.End != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date format"})
+			return
+		}
+
+		// The workbook is built in memory first so a query failure can still
+		// be reported as JSON.
+		var buf bytes.Buffer
+		if err := exportComplaintsXLSX(c.Request.Context(), &buf, filter); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export XLSX", "details": err.Error()})
+			return
+		}
+
+		c.Header("Content-Disposition", "attachment; filename=complaints.xlsx")
+		c.Data(http.StatusOK, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", buf.Bytes())
+	})
+
+	r.GET("/export/geojson", func(c *gin.Context) {
+		offset, limit, ok := parsePagingDefault(c, 100)
+		if !ok {
+			return
+		}
+
+		collection, err := exportFeatureCollection(c.Request.Context(), offset, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query MongoDB", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, collection)
+	})
+
+	r.GET("/districts", func(c *gin.Context) {
+		districts, err := distinctDistricts(c.Request.Context())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query districts", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"districts": districts})
+	})
+
+	r.GET("/complaints/subdistricts", func(c *gin.Context) {
+		district := strings.TrimSpace(c.Query("district"))
+		if district == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "district is required"})
+			return
+		}
+
+		subdistricts, err := distinctSubdistricts(c.Request.Context(), district)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query subdistricts", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"district": district, "subdistricts": subdistricts})
+	})
+
+	r.GET("/statistics/by-district", func(c *gin.Context) {
+		startDate := c.Query("start")
+		endDate := c.Query("end")
+
+		if _, err := parseDate(startDate); startDate != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date format"})
+			return
+		}
+
+		if _, err := parseDate(endDate); endDate != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date format"})
+			return
+		}
+
+		counts, err := aggregateByDistrict(c.Request.Context(), startDate, endDate)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to aggregate districts", "details": err.Error()})
+			return
+		}
+
+		total := 0
+		for _, dc := range counts {
+			total += dc.Count
+		}
+
+		c.JSON(http.StatusOK, gin.H{"total": total, "items": counts})
+	})
+
+	// GET /statistics/resolution-time reports mean, median and 95th
+	// percentile hours from report to last activity for finished complaints,
+	// optionally grouped by district and/or problem_type.
+	r.GET("/statistics/resolution-time", func(c *gin.Context) {
+		startDate := c.Query("start")
+		endDate := c.Query("end")
+		groupBy := splitList(c.Query("group_by"))
+
+		for _, group := range groupBy {
+			if !slices.Contains(resolutionGroups, group) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group_by", "allowed": resolutionGroups})
+				return
+			}
+		}
+
+		if _, err := parseDate(startDate); startDate != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date format"})
+			return
+		}
+
+		if _, err := parseDate(endDate); endDate != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date format"})
+			return
+		}
+
+		results, err := aggregateResolutionTime(c.Request.Context(), startDate, endDate, groupBy)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to aggregate resolution time", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"items": results})
+	})
+
+	r.GET("/statistics/timeseries", func(c *gin.Context) {
+		startDate := c.Query("start")
+		endDate := c.Query("end")
+		bucket := c.DefaultQuery("bucket", "day")
+
+		if !isValidTimeBucket(bucket) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid bucket", "allowed": timeBuckets})
+			return
+		}
+
+		if _, err := parseDate(startDate); startDate != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date format"})
+			return
+		}
+
+		if _, err := parseDate(endDate); endDate != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date format"})
+			return
+		}
+
+		counts, err := aggregateTimeseries(c.Request.Context(), startDate, endDate, bucket)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to aggregate timeseries", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, counts)
+	})
+
+	r.GET("/complaints/count-by-org", func(c *gin.Context) {
+		startDate := c.Query("start")
+		endDate := c.Query("end")
+
+		if _, err := parseDate(startDate); startDate != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date format"})
+			return
+		}
+
+		if _, err := parseDate(endDate); endDate != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date format"})
+			return
+		}
+
+		counts, err := aggregateCountByOrg(c.Request.Context(), startDate, endDate)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to aggregate organizations", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, counts)
+	})
+
+	r.GET("/complaints/open-vs-closed", func(c *gin.Context) {
+		startDate := c.Query("start")
+		endDate := c.Query("end")
+
+		if _, err := parseDate(startDate); startDate != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date format"})
+			return
+		}
+
+		if _, err := parseDate(endDate); endDate != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date format"})
+			return
+		}
+
+		counts, err := aggregateOpenClosed(c.Request.Context(), startDate, endDate)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to aggregate open and closed complaints", "details": err.Error()})
+			return
+		}
+
+		overallOpen, overallClosed := 0, 0
+		for _, oc := range counts {
+			overallOpen += oc.Open
+			overallClosed += oc.Closed
+		}
+
+		c.JSON(http.StatusOK, gin.H{"overall_open": overallOpen, "overall_closed": overallClosed, "items": counts})
+	})
+
+	r.GET("/complaints/top-problems", func(c *gin.Context) {
+		startDate := c.Query("start")
+		endDate := c.Query("end")
+
+		if _, err := parseDate(startDate); startDate != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date format"})
+			return
+		}
+
+		if _, err := parseDate(endDate); endDate != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date format"})
+			return
+		}
+
+		limit, ok := parseIntParamDefault(c, "limit", 10)
+		if !ok {
+			return
+		}
+		if limit <= 0 || limit > maxTopProblems {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxTopProblems)})
+			return
+		}
+
+		counts, err := aggregateTopProblems(c.Request.Context(), startDate, endDate, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to aggregate problem types", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, counts)
+	})
+
+	r.GET("/complaints/aggregate/org-load-balance", func(c *gin.Context) {
+		startDate := c.Query("start")
+		endDate := c.Query("end")
+
+		if _, err := parseDate(startDate); startDate != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date format"})
+			return
+		}
+
+		if _, err := parseDate(endDate); endDate != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date format"})
+			return
+		}
+
+		loads, err := aggregateOrgLoad(c.Request.Context(), startDate, endDate)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to aggregate org load", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"gini_coefficient": giniCoefficient(loads), "items": loads})
+	})
+
+	r.GET("/complaints/aggregate/geocoding-coverage", func(c *gin.Context) {
+		startDate := c.Query("start")
+		endDate := c.Query("end")
+
+		if _, err := parseDate(startDate); startDate != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date format"})
+			return
+		}
+
+		if _, err := parseDate(endDate); endDate != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date format"})
+			return
+		}
+
+		coverage, err := aggregateGeocodingCoverage(c.Request.Context(), startDate, endDate)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to aggregate geocoding coverage", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, coverage)
+	})
+
+	r.GET("/complaints/aggregate/reopen-streaks", func(c *gin.Context) {
+		startDate := c.Query("start")
+		endDate := c.Query("end")
+
+		if _, err := parseDate(startDate); startDate != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date format"})
+			return
+		}
+
+		if _, err := parseDate(endDate); endDate != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date format"})
+			return
+		}
+
+		minStreak, ok := parseIntParamDefault(c, "min_streak", 3)
+		if !ok {
+			return
+		}
+		if minStreak < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "min_streak must be at least 1"})
+			return
+		}
+
+		streaks, err := aggregateReopenStreaks(c.Request.Context(), startDate, endDate, minStreak)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to aggregate reopen streaks", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, streaks)
+	})
+
+	r.GET("/complaints/aggregate/problem-type-resolution", func(c *gin.Context) {
+		startDate := c.Query("start")
+		endDate := c.Query("end")
+
+		if _, err := parseDate(startDate); startDate != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date format"})
+			return
+		}
+
+		if _, err := parseDate(endDate); endDate != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date format"})
+			return
+		}
+
+		minTickets, ok := parseIntParamDefault(c, "min_tickets", 10)
+		if !ok {
+			return
+		}
+		if minTickets < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "min_tickets must be at least 1"})
+			return
+		}
+
+		results, err := aggregateProblemTypeResolution(c.Request.Context(), startDate, endDate, minTickets)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to aggregate problem type resolution", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, results)
+	})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestWriteRoutesRequireAuth(t *testing.T) {
+	r := newTestRouter(t, Config{JWTSecret: testJWTSecret})
+
+	routes := []struct {
+		method, path, body string
+	}{
+		{http.MethodPost, "/api/v1/sync/range", `{"start":"2024-01-01","end":"2024-01-02"}`},
+		{http.MethodPut, "/api/v1/complaints/T1", `{"state":"เสร็จสิ้น"}`},
+		{http.MethodDelete, "/api/v1/complaints/T1", ``},
+		{http.MethodPatch, "/api/v1/complaints/bulk-state", `{"ticket_ids":["T1"],"state":"เสร็จสิ้น"}`},
+		{http.MethodPost, "/api/v1/import/csv", ``},
+		{http.MethodPost, "/api/v1/complaints/import/json", `{"type":"FeatureCollection","features":[]}`},
+	}
+	for _, route := range routes {
+		w := serve(r, route.method, route.path, route.body, "Content-Type", "application/json")
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s without a token = %d, want 401", route.method, route.path, w.Code)
+		}
+	}
+}
+
+func TestComplaintFilterParamsValidated(t *testing.T) {
+	useStore(t)
+	r := newTestRouter(t, Config{DebugMode: true})
+
+	paths := []string{"/api/v1/", "/api/v1/topojson", "/api/v1/complaints", "/api/v1/debug/explain", "/api/v1/features/count"}
+	for _, path := range paths {
+		for _, query := range []string{"bbox=100,13,99,14", "state=unknown", "start=yesterday", "min_star=x"} {
+			if w := serve(r, http.MethodGet, path+"?"+query, ""); w.Code != http.StatusBadRequest {
+				t.Errorf("GET %s?%s = %d, want 400", path, query, w.Code)
+			}
+		}
+	}
+}
+
+func TestFeaturesCountAppliesBBox(t *testing.T) {
+	store := useStore(t)
+	r := newTestRouter(t, Config{})
+	store.InsertFeatures(context.Background(), []Feature{pointFeature("in", 100.5, 13.7), pointFeature("out", 101.5, 14.7)})
+
+	w := serve(r, http.MethodGet, "/api/v1/features/count?bbox=100,13,101,14", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"count":1`) {
+		t.Errorf("status = %d, body %s, want a count of 1", w.Code, w.Body)
+	}
+}
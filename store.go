@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// errTicketNotFound is returned by the Store methods that target a single
+// ticket when no document has the ticket ID.
+var errTicketNotFound = errors.New("ticket not found")
+
+// Store is the persistence the handlers use to read and write complaints.
+// Aggregation pipelines, index management and schema migrations are
+// MongoDB-specific and still use postsCollection directly.
+//
+// The insert methods only return an error when the whole batch failed;
+// per-document failures are reported in the BulkResult, see BulkResult.Err.
+// FindComplaints calls fn for each match in turn, so large results can be
+// streamed, and stops at the first error fn returns.
+//
+// FindFeatures, FindStale and SearchFeatures return pages of Feature
+// documents; FindFeatures serves flat complaints too when filter.Schema
+// asks for them.
+type Store interface {
+	InsertFeatures(ctx context.Context, features []Feature) (BulkResult, error)
+	InsertComplaints(ctx context.Context, complaints []Complaint) (BulkResult, error)
+	FindComplaints(ctx context.Context, filter ComplaintFilter, fn func(Complaint) error) error
+	CountComplaints(ctx context.Context, filter ComplaintFilter) (int64, error)
+	DeleteOne(ctx context.Context, ticketID string) error
+	DeleteComplaints(ctx context.Context, filter ComplaintFilter) (int64, error)
+
+	FindFeatures(ctx context.Context, filter ComplaintFilter, offset, limit int, cursor string) (ComplaintsPage, error)
+	FindStale(ctx context.Context, threshold time.Time, state string, offset, limit int) (ComplaintsPage, error)
+	SearchFeatures(ctx context.Context, q string, filter ComplaintFilter, offset, limit int) (ComplaintsPage, error)
+	FindNearby(ctx context.Context, lng, lat, radiusM float64, limit int) ([]Feature, error)
+	Heatmap(ctx context.Context, bbox *[4]float64, gridSize float64) ([]HeatmapCell, error)
+
+	FindOne(ctx context.Context, ticketID string) (bson.M, error)
+	UpdateOne(ctx context.Context, ticketID string, update ComplaintUpdate) (bson.M, error)
+	UpdateMany(ctx context.Context, ticketIDs []string, update ComplaintUpdate) (matched, modified int64, err error)
+	History(ctx context.Context, ticketID string) ([]HistoryEntry, error)
+
+	FindDuplicates(ctx context.Context, offset, limit int) (int, []DuplicateTicket, error)
+	DeleteDuplicates(ctx context.Context) (int64, error)
+}
+
+// complaintStore is the Store handlers use. Tests can swap in a MemoryStore.
+var complaintStore Store = MongoStore{}
+
+// MongoStore is the Store backed by postsCollection.
+type MongoStore struct{}
+
+// InsertFeatures upserts features by ticket ID.
+func (MongoStore) InsertFeatures(ctx context.Context, features []Feature) (BulkResult, error) {
+	return saveFeaturesToMongoDB(ctx, Data{Features: features})
+}
+
+func (MongoStore) InsertComplaints(ctx context.Context, complaints []Complaint) (BulkResult, error) {
+	return saveFeaturesToMongoDBCSV(ctx, complaints)
+}
+
+// FindComplaints streams documents matching filter, with features
+// converted to complaints. The cursor is not bounded by mongoOpTimeout,
+// since fn may be writing each result to a slow client.
+func (MongoStore) FindComplaints(ctx context.Context, filter ComplaintFilter, fn func(Complaint) error) error {
+	cursor, err := postsCollection.Find(ctx, filter.storeFilter())
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		complaint, err := decodeComplaint(cursor.Current)
+		if err != nil {
+			return err
+		}
+		if err := fn(complaint); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (MongoStore) CountComplaints(ctx context.Context, filter ComplaintFilter) (int64, error) {
+	ctx, cancel := withMongoTimeout(ctx, mongoOpTimeout)
+	defer cancel()
+
+	return postsCollection.CountDocuments(ctx, filter.storeFilter())
+}
+
+func (MongoStore) DeleteOne(ctx context.Context, ticketID string) error {
+	deleted, err := deleteComplaint(ctx, ticketID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return errTicketNotFound
+	}
+	return nil
+}
+
+func (MongoStore) DeleteComplaints(ctx context.Context, filter ComplaintFilter) (int64, error) {
+	return deleteComplaints(ctx, filter)
+}
+
+func (MongoStore) FindFeatures(ctx context.Context, filter ComplaintFilter, offset, limit int, cursor string) (ComplaintsPage, error) {
+	return findComplaints(ctx, filter, offset, limit, cursor)
+}
+
+func (MongoStore) FindStale(ctx context.Context, threshold time.Time, state string, offset, limit int) (ComplaintsPage, error) {
+	return staleComplaints(ctx, threshold, state, offset, limit)
+}
+
+func (MongoStore) SearchFeatures(ctx context.Context, q string, filter ComplaintFilter, offset, limit int) (ComplaintsPage, error) {
+	return searchComplaints(ctx, q, filter, offset, limit)
+}
+
+func (MongoStore) FindNearby(ctx context.Context, lng, lat, radiusM float64, limit int) ([]Feature, error) {
+	return nearbyComplaints(ctx, lng, lat, radiusM, limit)
+}
+
+func (MongoStore) Heatmap(ctx context.Context, bbox *[4]float64, gridSize float64) ([]HeatmapCell, error) {
+	return heatmapCells(ctx, bbox, gridSize)
+}
+
+func (MongoStore) FindOne(ctx context.Context, ticketID string) (bson.M, error) {
+	doc, err := findComplaint(ctx, ticketID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, errTicketNotFound
+	}
+	return doc, err
+}
+
+func (MongoStore) UpdateOne(ctx context.Context, ticketID string, update ComplaintUpdate) (bson.M, error) {
+	doc, err := updateComplaint(ctx, ticketID, update)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, errTicketNotFound
+	}
+	return doc, err
+}
+
+func (MongoStore) UpdateMany(ctx context.Context, ticketIDs []string, update ComplaintUpdate) (int64, int64, error) {
+	return bulkUpdate(ctx, ticketIDs, update)
+}
+
+func (MongoStore) History(ctx context.Context, ticketID string) ([]HistoryEntry, error) {
+	return featureHistory(ctx, ticketID)
+}
+
+func (MongoStore) FindDuplicates(ctx context.Context, offset, limit int) (int, []DuplicateTicket, error) {
+	return findDuplicates(ctx, offset, limit)
+}
+
+func (MongoStore) DeleteDuplicates(ctx context.Context) (int64, error) {
+	return deleteDuplicates(ctx)
+}
@@ -0,0 +1,673 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const importFeaturesJSON = `{
+	"type": "FeatureCollection",
+	"features": [
+		{"type": "Feature", "geometry": {"type": "Point", "coordinates": [100.5, 13.7]},
+		 "properties": {"ticket_id": "F1", "state": "เสร็จสิ้น", "timestamp": "2024-01-02 10:00:00.000000+0700"}},
+		{"type": "Feature", "geometry": {"type": "Point", "coordinates": [100.6, 13.8]},
+		 "properties": {"ticket_id": "F2", "state": "รอรับเรื่อง", "timestamp": "2024-01-03 10:00:00.000000+0700"}}
+	]
+}`
+
+func TestImportJSONThroughStore(t *testing.T) {
+	store := useStore(t)
+	r := newTestRouter(t, Config{JWTSecret: testJWTSecret})
+
+	for i, want := range []ImportResult{{Inserted: 2}, {Updated: 2}} {
+		w := serve(r, http.MethodPost, "/api/v1/complaints/import/json", importFeaturesJSON,
+			"Content-Type", "application/json", "Authorization", bearer(t))
+		if w.Code != http.StatusOK {
+			t.Fatalf("import %d: status = %d, want 200: %s", i+1, w.Code, w.Body)
+		}
+		var got ImportResult
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("import %d: decode body: %v", i+1, err)
+		}
+		if got.Inserted != want.Inserted || got.Updated != want.Updated {
+			t.Errorf("import %d: result = %+v, want %d inserted and %d updated", i+1, got, want.Inserted, want.Updated)
+		}
+	}
+
+	if n, _ := store.CountComplaints(context.Background(), ComplaintFilter{}); n != 2 {
+		t.Errorf("store holds %d complaints after importing the same features twice, want 2", n)
+	}
+}
+
+func TestImportCSVThroughStore(t *testing.T) {
+	store := useStore(t)
+	r := newTestRouter(t, Config{JWTSecret: testJWTSecret})
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("file", "complaints.csv")
+	part.Write([]byte("ticket_id,state,timestamp\nC1,เสร็จสิ้น,2024-01-02 10:00:00.000000+0700\nC2,กำลังดำเนินการ\n"))
+	form.Close()
+
+	w := serve(r, http.MethodPost, "/api/v1/import/csv", body.String(),
+		"Content-Type", form.FormDataContentType(), "Authorization", bearer(t))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var got ImportResult
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if got.Inserted != 1 || got.Skipped != 1 {
+		t.Errorf("result = %+v, want 1 inserted and the short row skipped", got)
+	}
+
+	n, _ := store.CountComplaints(context.Background(), ComplaintFilter{Schema: schemaComplaint})
+	if n != 1 {
+		t.Errorf("store holds %d CSV complaints, want 1", n)
+	}
+}
+
+func TestCountAndExportThroughStore(t *testing.T) {
+	store := useStore(t)
+	r := newTestRouter(t, Config{JWTSecret: testJWTSecret})
+
+	ctx := context.Background()
+	store.InsertFeatures(ctx, []Feature{testFeature("F1"), testFeature("F2")})
+	store.InsertComplaints(ctx, []Complaint{{TicketID: "C1", State: "เสร็จสิ้น"}})
+
+	w := serve(r, http.MethodGet, "/api/v1/features/count", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("count: status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var count struct {
+		Count  int64  `json:"count"`
+		Source string `json:"source"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &count); err != nil {
+		t.Fatalf("count: decode body: %v", err)
+	}
+	if count.Count != 2 || count.Source != "mongodb" {
+		t.Errorf("count = %+v, want the 2 stored features", count)
+	}
+
+	w = serve(r, http.MethodGet, "/api/v1/export/csv", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("export: status = %d, want 200: %s", w.Code, w.Body)
+	}
+	rows, err := csv.NewReader(strings.NewReader(w.Body.String())).ReadAll()
+	if err != nil {
+		t.Fatalf("export: parse CSV: %v", err)
+	}
+	if len(rows) != 4 {
+		t.Fatalf("export has %d rows, want a header and one per stored document", len(rows))
+	}
+	ticketCol := -1
+	for i, name := range rows[0] {
+		if name == "ticket_id" {
+			ticketCol = i
+		}
+	}
+	var tickets []string
+	for _, row := range rows[1:] {
+		tickets = append(tickets, row[ticketCol])
+	}
+	if got := strings.Join(tickets, ","); got != "F1,F2,C1" {
+		t.Errorf("exported tickets %s, want F1,F2,C1", got)
+	}
+}
+
+func TestDeleteThroughStore(t *testing.T) {
+	store := useStore(t)
+	r := newTestRouter(t, Config{JWTSecret: testJWTSecret})
+	store.InsertFeatures(context.Background(), []Feature{testFeature("F1")})
+
+	w := serve(r, http.MethodDelete, "/api/v1/complaints/F1", "", "Authorization", bearer(t))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want 204: %s", w.Code, w.Body)
+	}
+	if n, _ := store.CountComplaints(context.Background(), ComplaintFilter{}); n != 0 {
+		t.Errorf("store still holds %d complaints after the delete", n)
+	}
+
+	w = serve(r, http.MethodDelete, "/api/v1/complaints/F1", "", "Authorization", bearer(t))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("second delete: status = %d, want 404", w.Code)
+	}
+}
+
+// pointFeature is testFeature located at lng, lat.
+func pointFeature(ticketID string, lng, lat float64) Feature {
+	f := testFeature(ticketID)
+	f.Geometry = Coordinates{Type: "Point", Coordinates: []float64{lng, lat}}
+	return f
+}
+
+// decodePage decodes a ComplaintsPage response and returns its ticket IDs.
+func decodePage(t *testing.T, w *httptest.ResponseRecorder) (ComplaintsPage, []string) {
+	t.Helper()
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var page ComplaintsPage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	var tickets []string
+	for _, f := range page.Data {
+		tickets = append(tickets, f.Properties.TicketID)
+	}
+	return page, tickets
+}
+
+func TestComplaintsThroughStore(t *testing.T) {
+	store := useStore(t)
+	r := newTestRouter(t, Config{})
+
+	ctx := context.Background()
+	store.InsertFeatures(ctx, []Feature{testFeature("F1"), testFeature("F2"), testFeature("F3")})
+	store.InsertComplaints(ctx, []Complaint{{TicketID: "C1", State: "start"}})
+
+	page, tickets := decodePage(t, serve(r, http.MethodGet, "/api/v1/complaints?limit=2", ""))
+	if strings.Join(tickets, ",") != "F1,F2" || page.Meta.Total != 3 || !page.Meta.HasMore {
+		t.Fatalf("first page %v, meta %+v, want F1,F2 of 3 features", tickets, page.Meta)
+	}
+
+	_, tickets = decodePage(t, serve(r, http.MethodGet, "/api/v1/complaints?limit=2&cursor="+page.Meta.NextCursor, ""))
+	if strings.Join(tickets, ",") != "F3" {
+		t.Errorf("page after next_cursor %v, want F3", tickets)
+	}
+
+	_, tickets = decodePage(t, serve(r, http.MethodGet, "/api/v1/complaints?schema=complaint", ""))
+	if strings.Join(tickets, ",") != "C1" {
+		t.Errorf("schema=complaint returned %v, want C1", tickets)
+	}
+}
+
+func TestComplaintsUnknownProvince(t *testing.T) {
+	store := useStore(t)
+	r := newTestRouter(t, Config{})
+
+	bangkok := testFeature("F1")
+	bangkok.Properties.Province = "กรุงเทพมหานคร"
+	ctx := context.Background()
+	store.InsertFeatures(ctx, []Feature{bangkok})
+	store.InsertComplaints(ctx, []Complaint{{TicketID: "C1", State: "start", Province: "กรุงเทพมหานคร"}})
+
+	_, tickets := decodePage(t, serve(r, http.MethodGet, "/api/v1/complaints?province=+กรุงเทพมหานคร+", ""))
+	if strings.Join(tickets, ",") != "F1" {
+		t.Errorf("province=กรุงเทพมหานคร returned %v, want F1", tickets)
+	}
+
+	for _, query := range []string{"province=Atlantis", "province=Atlantis&schema=complaint"} {
+		w := serve(r, http.MethodGet, "/api/v1/complaints?"+query, "")
+		page, tickets := decodePage(t, w)
+		if len(tickets) != 0 || page.Meta.Total != 0 {
+			t.Errorf("%s returned %v of %d, want nothing", query, tickets, page.Meta.Total)
+		}
+		if !strings.Contains(w.Body.String(), `"data":[]`) {
+			t.Errorf("%s: body = %s, want an empty data array", query, w.Body)
+		}
+	}
+}
+
+func TestStarRangeMixedTypes(t *testing.T) {
+	store := useStore(t)
+	r := newTestRouter(t, Config{})
+
+	starred := func(ticketID string, star interface{}) Feature {
+		f := testFeature(ticketID)
+		f.Properties.Star = star
+		return f
+	}
+	ctx := context.Background()
+	store.InsertFeatures(ctx, []Feature{
+		starred("int5", 5),
+		starred("float3.5", 3.5),
+		starred("string4", "4"),
+		starred("string2", " 2 "),
+		starred("nostar", nil),
+		starred("text", "good"),
+	})
+	store.InsertComplaints(ctx, []Complaint{{TicketID: "csv4.5", Star: "4.5"}, {TicketID: "csv-empty"}})
+
+	tests := []struct {
+		query, want string
+	}{
+		{"min_star=4", "int5,string4"},
+		{"max_star=3.5", "float3.5,string2"},
+		{"min_star=3&max_star=4.5", "float3.5,string4"},
+		{"min_star=4&schema=complaint", "csv4.5"},
+		{"min_star=+2&max_star=2", "string2"},
+		{"min_star=6", ""},
+	}
+	for _, tc := range tests {
+		_, tickets := decodePage(t, serve(r, http.MethodGet, "/api/v1/complaints?"+tc.query, ""))
+		if got := strings.Join(tickets, ","); got != tc.want {
+			t.Errorf("%s returned %q, want %q", tc.query, got, tc.want)
+		}
+	}
+
+	for _, query := range []string{"min_star=x", "max_star=NaN", "min_star=Inf", "min_star=4&max_star=3"} {
+		if w := serve(r, http.MethodGet, "/api/v1/complaints?"+query, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", query, w.Code)
+		}
+	}
+}
+
+func TestReopenRange(t *testing.T) {
+	store := useStore(t)
+	r := newTestRouter(t, Config{})
+
+	reopened := func(ticketID string, count int) Feature {
+		f := testFeature(ticketID)
+		f.Properties.CountReopen = count
+		return f
+	}
+	ctx := context.Background()
+	store.InsertFeatures(ctx, []Feature{reopened("R0", 0), reopened("R2", 2), reopened("R5", 5)})
+	store.InsertComplaints(ctx, []Complaint{{TicketID: "C0", CountReopen: "0"}, {TicketID: "C2", CountReopen: "2"}, {TicketID: "C5", CountReopen: "5"}})
+
+	tests := []struct {
+		query, want string
+	}{
+		{"min_reopen=0", "R0,R2,R5"},
+		{"max_reopen=0", "R0"},
+		{"min_reopen=1", "R2,R5"},
+		{"min_reopen=2&max_reopen=4", "R2"},
+		{"min_reopen=5&max_reopen=5", "R5"},
+		{"min_reopen=6", ""},
+		{"min_reopen=1&schema=complaint", "C2,C5"},
+		{"max_reopen=2&schema=complaint", "C0,C2"},
+	}
+	for _, tc := range tests {
+		_, tickets := decodePage(t, serve(r, http.MethodGet, "/api/v1/complaints?"+tc.query, ""))
+		if got := strings.Join(tickets, ","); got != tc.want {
+			t.Errorf("%s returned %q, want %q", tc.query, got, tc.want)
+		}
+	}
+
+	for _, query := range []string{"min_reopen=-1", "max_reopen=1.5", "min_reopen=x", "min_reopen=5&max_reopen=2"} {
+		if w := serve(r, http.MethodGet, "/api/v1/complaints?"+query, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", query, w.Code)
+		}
+	}
+}
+
+func TestGetAndUpdateComplaintThroughStore(t *testing.T) {
+	store := useStore(t)
+	r := newTestRouter(t, Config{JWTSecret: testJWTSecret})
+
+	ctx := context.Background()
+	store.InsertFeatures(ctx, []Feature{testFeature("F1")})
+	store.InsertComplaints(ctx, []Complaint{{TicketID: "C1", State: "start", CountReopen: "0"}})
+
+	w := serve(r, http.MethodPut, "/api/v1/complaints/F1", `{"state":"finish","count_reopen":2}`,
+		"Content-Type", "application/json", "Authorization", bearer(t))
+	if w.Code != http.StatusOK {
+		t.Fatalf("update feature: status = %d, want 200: %s", w.Code, w.Body)
+	}
+	w = serve(r, http.MethodPut, "/api/v1/complaints/C1", `{"state":"finish","count_reopen":2}`,
+		"Content-Type", "application/json", "Authorization", bearer(t))
+	if w.Code != http.StatusOK {
+		t.Fatalf("update complaint: status = %d, want 200: %s", w.Code, w.Body)
+	}
+
+	w = serve(r, http.MethodGet, "/api/v1/complaints/F1", "")
+	var feature Feature
+	if err := json.Unmarshal(w.Body.Bytes(), &feature); err != nil {
+		t.Fatalf("get feature: decode body: %v", err)
+	}
+	if feature.Properties.State != "finish" || feature.Properties.CountReopen != 2 {
+		t.Errorf("stored feature %+v, want state finish and 2 reopens", feature.Properties)
+	}
+
+	w = serve(r, http.MethodGet, "/api/v1/complaints/C1", "")
+	var complaint Complaint
+	if err := json.Unmarshal(w.Body.Bytes(), &complaint); err != nil {
+		t.Fatalf("get complaint: decode body: %v", err)
+	}
+	if complaint.State != "finish" || complaint.CountReopen != "2" {
+		t.Errorf("stored complaint %+v, want state finish and count_reopen \"2\"", complaint)
+	}
+
+	if w := serve(r, http.MethodGet, "/api/v1/complaints/missing", ""); w.Code != http.StatusNotFound {
+		t.Errorf("get missing: status = %d, want 404", w.Code)
+	}
+	w = serve(r, http.MethodPut, "/api/v1/complaints/missing", `{"state":"finish"}`,
+		"Content-Type", "application/json", "Authorization", bearer(t))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("update missing: status = %d, want 404", w.Code)
+	}
+}
+
+func TestBulkStateThroughStore(t *testing.T) {
+	store := useStore(t)
+	r := newTestRouter(t, Config{JWTSecret: testJWTSecret})
+
+	done := testFeature("F2")
+	done.Properties.State = "finish"
+	store.InsertFeatures(context.Background(), []Feature{testFeature("F1"), done})
+
+	w := serve(r, http.MethodPatch, "/api/v1/complaints/bulk-state", `{"ticket_ids":["F1","F2","F9"],"state":"finish"}`,
+		"Content-Type", "application/json", "Authorization", bearer(t))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var got struct {
+		Matched  int64 `json:"matched"`
+		Modified int64 `json:"modified"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if got.Matched != 2 || got.Modified != 1 {
+		t.Errorf("result = %+v, want 2 matched and only F1 modified", got)
+	}
+	if n, _ := store.CountComplaints(context.Background(), ComplaintFilter{State: "finish"}); n != 2 {
+		t.Errorf("%d complaints finished, want 2", n)
+	}
+}
+
+func TestStaleThroughStore(t *testing.T) {
+	store := useStore(t)
+	r := newTestRouter(t, Config{})
+
+	activity := func(ticketID string, daysAgo int) Feature {
+		f := testFeature(ticketID)
+		f.Properties.LastActivity = time.Now().AddDate(0, 0, -daysAgo).In(bangkokTime).Format(storedTimestampLayout)
+		return f
+	}
+	store.InsertFeatures(context.Background(), []Feature{activity("recent", 1), activity("old", 40), activity("older", 90), testFeature("unknown")})
+
+	_, tickets := decodePage(t, serve(r, http.MethodGet, "/api/v1/complaints/stale?days=30", ""))
+	if strings.Join(tickets, ",") != "older,old" {
+		t.Errorf("stale tickets %v, want older,old", tickets)
+	}
+}
+
+func TestSearchThroughStore(t *testing.T) {
+	store := useStore(t)
+	r := newTestRouter(t, Config{})
+
+	describe := func(ticketID, description string) Feature {
+		f := testFeature(ticketID)
+		f.Properties.Description = description
+		return f
+	}
+	store.InsertFeatures(context.Background(), []Feature{
+		describe("F1", "broken street light"),
+		describe("F2", "flooded street near the broken pipe"),
+		describe("F3", "noise"),
+	})
+
+	_, tickets := decodePage(t, serve(r, http.MethodGet, "/api/v1/complaints/search?q=broken+pipe", ""))
+	if strings.Join(tickets, ",") != "F2,F1" {
+		t.Errorf("search results %v, want F2 ranked above F1", tickets)
+	}
+}
+
+func TestNearbyThroughStore(t *testing.T) {
+	store := useStore(t)
+	r := newTestRouter(t, Config{})
+
+	ctx := context.Background()
+	store.InsertFeatures(ctx, []Feature{pointFeature("far", 100.6, 13.8), pointFeature("near", 100.501, 13.7)})
+	store.InsertComplaints(ctx, []Complaint{{TicketID: "C1", Coords: "100.5,13.7"}})
+
+	w := serve(r, http.MethodGet, "/api/v1/complaints/nearby?lat=13.7&lng=100.5&radius_m=1000", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var collection FeatureCollection
+	if err := json.Unmarshal(w.Body.Bytes(), &collection); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	var tickets []string
+	for _, f := range collection.Features {
+		tickets = append(tickets, f.Properties.TicketID)
+	}
+	if strings.Join(tickets, ",") != "C1,near" {
+		t.Errorf("nearby tickets %v, want C1,near nearest first", tickets)
+	}
+}
+
+func TestHeatmapThroughStore(t *testing.T) {
+	store := useStore(t)
+	r := newTestRouter(t, Config{})
+
+	store.InsertFeatures(context.Background(), []Feature{
+		pointFeature("F1", 100.51, 13.71),
+		pointFeature("F2", 100.52, 13.69),
+		pointFeature("F3", 101.2, 14.1),
+		testFeature("no point"),
+	})
+
+	w := serve(r, http.MethodGet, "/api/v1/complaints/heatmap?grid_size=0.1&bbox=100,13,101,14", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var cells []HeatmapCell
+	if err := json.Unmarshal(w.Body.Bytes(), &cells); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if len(cells) != 1 || cells[0].Count != 2 || math.Abs(cells[0].Lng-100.5) > 1e-9 || math.Abs(cells[0].Lat-13.7) > 1e-9 {
+		t.Errorf("cells = %+v, want the two points inside bbox in the 100.5,13.7 cell", cells)
+	}
+}
+
+func TestHeatmapCellsSnapToGrid(t *testing.T) {
+	store := useStore(t)
+	r := newTestRouter(t, Config{})
+
+	points := [][2]float64{{100.5123, 13.7456}, {100.5129, 13.7449}, {100.6871, 13.8012}, {100.3333, 13.6667}, {100.9999, 13.0001}}
+	var features []Feature
+	for i, p := range points {
+		features = append(features, pointFeature(fmt.Sprintf("F%d", i), p[0], p[1]))
+	}
+	store.InsertFeatures(context.Background(), features)
+
+	for _, gridSize := range []float64{0.001, 0.01, 0.05, 0.25, 1} {
+		w := serve(r, http.MethodGet, fmt.Sprintf("/api/v1/complaints/heatmap?grid_size=%g", gridSize), "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("grid_size=%g: status = %d, want 200: %s", gridSize, w.Code, w.Body)
+		}
+		var cells []HeatmapCell
+		if err := json.Unmarshal(w.Body.Bytes(), &cells); err != nil {
+			t.Fatalf("grid_size=%g: decode body: %v", gridSize, err)
+		}
+
+		total := 0
+		for _, cell := range cells {
+			total += cell.Count
+			for _, v := range []float64{cell.Lat, cell.Lng} {
+				if steps := v / gridSize; math.Abs(steps-math.Round(steps)) > 1e-6 {
+					t.Errorf("grid_size=%g: cell %+v is not on the grid", gridSize, cell)
+				}
+			}
+		}
+		if total != len(points) {
+			t.Errorf("grid_size=%g: cells count %d points, want %d", gridSize, total, len(points))
+		}
+		for _, p := range points {
+			if !slices.ContainsFunc(cells, func(cell HeatmapCell) bool {
+				return math.Abs(cell.Lng-p[0]) <= gridSize/2+1e-9 && math.Abs(cell.Lat-p[1]) <= gridSize/2+1e-9
+			}) {
+				t.Errorf("grid_size=%g: no cell within half a step of %v: %+v", gridSize, p, cells)
+			}
+		}
+	}
+}
+
+func TestDuplicatesThroughStore(t *testing.T) {
+	store := useStore(t)
+	r := newTestRouter(t, Config{JWTSecret: testJWTSecret})
+
+	ctx := context.Background()
+	store.InsertFeatures(ctx, []Feature{testFeature("F1")})
+	store.InsertComplaints(ctx, []Complaint{{TicketID: "F1"}, {TicketID: "C1"}, {TicketID: "C1"}, {TicketID: "C1"}, {TicketID: "C2"}})
+
+	w := serve(r, http.MethodGet, "/api/v1/complaints/duplicates", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("find: status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var found struct {
+		Total int               `json:"total_duplicates"`
+		Items []DuplicateTicket `json:"items"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &found); err != nil {
+		t.Fatalf("find: decode body: %v", err)
+	}
+	if found.Total != 1 || len(found.Items) != 1 || found.Items[0] != (DuplicateTicket{TicketID: "C1", Count: 3}) {
+		t.Errorf("duplicates = %+v, want only C1 three times; F1 is stored once per schema", found)
+	}
+
+	w = serve(r, http.MethodDelete, "/api/v1/complaints/duplicates", "", "Authorization", bearer(t))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"deleted":2`) {
+		t.Errorf("delete: status = %d, body %s, want 2 deleted", w.Code, w.Body)
+	}
+	if n, _ := store.CountComplaints(ctx, ComplaintFilter{}); n != 4 {
+		t.Errorf("store holds %d documents after deleting duplicates, want 4", n)
+	}
+}
+
+func TestHistoryThroughStore(t *testing.T) {
+	store := useStore(t)
+	r := newTestRouter(t, Config{})
+
+	ctx := context.Background()
+	store.InsertFeatures(ctx, []Feature{testFeature("T1")})
+	store.InsertComplaints(ctx, []Complaint{{TicketID: "T1", State: "finish", Type: "ถนน"}})
+
+	w := serve(r, http.MethodGet, "/api/v1/features/T1/history", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var history []HistoryEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &history); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("history has %d versions, want 2", len(history))
+	}
+	if change := history[1].Diff["state"]; change.From != "รอรับเรื่อง" || change.To != "finish" {
+		t.Errorf("second version diff %+v, want the state change", history[1].Diff)
+	}
+
+	if w := serve(r, http.MethodGet, "/api/v1/features/missing/history", ""); w.Code != http.StatusNotFound {
+		t.Errorf("missing ticket: status = %d, want 404", w.Code)
+	}
+}
+
+func TestExportGeoJSONThroughStore(t *testing.T) {
+	store := useStore(t)
+	r := newTestRouter(t, Config{})
+
+	ctx := context.Background()
+	store.InsertFeatures(ctx, []Feature{pointFeature("F1", 100.5, 13.7)})
+	store.InsertComplaints(ctx, []Complaint{{TicketID: "C1", Coords: "100.6,13.8"}})
+
+	w := serve(r, http.MethodGet, "/api/v1/export/geojson", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var collection FeatureCollection
+	if err := json.Unmarshal(w.Body.Bytes(), &collection); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if len(collection.Features) != 1 || collection.Features[0].Properties.TicketID != "F1" {
+		t.Errorf("exported %+v, want the one stored feature", collection.Features)
+	}
+}
+
+func TestClearRangeThroughStore(t *testing.T) {
+	store := useStore(t)
+	r := newTestRouter(t, Config{JWTSecret: testJWTSecret})
+
+	dated := func(ticketID, timestamp string) Feature {
+		f := testFeature(ticketID)
+		f.Properties.Timestamp = timestamp
+		return f
+	}
+	ctx := context.Background()
+	store.InsertFeatures(ctx, []Feature{
+		dated("in", "2024-01-02 10:00:00.000000+0700"),
+		dated("out", "2024-02-02 10:00:00.000000+0700"),
+	})
+	store.InsertComplaints(ctx, []Complaint{{TicketID: "C1", Timestamp: "2024-01-03 09:00:00.000000+0700"}})
+
+	w := serve(r, http.MethodDelete, "/api/v1/complaints?start=2024-01-01&end=2024-01-31", "", "Authorization", bearer(t))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"deleted":2`) {
+		t.Fatalf("status = %d, body %s, want 2 deleted", w.Code, w.Body)
+	}
+	if _, err := store.FindOne(ctx, "out"); err != nil {
+		t.Errorf("the feature outside the range is gone: %v", err)
+	}
+}
+
+func TestClearRangeBoundaries(t *testing.T) {
+	store := useStore(t)
+	r := newTestRouter(t, Config{JWTSecret: testJWTSecret})
+
+	dated := func(ticketID, timestamp string) Feature {
+		f := testFeature(ticketID)
+		f.Properties.Timestamp = timestamp
+		return f
+	}
+	ctx := context.Background()
+	store.InsertFeatures(ctx, []Feature{
+		dated("before", "2023-12-31 23:59:59.000000+0700"),
+		dated("first", "2024-01-01 00:00:00.000000+0700"),
+		dated("last", "2024-01-31 23:59:59.999999+0700"),
+		dated("after", "2024-02-01 00:00:00.000000+0700"),
+		testFeature("undated"),
+	})
+	store.InsertComplaints(ctx, []Complaint{
+		{TicketID: "csv-in", Timestamp: "2024-01-15 12:00:00.000000+0700"},
+		{TicketID: "csv-out", Timestamp: "2024-03-15 12:00:00.000000+0700"},
+	})
+
+	for _, target := range []string{
+		"/api/v1/complaints?start=2024-01-01",
+		"/api/v1/complaints?end=2024-01-31",
+		"/api/v1/complaints?start=2024-01-31&end=2024-01-01",
+		"/api/v1/complaints?start=2024-01-01&end=2024-13-01",
+	} {
+		if w := serve(r, http.MethodDelete, target, "", "Authorization", bearer(t)); w.Code != http.StatusBadRequest {
+			t.Errorf("DELETE %s: status = %d, want 400", target, w.Code)
+		}
+	}
+	if w := serve(r, http.MethodDelete, "/api/v1/complaints?start=2024-01-01&end=2024-01-31", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("without a token: status = %d, want 401", w.Code)
+	}
+	if n, _ := store.CountComplaints(ctx, ComplaintFilter{}); n != 7 {
+		t.Fatalf("rejected requests left %d documents, want all 7", n)
+	}
+
+	w := serve(r, http.MethodDelete, "/api/v1/saveToMongoDB/clear?start=2024-01-01&end=2024-01-31", "", "Authorization", bearer(t))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"deleted":3`) {
+		t.Fatalf("status = %d, body %s, want 3 deleted", w.Code, w.Body)
+	}
+	for _, ticketID := range []string{"before", "after", "undated", "csv-out"} {
+		if _, err := store.FindOne(ctx, ticketID); err != nil {
+			t.Errorf("%s is outside the range but was deleted: %v", ticketID, err)
+		}
+	}
+	for _, ticketID := range []string{"first", "last", "csv-in"} {
+		if _, err := store.FindOne(ctx, ticketID); err == nil {
+			t.Errorf("%s is inside the range but was kept", ticketID)
+		}
+	}
+}
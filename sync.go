@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+const syncBatchSize = 1000
+
+type SyncStatus struct {
+	Enabled    bool      `json:"enabled"`
+	Running    bool      `json:"running"`
+	LastRun    time.Time `json:"last_run,omitempty"`
+	LastResult string    `json:"last_result,omitempty"`
+	LastCount  int       `json:"last_count"`
+}
+
+// Scheduler periodically pulls recent data from the upstream API into
+// MongoDB. A cycle is skipped if the previous one is still running.
+type Scheduler struct {
+	interval time.Duration
+	sync     func(ctx context.Context) (int, error)
+
+	mu     sync.Mutex
+	status SyncStatus
+	runs   sync.WaitGroup
+}
+
+func NewScheduler(interval time.Duration, sync func(ctx context.Context) (int, error)) *Scheduler {
+	return &Scheduler{
+		interval: interval,
+		sync:     sync,
+		status:   SyncStatus{Enabled: true},
+	}
+}
+
+// Start runs the sync loop in the background until ctx is cancelled.
+func (s *Scheduler) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.runs.Add(1)
+				go func() {
+					defer s.runs.Done()
+					s.runOnce(ctx)
+				}()
+			}
+		}
+	}()
+}
+
+// Wait blocks until every sync cycle started so far has returned.
+func (s *Scheduler) Wait() {
+	s.runs.Wait()
+}
+
+func (s *Scheduler) runOnce(ctx context.Context) {
+	s.mu.Lock()
+	if s.status.Running {
+		s.mu.Unlock()
+		slog.Warn("Sync skipped: previous cycle still running")
+		return
+	}
+	s.status.Running = true
+	s.mu.Unlock()
+
+	started := time.Now()
+	count, err := s.sync(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status.Running = false
+	s.status.LastRun = started
+	s.status.LastCount = count
+	if err != nil {
+		s.status.LastResult = err.Error()
+		slog.Error("Sync failed", "count", count, "duration", time.Since(started), "error", err)
+		return
+	}
+	s.status.LastResult = "ok"
+	slog.Info("Sync finished", "count", count, "duration", time.Since(started))
+}
+
+func (s *Scheduler) Status() SyncStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// syncRecent saves everything the upstream API reports for yesterday and
+// today.
+func syncRecent(ctx context.Context) (int, error) {
+	now := time.Now()
+	return syncRange(ctx, now.AddDate(0, 0, -1).Format("2006-01-02"), now.Format("2006-01-02"))
+}
+
+// syncRange saves every feature the upstream JSON API reports between start
+// and end, paging until a short page is returned.
+func syncRange(ctx context.Context, start, end string) (int, error) {
+	saved := 0
+	for offset := 0; ; offset += syncBatchSize {
+		if err := ctx.Err(); err != nil {
+			return saved, err
+		}
+
+		data, err := fetchPage(ctx, start, end, offset, syncBatchSize, UpstreamFilter{})
+		if err != nil {
+			return saved, err
+		}
+
+		result, err := complaintStore.InsertFeatures(ctx, data.Features)
+		if err != nil {
+			return saved, err
+		}
+		saved += result.Inserted + result.Updated
+		if err := result.Err(); err != nil {
+			return saved, fmt.Errorf("offset %d: %w", offset, err)
+		}
+
+		if len(data.Features) < syncBatchSize {
+			return saved, nil
+		}
+	}
+}
+
+// syncRangeCSV is syncRange for the upstream CSV API.
+func syncRangeCSV(ctx context.Context, start, end string) (int, error) {
+	saved := 0
+	for offset := 0; ; offset += syncBatchSize {
+		if err := ctx.Err(); err != nil {
+			return saved, err
+		}
+
+		csvData, err := fetchCSVPage(ctx, start, end, offset, syncBatchSize, "", "", "", "", UpstreamFilter{})
+		if err != nil {
+			return saved, err
+		}
+
+		complaints, skipped, err := convertCSVToComplaints(strings.NewReader(csvData))
+		if err != nil {
+			return saved, err
+		}
+		if skipped > 0 {
+			slog.Warn("Skipped malformed CSV rows", "offset", offset, "skipped", skipped)
+		}
+
+		// Skipped rows still came from the upstream page, so they count
+		// towards telling a full page from the last one.
+		if len(complaints) > 0 {
+			result, err := complaintStore.InsertComplaints(ctx, complaints)
+			if err != nil {
+				return saved, err
+			}
+			saved += result.Inserted
+			if err := result.Err(); err != nil {
+				return saved, fmt.Errorf("offset %d: %w", offset, err)
+			}
+		}
+
+		if len(complaints)+skipped < syncBatchSize {
+			return saved, nil
+		}
+	}
+}
+
+// maxIngestRanges caps how many ranges one /saveToMongoDB/range request
+// may backfill.
+const maxIngestRanges = 12
+
+type DateRange struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+type RangeResult struct {
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Inserted int    `json:"inserted"`
+	Error    string `json:"error"`
+}
+
+// ingestRanges runs syncRange over each range in turn. A failed range is
+// recorded and the next one still runs.
+func ingestRanges(ctx context.Context, ranges []DateRange) []RangeResult {
+	results := make([]RangeResult, 0, len(ranges))
+	for _, rng := range ranges {
+		saved, err := syncRange(ctx, rng.Start, rng.End)
+		result := RangeResult{Start: rng.Start, End: rng.End, Inserted: saved}
+		if err != nil {
+			slog.Error("Failed to ingest range", "start", rng.Start, "end", rng.End, "error", err)
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results
+}
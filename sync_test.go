@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestSyncRangeCSVSkippedRows(t *testing.T) {
+	tests := []struct {
+		name      string
+		badRows   int
+		wantSaved int
+	}{
+		{"one bad row", 1, syncBatchSize - 1 + 5},
+		{"all bad rows", syncBatchSize, 5},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// The first page is full but holds badRows rows with a missing
+			// column; the second page has 5 good rows.
+			var offsets []int
+			useUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+				offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+				offsets = append(offsets, offset)
+
+				fmt.Fprintln(w, "ticket_id,state")
+				rows := 5
+				if offset == 0 {
+					rows = syncBatchSize
+				}
+				for i := 0; i < rows; i++ {
+					if offset == 0 && i < tc.badRows {
+						fmt.Fprintf(w, "T%d-%d\n", offset, i)
+					} else {
+						fmt.Fprintf(w, "T%d-%d,finish\n", offset, i)
+					}
+				}
+			})
+			store := useStore(t)
+
+			saved, err := syncRangeCSV(context.Background(), "2024-01-01", "2024-01-31")
+			if err != nil {
+				t.Fatalf("syncRangeCSV: %v", err)
+			}
+			if saved != tc.wantSaved {
+				t.Errorf("saved %d, want %d", saved, tc.wantSaved)
+			}
+			if len(offsets) != 2 {
+				t.Errorf("fetched offsets %v, want the second page after the full first one", offsets)
+			}
+			if n, _ := store.CountComplaints(context.Background(), ComplaintFilter{}); n != int64(tc.wantSaved) {
+				t.Errorf("store holds %d complaints, want %d", n, tc.wantSaved)
+			}
+		})
+	}
+}
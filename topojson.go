@@ -0,0 +1,64 @@
+package main
+
+import "log/slog"
+
+// Minimal TopoJSON (https://github.com/topojson/topojson-specification)
+// encoder. Complaints are points, which TopoJSON stores inline rather than as
+// arcs, so the arcs array is always present but empty and no transform or
+// quantization is applied.
+
+type Topology struct {
+	Type    string                            `json:"type"`
+	BBox    []float64                         `json:"bbox,omitempty"`
+	Objects map[string]TopoGeometryCollection `json:"objects"`
+	Arcs    [][][2]float64                    `json:"arcs"`
+}
+
+type TopoGeometryCollection struct {
+	Type       string         `json:"type"`
+	Geometries []TopoGeometry `json:"geometries"`
+}
+
+type TopoGeometry struct {
+	Type        string            `json:"type"`
+	ID          string            `json:"id,omitempty"`
+	Coordinates [2]float64        `json:"coordinates"`
+	Properties  map[string]string `json:"properties,omitempty"`
+}
+
+// encodeTopology converts complaints into a Topology with a single
+// "complaints" GeometryCollection. Complaints without parseable coords are
+// skipped.
+func encodeTopology(complaints []Complaint) Topology {
+	collection := TopoGeometryCollection{Type: "GeometryCollection", Geometries: []TopoGeometry{}}
+	var bbox []float64
+
+	for _, c := range complaints {
+		lng, lat, ok := complaintLngLat(c.Coords)
+		if !ok {
+			slog.Warn("Skipping complaint with malformed coords", "ticket_id", c.TicketID, "coords", c.Coords)
+			continue
+		}
+
+		collection.Geometries = append(collection.Geometries, TopoGeometry{
+			Type:        "Point",
+			ID:          c.TicketID,
+			Coordinates: [2]float64{lng, lat},
+			Properties: map[string]string{
+				"type":      c.Type,
+				"state":     c.State,
+				"district":  c.District,
+				"address":   c.Address,
+				"timestamp": c.Timestamp,
+			},
+		})
+		bbox = extendBBox(bbox, []float64{lng, lat})
+	}
+
+	return Topology{
+		Type:    "Topology",
+		BBox:    bbox,
+		Objects: map[string]TopoGeometryCollection{"complaints": collection},
+		Arcs:    [][][2]float64{},
+	}
+}
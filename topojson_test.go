@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"testing"
+)
+
+// refGeometry is a geometry decoded by parseTopology, with its arcs
+// resolved to positions.
+type refGeometry struct {
+	Type        string
+	ID          string
+	Properties  map[string]any
+	Coordinates any
+}
+
+// parseTopology is a reference TopoJSON reader written against the
+// specification, independent of encodeTopology. It handles untransformed
+// topologies only, which is all encodeTopology produces.
+func parseTopology(data []byte) (map[string][]refGeometry, error) {
+	var topology struct {
+		Type      string                     `json:"type"`
+		BBox      []float64                  `json:"bbox"`
+		Transform json.RawMessage            `json:"transform"`
+		Objects   map[string]json.RawMessage `json:"objects"`
+		Arcs      *[][][]float64             `json:"arcs"`
+	}
+	if err := json.Unmarshal(data, &topology); err != nil {
+		return nil, err
+	}
+	if topology.Type != "Topology" {
+		return nil, fmt.Errorf("type %q, want Topology", topology.Type)
+	}
+	if topology.Objects == nil {
+		return nil, fmt.Errorf("objects is missing")
+	}
+	if topology.Arcs == nil {
+		return nil, fmt.Errorf("arcs is missing")
+	}
+	if topology.Transform != nil {
+		return nil, fmt.Errorf("transformed topologies are not supported")
+	}
+	if topology.BBox != nil && len(topology.BBox)%2 != 0 {
+		return nil, fmt.Errorf("bbox has %d values", len(topology.BBox))
+	}
+	arcs := *topology.Arcs
+
+	arc := func(index int) ([][]float64, error) {
+		reversed := index < 0
+		if reversed {
+			index = ^index
+		}
+		if index >= len(arcs) {
+			return nil, fmt.Errorf("arc %d out of range", index)
+		}
+		positions := slices.Clone(arcs[index])
+		if reversed {
+			slices.Reverse(positions)
+		}
+		return positions, nil
+	}
+	line := func(indexes []int) ([][]float64, error) {
+		var positions [][]float64
+		for i, index := range indexes {
+			a, err := arc(index)
+			if err != nil {
+				return nil, err
+			}
+			// Consecutive arcs share their joining position.
+			if i > 0 {
+				a = a[1:]
+			}
+			positions = append(positions, a...)
+		}
+		return positions, nil
+	}
+	position := func(raw json.RawMessage) ([]float64, error) {
+		var p []float64
+		if err := json.Unmarshal(raw, &p); err != nil {
+			return nil, err
+		}
+		if len(p) < 2 {
+			return nil, fmt.Errorf("position %v has fewer than two values", p)
+		}
+		return p, nil
+	}
+
+	var decode func(raw json.RawMessage) ([]refGeometry, error)
+	decode = func(raw json.RawMessage) ([]refGeometry, error) {
+		var g struct {
+			Type        string            `json:"type"`
+			ID          any               `json:"id"`
+			Properties  map[string]any    `json:"properties"`
+			Coordinates json.RawMessage   `json:"coordinates"`
+			Arcs        json.RawMessage   `json:"arcs"`
+			Geometries  []json.RawMessage `json:"geometries"`
+		}
+		if err := json.Unmarshal(raw, &g); err != nil {
+			return nil, err
+		}
+		geometry := refGeometry{Type: g.Type, Properties: g.Properties}
+		if g.ID != nil {
+			geometry.ID = fmt.Sprint(g.ID)
+		}
+
+		var err error
+		switch g.Type {
+		case "GeometryCollection":
+			var all []refGeometry
+			for _, member := range g.Geometries {
+				decoded, err := decode(member)
+				if err != nil {
+					return nil, err
+				}
+				all = append(all, decoded...)
+			}
+			return all, nil
+		case "Point":
+			geometry.Coordinates, err = position(g.Coordinates)
+		case "MultiPoint":
+			var raws []json.RawMessage
+			if err = json.Unmarshal(g.Coordinates, &raws); err == nil {
+				var points [][]float64
+				for _, r := range raws {
+					p, perr := position(r)
+					if perr != nil {
+						return nil, perr
+					}
+					points = append(points, p)
+				}
+				geometry.Coordinates = points
+			}
+		case "LineString":
+			var indexes []int
+			if err = json.Unmarshal(g.Arcs, &indexes); err == nil {
+				geometry.Coordinates, err = line(indexes)
+			}
+		case "Polygon", "MultiLineString":
+			var rings [][]int
+			if err = json.Unmarshal(g.Arcs, &rings); err == nil {
+				var lines [][][]float64
+				for _, ring := range rings {
+					l, lerr := line(ring)
+					if lerr != nil {
+						return nil, lerr
+					}
+					lines = append(lines, l)
+				}
+				geometry.Coordinates = lines
+			}
+		case "":
+			err = fmt.Errorf("geometry without a type")
+		default:
+			err = fmt.Errorf("unsupported geometry type %q", g.Type)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return []refGeometry{geometry}, nil
+	}
+
+	objects := map[string][]refGeometry{}
+	for name, raw := range topology.Objects {
+		geometries, err := decode(raw)
+		if err != nil {
+			return nil, fmt.Errorf("object %s: %w", name, err)
+		}
+		objects[name] = geometries
+	}
+	return objects, nil
+}
+
+func TestParseTopologySpecExample(t *testing.T) {
+	data, err := os.ReadFile("testdata/topojson_spec_example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects, err := parseTopology(data)
+	if err != nil {
+		t.Fatalf("parseTopology: %v", err)
+	}
+
+	got := fmt.Sprint(objects["example"])
+	want := fmt.Sprint([]refGeometry{
+		{Type: "Point", Properties: map[string]any{"prop0": "value0"}, Coordinates: []float64{102, 0.5}},
+		{Type: "LineString", Properties: map[string]any{"prop0": "value0", "prop1": 0.0}, Coordinates: [][]float64{{102, 0}, {103, 1}, {104, 0}, {105, 1}}},
+		{Type: "Polygon", Properties: map[string]any{"prop0": "value0", "prop1": map[string]any{"this": "that"}}, Coordinates: [][][]float64{{{100, 0}, {100, 1}, {101, 1}, {101, 0}, {100, 0}}}},
+	})
+	if got != want {
+		t.Errorf("spec example decoded as\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestEncodeTopologyParses(t *testing.T) {
+	complaints := []Complaint{
+		{TicketID: "T1", Coords: "100.5,13.7", State: "finish", District: "บางรัก"},
+		{TicketID: "bad", Coords: "not coords"},
+		{TicketID: "T2", Coords: "100.6,13.9", State: "start"},
+		{TicketID: "empty"},
+	}
+	data, err := json.Marshal(encodeTopology(complaints))
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	objects, err := parseTopology(data)
+	if err != nil {
+		t.Fatalf("parseTopology: %v\n%s", err, data)
+	}
+	geometries := objects["complaints"]
+	if len(objects) != 1 || len(geometries) != 2 {
+		t.Fatalf("objects = %+v, want one complaints collection with the 2 parseable complaints", objects)
+	}
+
+	want := []struct {
+		id, state string
+		coords    []float64
+	}{
+		{"T1", "finish", []float64{100.5, 13.7}},
+		{"T2", "start", []float64{100.6, 13.9}},
+	}
+	for i, g := range geometries {
+		w := want[i]
+		if g.Type != "Point" || g.ID != w.id || g.Properties["state"] != w.state || !slices.Equal(g.Coordinates.([]float64), w.coords) {
+			t.Errorf("geometry %d = %+v, want a %s point at %v", i, g, w.id, w.coords)
+		}
+	}
+	if geometries[0].Properties["district"] != "บางรัก" {
+		t.Errorf("T1 properties = %v, want the district kept", geometries[0].Properties)
+	}
+
+	var topology Topology
+	if err := json.Unmarshal(data, &topology); err != nil {
+		t.Fatalf("decode Topology: %v", err)
+	}
+	if want := []float64{100.5, 13.7, 100.6, 13.9}; !slices.Equal(topology.BBox, want) {
+		t.Errorf("bbox = %v, want %v", topology.BBox, want)
+	}
+}
+
+func TestTopoJSONValidEndpointParses(t *testing.T) {
+	useUpstream(t, pagedUpstream(3))
+	r := newTestRouter(t, Config{})
+
+	for _, target := range []string{"/api/v1/topojson/valid?offset=0&limit=10", "/api/v1/topojson?format=topojson&offset=0&limit=10"} {
+		w := serve(r, http.MethodGet, target, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: status = %d, want 200: %s", target, w.Code, w.Body)
+		}
+		objects, err := parseTopology(w.Body.Bytes())
+		if err != nil {
+			t.Fatalf("GET %s: parseTopology: %v\n%s", target, err, w.Body)
+		}
+		if n := len(objects["complaints"]); n != 3 {
+			t.Errorf("GET %s: %d geometries, want 3", target, n)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// translations.json holds the romanized names of the Bangkok districts and
+// the provinces around Bangkok, keyed by their Thai names.
+//
+//go:embed translations.json
+var translationsJSON []byte
+
+var romanizedNames = loadRomanizedNames()
+
+func loadRomanizedNames() map[string]string {
+	var t struct {
+		Districts map[string]string `json:"districts"`
+		Provinces map[string]string `json:"provinces"`
+	}
+	if err := json.Unmarshal(translationsJSON, &t); err != nil {
+		panic("invalid translations.json: " + err.Error())
+	}
+
+	names := make(map[string]string, len(t.Districts)+len(t.Provinces))
+	for thai, en := range t.Districts {
+		names[thai] = en
+	}
+	for thai, en := range t.Provinces {
+		names[thai] = en
+	}
+	return names
+}
+
+// Transliterate returns the romanized name of a Thai district or province,
+// ignoring a leading "เขต" or "จังหวัด". It returns "" for names it does not
+// know.
+func Transliterate(thai string) string {
+	name := strings.TrimSpace(thai)
+	name = strings.TrimPrefix(name, "เขต")
+	name = strings.TrimPrefix(name, "จังหวัด")
+	return romanizedNames[strings.TrimSpace(name)]
+}
+
+// parseLang reads the optional lang query parameter and reports whether
+// English names were requested. It writes a 400 response and returns false
+// as its second value for anything but th or en.
+func parseLang(c *gin.Context) (bool, bool) {
+	switch c.Query("lang") {
+	case "", "th":
+		return false, true
+	case "en":
+		return true, true
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "lang must be th or en"})
+		return false, false
+	}
+}
+
+func romanizeFeatures(features []Feature) {
+	for i := range features {
+		p := &features[i].Properties
+		p.DistrictEn = Transliterate(p.District)
+		p.ProvinceEn = Transliterate(p.Province)
+	}
+}
+
+func romanizeComplaints(complaints []Complaint) {
+	for i := range complaints {
+		complaints[i].DistrictEn = Transliterate(complaints[i].District)
+		complaints[i].ProvinceEn = Transliterate(complaints[i].Province)
+	}
+}
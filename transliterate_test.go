@@ -0,0 +1,103 @@
+package main
+
+import "testing"
+
+// bangkokDistricts lists all 50 Bangkok districts with their romanized
+// names, independently of translations.json.
+var bangkokDistricts = map[string]string{
+	"พระนคร":  "Phra Nakhon",
+	"ดุสิต":   "Dusit",
+	"หนองจอก": "Nong Chok",
+	"บางรัก":  "Bang Rak",
+	"บางเขน":  "Bang Khen",
+	"บางกะปิ": "Bang Kapi",
+	"ปทุมวัน": "Pathum Wan",
+	"ป้อมปราบศัตรูพ่าย": "Pom Prap Sattru Phai",
+	"พระโขนง":           "Phra Khanong",
+	"มีนบุรี":           "Min Buri",
+	"ลาดกระบัง":         "Lat Krabang",
+	"ยานนาวา":           "Yan Nawa",
+	"สัมพันธวงศ์":       "Samphanthawong",
+	"พญาไท":             "Phaya Thai",
+	"ธนบุรี":            "Thon Buri",
+	"บางกอกใหญ่":        "Bangkok Yai",
+	"ห้วยขวาง":          "Huai Khwang",
+	"คลองสาน":           "Khlong San",
+	"ตลิ่งชัน":          "Taling Chan",
+	"บางกอกน้อย":        "Bangkok Noi",
+	"บางขุนเทียน":       "Bang Khun Thian",
+	"ภาษีเจริญ":         "Phasi Charoen",
+	"หนองแขม":           "Nong Khaem",
+	"ราษฎร์บูรณะ":       "Rat Burana",
+	"บางพลัด":           "Bang Phlat",
+	"ดินแดง":            "Din Daeng",
+	"บึงกุ่ม":           "Bueng Kum",
+	"สาทร":              "Sathon",
+	"บางซื่อ":           "Bang Sue",
+	"จตุจักร":           "Chatuchak",
+	"บางคอแหลม":         "Bang Kho Laem",
+	"ประเวศ":            "Prawet",
+	"คลองเตย":           "Khlong Toei",
+	"สวนหลวง":           "Suan Luang",
+	"จอมทอง":            "Chom Thong",
+	"ดอนเมือง":          "Don Mueang",
+	"ราชเทวี":           "Ratchathewi",
+	"ลาดพร้าว":          "Lat Phrao",
+	"วัฒนา":             "Watthana",
+	"บางแค":             "Bang Khae",
+	"หลักสี่":           "Lak Si",
+	"สายไหม":            "Sai Mai",
+	"คันนายาว":          "Khan Na Yao",
+	"สะพานสูง":          "Saphan Sung",
+	"วังทองหลาง":        "Wang Thonglang",
+	"คลองสามวา":         "Khlong Sam Wa",
+	"บางนา":             "Bang Na",
+	"ทวีวัฒนา":          "Thawi Watthana",
+	"ทุ่งครุ":           "Thung Khru",
+	"บางบอน":            "Bang Bon",
+}
+
+func TestTransliterateBangkokDistricts(t *testing.T) {
+	if len(bangkokDistricts) != 50 {
+		t.Fatalf("table lists %d districts, want 50", len(bangkokDistricts))
+	}
+
+	seen := map[string]string{}
+	for thai, want := range bangkokDistricts {
+		for _, name := range []string{thai, "เขต" + thai, " เขต" + thai + " "} {
+			if got := Transliterate(name); got != want {
+				t.Errorf("Transliterate(%q) = %q, want %q", name, got, want)
+			}
+		}
+		if other, ok := seen[want]; ok {
+			t.Errorf("%s and %s both romanize to %q", other, thai, want)
+		}
+		seen[want] = thai
+	}
+}
+
+func TestTransliterateProvinces(t *testing.T) {
+	tests := map[string]string{
+		"กรุงเทพมหานคร":   "Bangkok",
+		"นนทบุรี":         "Nonthaburi",
+		"ปทุมธานี":        "Pathum Thani",
+		"สมุทรปราการ":     "Samut Prakan",
+		"สมุทรสาคร":       "Samut Sakhon",
+		"นครปฐม":          "Nakhon Pathom",
+		"ฉะเชิงเทรา":      "Chachoengsao",
+		"พระนครศรีอยุธยา": "Phra Nakhon Si Ayutthaya",
+	}
+	for thai, want := range tests {
+		for _, name := range []string{thai, "จังหวัด" + thai} {
+			if got := Transliterate(name); got != want {
+				t.Errorf("Transliterate(%q) = %q, want %q", name, got, want)
+			}
+		}
+	}
+
+	for _, unknown := range []string{"", "Bangkok", "เชียงใหม่"} {
+		if got := Transliterate(unknown); got != "" {
+			t.Errorf("Transliterate(%q) = %q, want \"\"", unknown, got)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const webhookTimeout = 10 * time.Second
+
+// SyncEvent is the payload POSTed to WEBHOOK_URL after data is ingested.
+type SyncEvent struct {
+	Event     string    `json:"event"`
+	Inserted  int       `json:"inserted"`
+	Start     string    `json:"start"`
+	End       string    `json:"end"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// WebhookNotifier tells a downstream service that new complaint data is
+// available. A nil notifier does nothing, so callers need not check whether
+// a webhook is configured.
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookNotifier returns nil when url is empty.
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	if url == "" {
+		return nil
+	}
+	return &WebhookNotifier{url: url, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, event SyncEvent) error {
+	if n == nil {
+		return nil
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// NotifyAsync sends event in the background so the caller's response is not
+// held up by a slow receiver. Failures are logged.
+func (n *WebhookNotifier) NotifyAsync(event SyncEvent) {
+	if n == nil {
+		return
+	}
+	go func() {
+		if err := n.Notify(context.Background(), event); err != nil {
+			slog.Error("Failed to send webhook", "event", event.Event, "error", err)
+		}
+	}()
+}
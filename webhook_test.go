@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookNotify(t *testing.T) {
+	var gotType string
+	var got SyncEvent
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotType = r.Header.Get("Content-Type")
+		if r.Method != http.MethodPost {
+			t.Errorf("webhook sent %s, want POST", r.Method)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode payload: %v", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	event := SyncEvent{
+		Event:     "sync_complete",
+		Inserted:  42,
+		Start:     "2024-01-01",
+		End:       "2024-01-31",
+		Timestamp: time.Date(2024, 2, 1, 8, 0, 0, 0, time.UTC),
+	}
+	if err := NewWebhookNotifier(receiver.URL).Notify(context.Background(), event); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if gotType != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", gotType)
+	}
+	if got != event {
+		t.Errorf("payload = %+v, want %+v", got, event)
+	}
+}
+
+func TestWebhookNotifyErrorStatus(t *testing.T) {
+	for _, status := range []int{http.StatusMovedPermanently, http.StatusBadRequest, http.StatusInternalServerError} {
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}))
+		err := NewWebhookNotifier(receiver.URL).Notify(context.Background(), SyncEvent{Event: "sync_complete"})
+		receiver.Close()
+		if err == nil {
+			t.Errorf("receiver answering %d: Notify returned nil, want an error", status)
+		}
+	}
+}
+
+func TestWebhookNotifierDisabled(t *testing.T) {
+	n := NewWebhookNotifier("")
+	if n != nil {
+		t.Fatalf("NewWebhookNotifier(\"\") = %+v, want nil", n)
+	}
+	if err := n.Notify(context.Background(), SyncEvent{}); err != nil {
+		t.Errorf("nil notifier: Notify = %v, want nil", err)
+	}
+}